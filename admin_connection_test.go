@@ -0,0 +1,228 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestReuseAdminConnection verifies that the admin connection is opened
+// once, reused across operations and closed by Cleanup.
+func TestReuseAdminConnection(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &closeCountingProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:   provider,
+		MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:         "reuse_admin_template",
+		ReuseAdminConnection: true,
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	for i := 0; i < 3; i++ {
+		conn, testDBName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		if i == 0 {
+			c.Assert(tm.DropTestDatabase(ctx, testDBName), qt.IsNil)
+		}
+	}
+
+	// A single admin connection is held open.
+	opened, closed := provider.counts("postgres")
+	c.Assert(opened, qt.Equals, 1)
+	c.Assert(closed, qt.Equals, 0)
+
+	// Cleanup closes the held connection.
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.IsNil)
+	opened, closed = provider.counts("postgres")
+	c.Assert(opened, qt.Equals, 1)
+	c.Assert(closed, qt.Equals, 1)
+
+	// The manager is usable again with a new admin connection.
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.IsNil)
+	opened, closed = provider.counts("postgres")
+	c.Assert(opened, qt.Equals, 2)
+	c.Assert(closed, qt.Equals, 2)
+}
+
+// TestReuseAdminConnectionConcurrently verifies that concurrent operations
+// take turns using the reused admin connection.
+func TestReuseAdminConnectionConcurrently(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &closeCountingProvider{ConnectionProvider: &exclusiveConnectionProvider{ConnectionProvider: connProvider}}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:   provider,
+		MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:         "reuse_admin_concurrent_template",
+		ReuseAdminConnection: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	const goroutines = 8
+	var wg sync.WaitGroup
+	errs := make([]error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, testDBName, err := tm.CreateTestDatabase(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if err := conn.Close(); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = tm.DropTestDatabase(ctx, testDBName)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		c.Assert(err, qt.IsNil)
+	}
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	opened, closed := provider.counts("postgres")
+	c.Assert(opened, qt.Equals, 1)
+	c.Assert(closed, qt.Equals, 1)
+}
+
+// TestFreshAdminConnections verifies that without ReuseAdminConnection
+// every operation opens and closes its own admin connection.
+func TestFreshAdminConnections(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &closeCountingProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "fresh_admin_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+
+	opened, closed := provider.counts("postgres")
+	c.Assert(opened, qt.Equals, 3)
+	c.Assert(closed, qt.Equals, 3)
+}
+
+// BenchmarkCreateTestDatabaseAdminConnection compares creating test databases
+// with fresh and reused admin connections, given a provider with
+// a noticeable connect overhead.
+func BenchmarkCreateTestDatabaseAdminConnection(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reuse=%t", reuse), func(b *testing.B) {
+			ctx := context.Background()
+			provider := &closeCountingProvider{
+				ConnectionProvider: setupTestConnectionProvider(),
+				connectDelay:       100 * time.Microsecond,
+			}
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider:   provider,
+				MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:         "bench_admin_template",
+				ReuseAdminConnection: reuse,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := tm.Initialize(ctx); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := tm.CreateTestDatabase(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			opened, _ := provider.counts("postgres")
+			b.ReportMetric(float64(opened)/float64(b.N), "admin-connects/op")
+			if err := tm.Cleanup(ctx); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+// closeCountingProvider wraps a ConnectionProvider and counts opened
+// and closed connections per database.
+type closeCountingProvider struct {
+	pgdbtemplate.ConnectionProvider
+	connectDelay time.Duration
+
+	mu     sync.Mutex
+	opened map[string]int
+	closed map[string]int
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *closeCountingProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	time.Sleep(p.connectDelay)
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.opened == nil {
+		p.opened = map[string]int{}
+		p.closed = map[string]int{}
+	}
+	p.opened[databaseName]++
+	return &closeCountingConnection{DatabaseConnection: conn, provider: p, dbName: databaseName}, nil
+}
+
+// counts returns the number of opened and closed connections to the database.
+func (p *closeCountingProvider) counts(databaseName string) (opened, closed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.opened[databaseName], p.closed[databaseName]
+}
+
+// closeCountingConnection reports its Close calls to its provider.
+type closeCountingConnection struct {
+	pgdbtemplate.DatabaseConnection
+	provider *closeCountingProvider
+	dbName   string
+}
+
+// Close implements pgdbtemplate.DatabaseConnection.Close.
+func (c *closeCountingConnection) Close() error {
+	c.provider.mu.Lock()
+	c.provider.closed[c.dbName]++
+	c.provider.mu.Unlock()
+	return c.DatabaseConnection.Close()
+}
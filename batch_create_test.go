@@ -0,0 +1,204 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestCreateTestDatabases tests creating several test databases at once.
+func TestCreateTestDatabases(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	for _, concurrency := range []int{0, 3} {
+		c.Run(fmt.Sprintf("concurrency=%d", concurrency), func(c *qt.C) {
+			connProvider := setupTestConnectionProvider()
+			provider := &closeCountingProvider{ConnectionProvider: &exclusiveConnectionProvider{ConnectionProvider: connProvider}}
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider:     provider,
+				MigrationRunner:        &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:           fmt.Sprintf("batch_template_%d", concurrency),
+				TestDBPrefix:           "batch_test_",
+				BatchCreateConcurrency: concurrency,
+			})
+			c.Assert(err, qt.IsNil)
+			c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+			conns, dbNames, err := tm.CreateTestDatabases(ctx, 8)
+			c.Assert(err, qt.IsNil)
+			c.Assert(conns, qt.HasLen, 8)
+			c.Assert(dbNames, qt.HasLen, 8)
+
+			seen := map[string]bool{}
+			for i, dbName := range dbNames {
+				c.Assert(conns[i], qt.IsNotNil)
+				c.Assert(strings.HasPrefix(dbName, "batch_test_"), qt.IsTrue)
+				c.Assert(seen[dbName], qt.IsFalse)
+				seen[dbName] = true
+				c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsTrue)
+			}
+
+			// An admin connection was used by every concurrent worker,
+			// next to the one used by Initialize, and all were closed.
+			workers := concurrency
+			if workers == 0 {
+				workers = 1
+			}
+			opened, closed := provider.counts("postgres")
+			c.Assert(opened, qt.Equals, 1+workers)
+			c.Assert(closed, qt.Equals, opened)
+
+			// All databases are tracked and dropped by Cleanup.
+			c.Assert(tm.Cleanup(ctx), qt.IsNil)
+			for _, dbName := range dbNames {
+				c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+			}
+		})
+	}
+
+	c.Run("Zero databases", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: setupTestConnectionProvider(),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.IsNil)
+
+		conns, dbNames, err := tm.CreateTestDatabases(ctx, 0)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conns, qt.HasLen, 0)
+		c.Assert(dbNames, qt.HasLen, 0)
+	})
+}
+
+// TestCreateTestDatabasesPartialFailure tests that all databases created
+// by a failing batch are dropped.
+func TestCreateTestDatabasesPartialFailure(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	var mu sync.Mutex
+	var connected []string
+	provider := &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		// Fail connecting to test databases after three of them.
+		beforeConnect: func(_ context.Context, databaseName string) error {
+			if !strings.HasPrefix(databaseName, "batch_fail_test_") {
+				return nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			connected = append(connected, databaseName)
+			if len(connected) > 3 {
+				return fmt.Errorf("intentional connection failure for %s", databaseName)
+			}
+			return nil
+		},
+	}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:     provider,
+		MigrationRunner:        &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:           "batch_fail_template",
+		TestDBPrefix:           "batch_fail_test_",
+		BatchCreateConcurrency: 2,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	conns, dbNames, err := tm.CreateTestDatabases(ctx, 5)
+	c.Assert(err, qt.ErrorMatches, "(?s).*failed to connect to test database.*intentional connection failure.*")
+	c.Assert(conns, qt.IsNil)
+	c.Assert(dbNames, qt.IsNil)
+
+	// No database of the batch is left behind.
+	for _, dbName := range connected {
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+	}
+	c.Assert(connected, qt.HasLen, 5)
+
+	// Nothing is tracked, so Cleanup only drops the template.
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+// TestCreateTestDatabasesWorkerConnectFailure tests that a batch succeeds
+// if the admin connections of additional workers cannot be opened.
+func TestCreateTestDatabasesWorkerConnectFailure(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	var adminConnects atomic.Int32
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeConnect: func(ctx context.Context, databaseName string) error {
+				// Initialize and the batch get a connection, the workers don't.
+				if databaseName == "postgres" && adminConnects.Add(1) > 2 {
+					return fmt.Errorf("too many connections")
+				}
+				return nil
+			},
+		},
+		MigrationRunner:        &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:           "batch_worker_connect_template",
+		TestDBPrefix:           "batch_worker_connect_test_",
+		BatchCreateConcurrency: 3,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	conns, dbNames, err := tm.CreateTestDatabases(ctx, 5)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conns, qt.HasLen, 5)
+	c.Assert(adminConnects.Load(), qt.Equals, int32(4))
+	for _, dbName := range dbNames {
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsTrue)
+	}
+
+	adminConnects.Store(0)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+// exclusiveConnectionProvider wraps a ConnectionProvider, failing
+// statements executed on a connection which is executing another one.
+type exclusiveConnectionProvider struct {
+	pgdbtemplate.ConnectionProvider
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *exclusiveConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	return &exclusiveConnection{DatabaseConnection: conn}, nil
+}
+
+// exclusiveConnection is the connection of exclusiveConnectionProvider.
+type exclusiveConnection struct {
+	pgdbtemplate.DatabaseConnection
+	executing atomic.Bool
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *exclusiveConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	if !c.executing.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("connection is already executing a statement")
+	}
+	defer c.executing.Store(false)
+	// Make overlapping statements likely.
+	time.Sleep(time.Millisecond)
+	return c.DatabaseConnection.ExecContext(ctx, query, args...)
+}
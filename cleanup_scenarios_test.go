@@ -207,6 +207,63 @@ func TestCreateTemplateDatabaseCleanupOnMigrationFailure(t *testing.T) {
 	c.Assert(err, qt.ErrorIs, sql.ErrNoRows)
 }
 
+// TestKeepOnFailure tests that databases failing to be set up
+// are kept for inspection with KeepOnFailure.
+func TestKeepOnFailure(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+
+	c.Run("Template", func(c *qt.C) {
+		config := pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &failingMigrationRunner{errorMsg: "intentional migration failure"},
+			TemplateName:       "template_kept_on_failure",
+			KeepOnFailure:      true,
+		}
+		tm, err := pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.IsNil)
+
+		err = tm.Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, `failed to create template database: failed to run migrations on template: `+
+			`intentional migration failure; template database "template_kept_on_failure" was kept for inspection`)
+		c.Assert(databaseExists(ctx, connProvider, "template_kept_on_failure"), qt.IsTrue)
+
+		// The kept template is rebuilt with ForceRecreate.
+		config.MigrationRunner = &pgdbtemplate.NoOpMigrationRunner{}
+		config.ForceRecreate = true
+		tm, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(ctx, connProvider, "template_kept_on_failure"), qt.IsFalse)
+	})
+
+	c.Run("Test database", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "template_keeping_test_dbs",
+			KeepOnFailure:      true,
+			OnTestDatabaseCreated: func(ctx context.Context, conn pgdbtemplate.DatabaseConnection, name string) error {
+				return fmt.Errorf("intentional hook failure")
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		_, _, err = tm.CreateTestDatabase(ctx, "kept_test_db")
+		c.Assert(err, qt.ErrorMatches, `OnTestDatabaseCreated failed for test database "kept_test_db": `+
+			`intentional hook failure; test database "kept_test_db" was kept for inspection`)
+
+		// The kept test database is not dropped by Cleanup.
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(ctx, connProvider, "kept_test_db"), qt.IsTrue)
+	})
+}
+
 // TestCreateTemplateDatabaseCleanupOnMarkTemplateFailure tests that a template
 // database is dropped if it's created and migrations succeed but marking as
 // template fails.
@@ -326,6 +383,10 @@ func createRealConnectionProvider() pgdbtemplate.ConnectionProvider {
 			"template0": true,
 			"template1": true,
 		},
+		templates: map[string]bool{
+			"template0": true,
+			"template1": true,
+		},
 	}
 }
 
@@ -471,7 +532,10 @@ func (r *failingMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtem
 // cleanupMockConnectionProvider is a mock implementation of ConnectionProvider for testing.
 type cleanupMockConnectionProvider struct {
 	databases map[string]bool
+	templates map[string]bool
 	mu        sync.RWMutex
+
+	advisoryLocks sync.Map
 }
 
 // getDatabases implements databaseProvider.getDatabases.
@@ -479,11 +543,21 @@ func (m *cleanupMockConnectionProvider) getDatabases() map[string]bool {
 	return m.databases
 }
 
+// getTemplates implements databaseProvider.getTemplates.
+func (m *cleanupMockConnectionProvider) getTemplates() map[string]bool {
+	return m.templates
+}
+
 // getMutex implements databaseProvider.getMutex.
 func (m *cleanupMockConnectionProvider) getMutex() *sync.RWMutex {
 	return &m.mu
 }
 
+// getAdvisoryLocks implements databaseProvider.getAdvisoryLocks.
+func (m *cleanupMockConnectionProvider) getAdvisoryLocks() *sync.Map {
+	return &m.advisoryLocks
+}
+
 // Connect implements pgdbtemplate.ConnectionProvider.Connect.
 func (m *cleanupMockConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
 	return &sharedMockDatabaseConnection{provider: m, dbName: databaseName}, nil
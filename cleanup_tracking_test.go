@@ -2,9 +2,12 @@ package pgdbtemplate_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -134,6 +137,36 @@ func TestDropTestDatabaseRemovesFromTracking(t *testing.T) {
 	c.Assert(exists, qt.IsFalse)
 }
 
+// TestIsTracked verifies that only test databases created
+// by the manager and not dropped yet are tracked.
+func TestIsTracked(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "is_tracked_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.IsTracked(testDBName), qt.IsTrue)
+
+	// Neither the template nor databases created by others are tracked.
+	c.Assert(tm.IsTracked("is_tracked_template"), qt.IsFalse)
+	c.Assert(tm.IsTracked("postgres"), qt.IsFalse)
+	c.Assert(tm.IsTracked("is_tracked_missing_db"), qt.IsFalse)
+
+	c.Assert(tm.DropTestDatabase(ctx, testDBName), qt.IsNil)
+	c.Assert(tm.IsTracked(testDBName), qt.IsFalse)
+}
+
 // TestCleanupFailureResilience tests that cleanup continues even if some databases fail to drop.
 func TestCleanupFailureResilience(t *testing.T) {
 	t.Parallel()
@@ -178,6 +211,109 @@ func TestCleanupFailureResilience(t *testing.T) {
 	c.Assert(exists, qt.IsFalse)
 }
 
+// TestCleanupWithReport tests that the report of Cleanup
+// reflects both dropped and failed test databases.
+func TestCleanupWithReport(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &hookedConnectionProvider{
+		ConnectionProvider: setupTestConnectionProvider(),
+		beforeExec:         failingDrops("report_failing_db"),
+	}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "report_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	for _, dbName := range []string{"report_db_1", "report_db_2", "report_failing_db"} {
+		conn, _, err := tm.CreateTestDatabase(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+	}
+
+	report, err := tm.CleanupWithReport(ctx)
+	c.Assert(err, qt.ErrorMatches, `failed to clean up tracked test databases: failed to drop database "report_failing_db": intentional drop failure`)
+	c.Assert(report.DroppedTestDatabases, qt.Equals, 2)
+	c.Assert(report.Failures, qt.HasLen, 1)
+	c.Assert(report.Failures[0].Name, qt.Equals, "report_failing_db")
+	c.Assert(report.Failures[0].Err, qt.ErrorMatches, `failed to drop database "report_failing_db": intentional drop failure`)
+	c.Assert(report.TemplateDropped, qt.IsTrue)
+
+	// The failed database is dropped by the next call.
+	provider.beforeExec = nil
+	report, err = tm.CleanupWithReport(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.DeepEquals, pgdbtemplate.CleanupReport{DroppedTestDatabases: 1})
+
+	// Nothing is left to drop.
+	report, err = tm.CleanupWithReport(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(report, qt.DeepEquals, pgdbtemplate.CleanupReport{})
+}
+
+// TestCleanupTerminatesInBatches tests that connections to many test
+// databases are terminated with several queries.
+func TestCleanupTerminatesInBatches(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		batchSize int
+		dbCount   int
+
+		expectedBatches []int
+	}{{
+		name:            "Default batch size",
+		dbCount:         2500,
+		expectedBatches: []int{1000, 1000, 500},
+	}, {
+		name:            "Custom batch size",
+		batchSize:       2,
+		dbCount:         5,
+		expectedBatches: []int{2, 2, 1},
+	}}
+
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			var mu sync.Mutex
+			var batches []int
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: &hookedConnectionProvider{
+					ConnectionProvider: setupTestConnectionProvider(),
+					beforeQueryRow: func(query string) pgdbtemplate.Row {
+						if strings.Contains(query, "pg_terminate_backend") && strings.Contains(query, "'batch_") {
+							mu.Lock()
+							batches = append(batches, strings.Count(query, "'batch_"))
+							mu.Unlock()
+						}
+						return nil
+					},
+				},
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:       "terminate_batches_template",
+				TestDBPrefix:       "batch_",
+				TerminateBatchSize: test.batchSize,
+			})
+			c.Assert(err, qt.IsNil)
+			c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+			for i := 0; i < test.dbCount; i++ {
+				_, _, err := tm.CreateTestDatabase(ctx)
+				c.Assert(err, qt.IsNil)
+			}
+			c.Assert(tm.Cleanup(ctx), qt.IsNil)
+			c.Assert(batches, qt.DeepEquals, test.expectedBatches)
+		})
+	}
+}
+
 // TestConcurrentDatabaseCreationAndCleanup tests
 // concurrent database operations with cleanup.
 func TestConcurrentDatabaseCreationAndCleanup(t *testing.T) {
@@ -255,3 +391,190 @@ func TestConcurrentDatabaseCreationAndCleanup(t *testing.T) {
 	exists := databaseExists(ctx, connProvider, config.TemplateName)
 	c.Assert(exists, qt.IsFalse)
 }
+
+// TestConcurrentCleanup verifies that Cleanup with several workers drops
+// all tracked databases and aggregates the drop errors.
+func TestConcurrentCleanup(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		beforeExec:         failingDrops("concurrent_cleanup_3", "concurrent_cleanup_7"),
+	}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "concurrent_cleanup_template",
+		CleanupConcurrency: 4,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	for i := 0; i < 20; i++ {
+		_, _, err := tm.CreateTestDatabase(ctx, fmt.Sprintf("concurrent_cleanup_%d", i))
+		c.Assert(err, qt.IsNil)
+	}
+
+	// Tracked databases are dropped in no particular order.
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.ErrorMatches, `(?s)failed to clean up tracked test databases: .*`)
+	c.Assert(err.Error(), qt.Contains, `failed to drop database "concurrent_cleanup_3": intentional drop failure`)
+	c.Assert(err.Error(), qt.Contains, `failed to drop database "concurrent_cleanup_7": intentional drop failure`)
+	for i := 0; i < 20; i++ {
+		dbName := fmt.Sprintf("concurrent_cleanup_%d", i)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.Equals, i == 3 || i == 7)
+	}
+	c.Assert(databaseExists(ctx, connProvider, "concurrent_cleanup_template"), qt.IsFalse)
+}
+
+// BenchmarkCleanup compares sequential and concurrent cleanup of tracked
+// test databases, given a provider with a noticeable drop latency.
+func BenchmarkCleanup(b *testing.B) {
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+					ConnectionProvider: &hookedConnectionProvider{
+						ConnectionProvider: setupTestConnectionProvider(),
+						beforeExec: func(_ context.Context, query string) error {
+							if droppedDatabase(query) != "" {
+								time.Sleep(time.Millisecond)
+							}
+							return nil
+						},
+					},
+					MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+					TemplateName:       "bench_cleanup_template",
+					CleanupConcurrency: concurrency,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := tm.Initialize(ctx); err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := tm.CreateTestDatabases(ctx, 50); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err := tm.Cleanup(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestCleanupRetryAfterCancellation tests that a Cleanup interrupted
+// by context cancellation can be finished by calling Cleanup again.
+func TestCleanupRetryAfterCancellation(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	cleanupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var drops int32
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			// Fail once the context is done, like real drivers do.
+			beforeConnect: func(ctx context.Context, databaseName string) error {
+				return ctx.Err()
+			},
+			beforeExec: func(ctx context.Context, query string) error {
+				return ctx.Err()
+			},
+			afterExec: func(query string, err error) {
+				// Cancel the cleanup after the first dropped database.
+				if err == nil && strings.HasPrefix(query, "DROP DATABASE") && atomic.AddInt32(&drops, 1) == 1 {
+					cancel()
+				}
+			},
+		},
+		MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:    "cleanup_retry_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	var dbNames []string
+	for i := 0; i < 3; i++ {
+		conn, dbName, err := tm.CreateTestDatabase(ctx, fmt.Sprintf("cleanup_retry_db_%d", i))
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		dbNames = append(dbNames, dbName)
+	}
+
+	err = tm.Cleanup(cleanupCtx)
+	c.Assert(err, qt.ErrorMatches, `(?s).*cleanup interrupted, call Cleanup again to drop test databases \["cleanup_retry_db_1" "cleanup_retry_db_2"\]: context canceled.*`)
+	c.Assert(errors.Is(err, context.Canceled), qt.IsTrue)
+	c.Assert(databaseExists(ctx, connProvider, "cleanup_retry_db_0"), qt.IsFalse)
+	c.Assert(databaseExists(ctx, connProvider, "cleanup_retry_db_1"), qt.IsTrue)
+	c.Assert(databaseExists(ctx, connProvider, "cleanup_retry_template"), qt.IsTrue)
+
+	// A retry with a fresh context drops the rest.
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	for _, dbName := range append(dbNames, "cleanup_retry_template") {
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse, qt.Commentf("database %s", dbName))
+	}
+}
+
+// TestCleanupUnmarksTemplateTestDatabases tests that Cleanup drops
+// test databases which were marked as templates out-of-band.
+func TestCleanupUnmarksTemplateTestDatabases(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := NewMockConnectionProvider()
+	provider := &recordingConnectionProvider{ConnectionProvider: &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		// Reject to drop databases marked as templates like PostgreSQL does.
+		beforeExec: func(_ context.Context, query string) error {
+			connProvider.getMutex().RLock()
+			defer connProvider.getMutex().RUnlock()
+			if connProvider.getTemplates()[droppedDatabase(query)] {
+				return &mockPgError{code: "42809", message: "cannot drop a template database"}
+			}
+			return nil
+		},
+	}}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "unmark_cleanup_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	conn, dbName, err := tm.CreateTestDatabase(ctx, "unmark_cleanup_db")
+	c.Assert(err, qt.IsNil)
+	_, err = conn.ExecContext(ctx, `ALTER DATABASE "unmark_cleanup_db" WITH is_template TRUE`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+
+	var queries []string
+	for _, query := range provider.recordedQueries() {
+		if strings.Contains(query, "unmark_cleanup_db") {
+			queries = append(queries, query)
+		}
+	}
+	c.Assert(queries, qt.DeepEquals, []string{
+		`CREATE DATABASE "unmark_cleanup_db" TEMPLATE "unmark_cleanup_template"`,
+		`ALTER DATABASE "unmark_cleanup_db" WITH is_template TRUE`,
+		`DROP DATABASE "unmark_cleanup_db"`,
+		`ALTER DATABASE "unmark_cleanup_db" WITH is_template FALSE`,
+		`DROP DATABASE "unmark_cleanup_db"`,
+	})
+}
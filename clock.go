@@ -0,0 +1,19 @@
+package pgdbtemplate
+
+import "time"
+
+// Clock tells the current time to the template manager, which uses it
+// for generated database names and for measuring durations.
+//
+// Implementations must be safe for concurrent use. A fake clock makes
+// generated names predictable in tests, together with
+// ResetCountersForTesting.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// realClock is a Clock implementation using time.Now.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,187 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestClose tests that Close cleans up and closes the provider.
+func TestClose(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Provider with Close", func(c *qt.C) {
+		connProvider := setupTestConnectionProvider()
+		provider := &closableProvider{ConnectionProvider: connProvider}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "close_template",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		_, dbName, err := tm.CreateTestDatabase(ctx, "close_test_db")
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(tm.Close(), qt.IsNil)
+		c.Assert(provider.closes, qt.Equals, 1)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+		c.Assert(databaseExists(ctx, connProvider, "close_template"), qt.IsFalse)
+	})
+
+	c.Run("Concurrent calls", func(c *qt.C) {
+		provider := &closableProvider{ConnectionProvider: setupTestConnectionProvider()}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "close_concurrent_template",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Check(tm.Close(), qt.IsNil)
+			}()
+		}
+		wg.Wait()
+		c.Assert(provider.closes, qt.Equals, 1)
+	})
+
+	c.Run("Provider with Close returning an error", func(c *qt.C) {
+		provider := &closableProvider{ConnectionProvider: setupTestConnectionProvider(), closeErr: errors.New("pool busy")}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		c.Assert(tm.Close(), qt.ErrorMatches, "failed to close connection provider: pool busy")
+		c.Assert(provider.closes, qt.Equals, 1)
+	})
+
+	c.Run("Provider closed even if Cleanup fails", func(c *qt.C) {
+		provider := &closableProvider{ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: setupTestConnectionProvider(),
+			beforeExec:         failingDrops("close_failing_template"),
+		}}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "close_failing_template",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		c.Assert(tm.Close(), qt.ErrorMatches, "failed to drop template database: .*")
+		c.Assert(provider.closes, qt.Equals, 1)
+	})
+
+	c.Run("Provider without Close", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: setupTestConnectionProvider(),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Assert(tm.Close(), qt.IsNil)
+	})
+}
+
+// closableProvider wraps a ConnectionProvider, counting Close calls
+// like a provider owning connection pools.
+type closableProvider struct {
+	pgdbtemplate.ConnectionProvider
+	closeErr error
+
+	closes int
+}
+
+// Close releases the resources of the provider.
+func (p *closableProvider) Close() error {
+	p.closes++
+	return p.closeErr
+}
+
+// TestUseAfterCleanup tests that the manager is closed by Cleanup
+// until it is initialized again.
+func TestUseAfterCleanup(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "after_cleanup_template",
+		AutoInitialize:     true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+
+	c.Run("Create after Cleanup", func(c *qt.C) {
+		// AutoInitialize doesn't reopen the manager.
+		_, _, err := tm.CreateTestDatabase(ctx, "after_cleanup_db")
+		c.Assert(err, qt.ErrorMatches, "template manager is closed: call Initialize to reopen it")
+		c.Assert(errors.Is(err, pgdbtemplate.ErrManagerClosed), qt.IsTrue)
+		_, _, err = tm.CreateTestDatabaseFromTemplate(ctx, "template0", "after_cleanup_db")
+		c.Assert(errors.Is(err, pgdbtemplate.ErrManagerClosed), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(databaseExists(ctx, connProvider, "after_cleanup_db"), qt.IsFalse)
+		c.Assert(databaseExists(ctx, connProvider, "after_cleanup_template"), qt.IsFalse)
+	})
+
+	c.Run("Cleanup again", func(c *qt.C) {
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+
+	c.Run("Initialize again", func(c *qt.C) {
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		conn, dbName, err := tm.CreateTestDatabase(ctx, "after_reinitialize_db")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsTrue)
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+	})
+}
+
+// TestUseAfterClose tests that the manager cannot be used,
+// or initialized again, after Close.
+func TestUseAfterClose(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &closableProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(tm.Close(), qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, "template manager is closed: it cannot be used after Close")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrManagerClosed), qt.IsTrue)
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrManagerClosed), qt.IsTrue, qt.Commentf("got %v", err))
+
+	// Cleanup and Close are safe to call again.
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(tm.Close(), qt.IsNil)
+	c.Assert(provider.closes, qt.Equals, 1)
+}
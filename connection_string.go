@@ -1,7 +1,9 @@
 package pgdbtemplate
 
 import (
+	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -9,10 +11,16 @@ import (
 // in a PostgreSQL connection string.
 //
 // It supports both URL format (postgres://...) and
-// DSN format (host=... dbname=...).
+// DSN format (host=... dbname=...). In DSNs only the dbname value
+// is replaced, and malformed DSNs are returned unchanged.
 func ReplaceDatabaseInConnectionString(connStr, dbName string) string {
 	// Handle postgres:// and postgresql:// URLs.
 	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		// url.Parse rejects or mangles multi-host URLs such as
+		// postgres://h1:5432,h2:5432/postgres, so only replace the path.
+		if isMultiHostURL(connStr) {
+			return replaceURLPath(connStr, dbName)
+		}
 		u, err := url.Parse(connStr)
 		if err != nil {
 			// Fallback to simple replacement if URL parsing fails.
@@ -23,16 +31,21 @@ func ReplaceDatabaseInConnectionString(connStr, dbName string) string {
 	}
 
 	// Handle DSN format (host=localhost user=postgres dbname=postgres ...).
-	if strings.Contains(connStr, "dbname=") {
-		// Simple replacement for DSN format.
-		parts := strings.Fields(connStr)
-		for i, part := range parts {
-			if strings.HasPrefix(part, "dbname=") {
-				parts[i] = "dbname=" + dbName
-				break
+	if isDSN(connStr) {
+		pairs, err := parseDSNPairs(connStr)
+		if err != nil {
+			// Leave a malformed DSN as is, since appending to it
+			// could end up inside an unterminated quoted value.
+			return connStr
+		}
+		// libpq uses the last value of a repeated key.
+		for i := len(pairs) - 1; i >= 0; i-- {
+			if pairs[i].key == "dbname" {
+				return connStr[:pairs[i].start] + quoteDSNValue(dbName) + connStr[pairs[i].end:]
 			}
 		}
-		return strings.Join(parts, " ")
+		// The DSN doesn't specify a database, so add one.
+		return strings.TrimRight(connStr, " \t\n\r\v\f") + " dbname=" + quoteDSNValue(dbName)
 	}
 
 	// Fallback: assume it ends with a database name.
@@ -42,3 +55,349 @@ func ReplaceDatabaseInConnectionString(connStr, dbName string) string {
 	}
 	return connStr + "/" + dbName
 }
+
+// NewConnectionStringFunc returns a function building the connection
+// string of a database from baseConnString, as expected by the
+// connection providers, by replacing its database name with
+// ReplaceDatabaseInConnectionString:
+//
+//	connStringFunc, err := pgdbtemplate.NewConnectionStringFunc(baseConnString)
+//	if err != nil {
+//		return err
+//	}
+//	provider := pgdbtemplatepgx.NewConnectionProvider(connStringFunc)
+//
+// baseConnString is validated with ParseConnectionString, so that
+// a misconfigured connection string fails here rather than on the
+// first connection.
+func NewConnectionStringFunc(baseConnString string) (func(dbName string) string, error) {
+	if _, err := ParseConnectionString(baseConnString); err != nil {
+		return nil, fmt.Errorf("invalid base connection string: %w", err)
+	}
+	return func(dbName string) string {
+		return ReplaceDatabaseInConnectionString(baseConnString, dbName)
+	}, nil
+}
+
+// isDSN reports whether the connection string is in the keyword/value
+// DSN format, i.e. it consists of key=value pairs and is not a URL.
+func isDSN(connStr string) bool {
+	return !strings.Contains(connStr, "://") && strings.Contains(connStr, "=")
+}
+
+// quoteDSNValue quotes a DSN value per libpq rules if it is empty
+// or contains whitespace, single quotes or backslashes: the value is
+// wrapped in single quotes, and single quotes and backslashes in it
+// are escaped with a backslash.
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\r\v\f'\\") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+// isMultiHostURL reports whether the connection URL lists several
+// comma-separated hosts.
+func isMultiHostURL(connStr string) bool {
+	authority := connStr[strings.Index(connStr, "://")+len("://"):]
+	if i := strings.IndexAny(authority, "/?#"); i >= 0 {
+		authority = authority[:i]
+	}
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		authority = authority[i+1:]
+	}
+	return strings.Contains(authority, ",")
+}
+
+// replaceURLPath replaces the path of the connection URL with dbName,
+// leaving the scheme, user info, hosts and query parameters untouched.
+func replaceURLPath(connStr, dbName string) string {
+	authorityStart := strings.Index(connStr, "://") + len("://")
+	authorityEnd := len(connStr)
+	if i := strings.IndexAny(connStr[authorityStart:], "/?#"); i >= 0 {
+		authorityEnd = authorityStart + i
+	}
+
+	suffix := connStr[authorityEnd:]
+	if strings.HasPrefix(suffix, "/") {
+		// Drop the old path, keeping the query and fragment.
+		if i := strings.IndexAny(suffix, "?#"); i >= 0 {
+			suffix = suffix[i:]
+		} else {
+			suffix = ""
+		}
+	}
+	return connStr[:authorityEnd] + "/" + url.PathEscape(dbName) + suffix
+}
+
+// ConnectionInfo holds the components of a PostgreSQL connection string.
+//
+// Multiple hosts and ports are kept comma-separated, as in the libpq
+// keyword/value format, e.g. Host "h1,h2" and Port "5432,5433".
+type ConnectionInfo struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Database string
+	// Params holds all other parameters, such as sslmode.
+	Params map[string]string
+
+	// scheme is the URL scheme, or empty for the DSN format.
+	scheme string
+}
+
+// ParseConnectionString parses a PostgreSQL connection string in either
+// URL format (postgres://...) or DSN format (host=... dbname=...).
+func ParseConnectionString(connStr string) (*ConnectionInfo, error) {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		return parseConnectionURL(connStr)
+	}
+	if connStr == "" || isDSN(connStr) {
+		return parseConnectionDSN(connStr)
+	}
+	return nil, fmt.Errorf("invalid connection string: neither a postgres:// URL nor a DSN")
+}
+
+// String serializes the connection info in the format it was parsed from.
+//
+// Empty components are omitted, and parameters are sorted by key.
+func (ci *ConnectionInfo) String() string {
+	if ci.scheme != "" {
+		return ci.urlString()
+	}
+	return ci.dsnString()
+}
+
+// urlString serializes the connection info in URL format.
+func (ci *ConnectionInfo) urlString() string {
+	u := url.URL{Scheme: ci.scheme}
+	if ci.User != "" || ci.Password != "" {
+		if ci.Password != "" {
+			u.User = url.UserPassword(ci.User, ci.Password)
+		} else {
+			u.User = url.User(ci.User)
+		}
+	}
+
+	hosts := strings.Split(ci.Host, ",")
+	ports := strings.Split(ci.Port, ",")
+	hostPorts := make([]string, len(hosts))
+	for i, host := range hosts {
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// A single port applies to all hosts.
+		port := ports[0]
+		if len(ports) == len(hosts) {
+			port = ports[i]
+		}
+		if port != "" {
+			host += ":" + port
+		}
+		hostPorts[i] = host
+	}
+	u.Host = strings.Join(hostPorts, ",")
+
+	if ci.Database != "" {
+		u.Path = "/" + ci.Database
+	}
+	if len(ci.Params) > 0 {
+		query := url.Values{}
+		for key, value := range ci.Params {
+			query.Set(key, value)
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// dsnString serializes the connection info in DSN format.
+func (ci *ConnectionInfo) dsnString() string {
+	var pairs []string
+	add := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+quoteDSNValue(value))
+		}
+	}
+	add("host", ci.Host)
+	add("port", ci.Port)
+	add("user", ci.User)
+	add("password", ci.Password)
+	add("dbname", ci.Database)
+
+	keys := make([]string, 0, len(ci.Params))
+	for key := range ci.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+quoteDSNValue(ci.Params[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// parseConnectionURL parses a connection string in URL format.
+func parseConnectionURL(connStr string) (*ConnectionInfo, error) {
+	// url.Parse doesn't support multiple hosts, so parse
+	// the host list separately and replace it with a placeholder.
+	authorityStart := strings.Index(connStr, "://") + len("://")
+	authorityEnd := len(connStr)
+	if i := strings.IndexAny(connStr[authorityStart:], "/?#"); i >= 0 {
+		authorityEnd = authorityStart + i
+	}
+	hostsStart := authorityStart
+	if i := strings.LastIndex(connStr[authorityStart:authorityEnd], "@"); i >= 0 {
+		hostsStart = authorityStart + i + 1
+	}
+	hostList := connStr[hostsStart:authorityEnd]
+
+	u, err := url.Parse(connStr[:hostsStart] + "placeholder" + connStr[authorityEnd:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection URL: %w", err)
+	}
+
+	ci := &ConnectionInfo{
+		User:     u.User.Username(),
+		Database: strings.TrimPrefix(u.Path, "/"),
+		Params:   map[string]string{},
+		scheme:   u.Scheme,
+	}
+	ci.Password, _ = u.User.Password()
+
+	var hosts, ports []string
+	hasPort := false
+	for _, hostPort := range strings.Split(hostList, ",") {
+		host, port, err := splitHostPort(hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse connection URL host %q: %w", hostPort, err)
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+		hasPort = hasPort || port != ""
+	}
+	ci.Host = strings.Join(hosts, ",")
+	if hasPort {
+		ci.Port = strings.Join(ports, ",")
+	}
+
+	for key, values := range u.Query() {
+		ci.Params[key] = values[len(values)-1]
+	}
+	return ci, nil
+}
+
+// splitHostPort splits a URL host of the form host, host:port,
+// [ipv6] or [ipv6]:port.
+func splitHostPort(hostPort string) (host, port string, err error) {
+	if strings.HasPrefix(hostPort, "[") {
+		end := strings.Index(hostPort, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf("missing ']' in host")
+		}
+		host, rest := hostPort[1:end], hostPort[end+1:]
+		if rest == "" {
+			return host, "", nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("unexpected %q after host", rest)
+		}
+		return host, rest[1:], nil
+	}
+	if i := strings.LastIndex(hostPort, ":"); i >= 0 {
+		return hostPort[:i], hostPort[i+1:], nil
+	}
+	return hostPort, "", nil
+}
+
+// parseConnectionDSN parses a connection string in the keyword/value
+// DSN format per libpq rules.
+func parseConnectionDSN(connStr string) (*ConnectionInfo, error) {
+	pairs, err := parseDSNPairs(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := &ConnectionInfo{Params: map[string]string{}}
+	for _, pair := range pairs {
+		switch pair.key {
+		case "host":
+			ci.Host = pair.value
+		case "port":
+			ci.Port = pair.value
+		case "user":
+			ci.User = pair.value
+		case "password":
+			ci.Password = pair.value
+		case "dbname":
+			ci.Database = pair.value
+		default:
+			ci.Params[pair.key] = pair.value
+		}
+	}
+	return ci, nil
+}
+
+// dsnPair is a key=value pair of a DSN connection string.
+type dsnPair struct {
+	key   string
+	value string
+	// start and end are the offsets of the value in the connection
+	// string, including its quotes, if any.
+	start, end int
+}
+
+// parseDSNPairs splits a connection string in the keyword/value
+// DSN format into its pairs per libpq rules.
+func parseDSNPairs(connStr string) ([]dsnPair, error) {
+	var pairs []dsnPair
+	s := connStr
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\v\f")
+		if s == "" {
+			return pairs, nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("missing '=' after %q in connection string", s)
+		}
+		key := strings.TrimRight(s[:eq], " \t\n\r\v\f")
+		if key == "" || strings.ContainsAny(key, " \t\n\r\v\f") {
+			return nil, fmt.Errorf("invalid key %q in connection string", key)
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t\n\r\v\f")
+		start := len(connStr) - len(s)
+
+		var value strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+		closed := false
+		for len(s) > 0 {
+			c := s[0]
+			if c == '\\' && len(s) > 1 {
+				value.WriteByte(s[1])
+				s = s[2:]
+				continue
+			}
+			if quoted && c == '\'' {
+				s = s[1:]
+				closed = true
+				break
+			}
+			if !quoted && strings.IndexByte(" \t\n\r\v\f", c) >= 0 {
+				break
+			}
+			value.WriteByte(c)
+			s = s[1:]
+		}
+		if quoted && !closed {
+			return nil, fmt.Errorf("unterminated quoted value for key %q in connection string", key)
+		}
+
+		pairs = append(pairs, dsnPair{key: key, value: value.String(), start: start, end: len(connStr) - len(s)})
+	}
+}
@@ -0,0 +1,38 @@
+package pgdbtemplate
+
+import "fmt"
+
+// Dialect selects the SQL dialect of the database server.
+type Dialect int
+
+const (
+	// DialectPostgres is the dialect of PostgreSQL, used by default.
+	DialectPostgres Dialect = iota
+	// DialectCockroach is the dialect of CockroachDB.
+	//
+	// CockroachDB doesn't support template databases, copying databases
+	// or pg_terminate_backend, so in this mode:
+	//
+	//   - no template database is created, since it could not be copied,
+	//   - test databases are created empty and migrated one by one,
+	//     together with Config.Extensions, Config.PostMigrationSQL
+	//     and Config.TemplateVerifyQuery,
+	//   - connections are not terminated before dropping a database,
+	//     since DROP DATABASE doesn't wait for them in CockroachDB.
+	//
+	// Creating test databases is therefore as slow as running the
+	// migrations, but tests run unchanged against CockroachDB.
+	DialectCockroach
+)
+
+// String returns the name of the dialect.
+func (d Dialect) String() string {
+	switch d {
+	case DialectPostgres:
+		return "postgres"
+	case DialectCockroach:
+		return "cockroach"
+	default:
+		return fmt.Sprintf("Dialect(%d)", int(d))
+	}
+}
@@ -0,0 +1,129 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestCockroachDialect tests the template manager against a server
+// which, like CockroachDB, rejects template databases.
+func TestCockroachDialect(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &recordingConnectionProvider{
+		ConnectionProvider: cockroachProvider(connProvider),
+	}
+	migrator := &countingMigrationRunner{}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    migrator,
+		TemplateName:       "cockroach_template",
+		Dialect:            pgdbtemplate.DialectCockroach,
+	})
+	c.Assert(err, qt.IsNil)
+
+	// No template is created, since it could not be copied.
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(migrator.count(), qt.Equals, int64(0))
+	c.Assert(databaseExists(ctx, connProvider, "cockroach_template"), qt.IsFalse)
+
+	// Test databases are migrated instead.
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsTrue)
+	c.Assert(migrator.count(), qt.Equals, int64(1))
+
+	_, cleanDBName, err := tm.CreateTestDatabaseFromTemplate(ctx, "template0", "")
+	c.Assert(err, qt.IsNil)
+	c.Assert(migrator.count(), qt.Equals, int64(1))
+
+	_, _, err = tm.CreateTestDatabaseFromTemplate(ctx, "some_other_template", "cockroach_unsupported_db")
+	c.Assert(err, qt.ErrorMatches, `failed to create test database "cockroach_unsupported_db": copying from template "some_other_template" is not supported by cockroach`)
+
+	c.Assert(tm.DropTestDatabase(ctx, testDBName), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsFalse)
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, cleanDBName), qt.IsFalse)
+
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		fmt.Sprintf(`CREATE DATABASE "%s"`, testDBName),
+		fmt.Sprintf(`CREATE DATABASE "%s"`, cleanDBName),
+		fmt.Sprintf(`DROP DATABASE "%s"`, testDBName),
+		fmt.Sprintf(`DROP DATABASE "%s"`, cleanDBName),
+	})
+	c.Assert(provider.recordedConnects(), qt.Not(qt.Contains), "cockroach_template")
+}
+
+// TestPostgresDialectOnCockroach tests that the default dialect
+// fails against a server rejecting template databases.
+func TestPostgresDialectOnCockroach(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: cockroachProvider(setupTestConnectionProvider()),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "postgres_on_cockroach_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, "failed to create template database: failed to mark database as template: .*is_template.*")
+}
+
+// TestUnknownDialect tests that NewTemplateManager rejects unknown dialects.
+func TestUnknownDialect(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		Dialect:            pgdbtemplate.Dialect(42),
+	})
+	c.Assert(err, qt.ErrorMatches, `unknown Dialect Dialect\(42\)`)
+}
+
+// cockroachProvider wraps provider, rejecting the statements
+// which CockroachDB doesn't support.
+func cockroachProvider(provider pgdbtemplate.ConnectionProvider) *hookedConnectionProvider {
+	return &hookedConnectionProvider{
+		ConnectionProvider: provider,
+		beforeExec: func(_ context.Context, query string) error {
+			for _, unsupported := range []string{"is_template", " TEMPLATE ", "pg_terminate_backend"} {
+				if strings.Contains(query, unsupported) {
+					return fmt.Errorf("at or near %q: syntax error: unimplemented", strings.TrimSpace(unsupported))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// countingMigrationRunner counts how many times migrations were run.
+type countingMigrationRunner struct {
+	runs int64
+}
+
+// RunMigrations implements pgdbtemplate.MigrationRunner.RunMigrations.
+func (r *countingMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	atomic.AddInt64(&r.runs, 1)
+	return nil
+}
+
+// count returns the number of migration runs.
+func (r *countingMigrationRunner) count() int64 {
+	return atomic.LoadInt64(&r.runs)
+}
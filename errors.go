@@ -0,0 +1,89 @@
+package pgdbtemplate
+
+import "errors"
+
+// Sentinel errors wrapped by the template manager, so that callers
+// can match them with errors.Is instead of relying on error messages.
+var (
+	// ErrTemplateNotInitialized is returned when an operation requires
+	// the template database, but it has not been initialized.
+	ErrTemplateNotInitialized = errors.New("template database is not initialized")
+	// ErrDatabaseAlreadyExists is returned when creating a database
+	// whose name is already taken.
+	ErrDatabaseAlreadyExists = errors.New("database already exists")
+	// ErrDatabaseDoesNotExist is returned when operating on
+	// a database that does not exist.
+	ErrDatabaseDoesNotExist = errors.New("database does not exist")
+	// ErrManagerClosed is returned when the template manager is used
+	// after Cleanup or Close.
+	ErrManagerClosed = errors.New("template manager is closed")
+)
+
+// PostgreSQL error codes (SQLSTATE) mapped to sentinel errors.
+const (
+	sqlStateDuplicateDatabase  = "42P04"
+	sqlStateInvalidCatalogName = "3D000"
+)
+
+// sqlStateObjectInUse is the SQLSTATE of dropping a database
+// which other sessions are still connected to.
+const sqlStateObjectInUse = "55006"
+
+// sqlStateWrongObjectType is the SQLSTATE of, among others,
+// dropping a database which is marked as a template.
+const sqlStateWrongObjectType = "42809"
+
+// sqlStateError is implemented by driver errors exposing their SQLSTATE,
+// such as *pq.Error and *pgconn.PgError.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// SQLState returns the PostgreSQL error code (SQLSTATE) of the first
+// driver error in err's tree, or an empty string if there is none,
+// e.g. "42P04" for a duplicate database.
+//
+// Driver errors are found if they implement SQLState() string,
+// as both *pq.Error and *pgconn.PgError do, so errors returned by
+// the template manager can be inspected without importing the driver.
+func SQLState(err error) string {
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+	return ""
+}
+
+// sentinelError attaches a sentinel error to a driver error
+// without changing its message.
+type sentinelError struct {
+	sentinel error
+	err      error
+}
+
+// Error implements error.Error.
+func (e *sentinelError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns both the sentinel and the driver error.
+func (e *sentinelError) Unwrap() []error {
+	return []error{e.sentinel, e.err}
+}
+
+// withSentinel wraps err with sentinel.
+func withSentinel(err, sentinel error) error {
+	return &sentinelError{sentinel: sentinel, err: err}
+}
+
+// classifyError wraps err with the sentinel error matching
+// its SQLSTATE, if any.
+func classifyError(err error) error {
+	switch SQLState(err) {
+	case sqlStateDuplicateDatabase:
+		return withSentinel(err, ErrDatabaseAlreadyExists)
+	case sqlStateInvalidCatalogName:
+		return withSentinel(err, ErrDatabaseDoesNotExist)
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/andrei-polukhin/pgdbtemplate/pgdbtemplatetest"
+)
+
+// TestSentinelErrors verifies that errors returned for common failure
+// modes can be matched with errors.Is based on the driver SQLSTATE.
+func TestSentinelErrors(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		// Fail to copy a missing template like PostgreSQL does.
+		beforeExec: func(ctx context.Context, query string) error {
+			parts := strings.Fields(query)
+			if len(parts) != 5 || parts[0] != "CREATE" || parts[3] != "TEMPLATE" {
+				return nil
+			}
+			if templateName := strings.Trim(parts[4], `"`); !databaseExists(ctx, connProvider, templateName) {
+				return &mockPgError{
+					code:    "3D000",
+					message: fmt.Sprintf("template database %q does not exist", templateName),
+				}
+			}
+			return nil
+		},
+	}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "sentinel_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// The template database doesn't exist before Initialize.
+	_, _, err = tm.CreateTestDatabase(ctx, "sentinel_uninitialized_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsTrue, qt.Commentf("got %v", err))
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, _, err = tm.CreateTestDatabase(ctx, "sentinel_db")
+	c.Assert(err, qt.IsNil)
+
+	_, _, err = tm.CreateTestDatabase(ctx, "sentinel_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseAlreadyExists), qt.IsTrue, qt.Commentf("got %v", err))
+
+	// Databases not created by the manager are rejected by the server.
+	adminConn, err := provider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	_, err = adminConn.ExecContext(ctx, `CREATE DATABASE "sentinel_external_db"`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(adminConn.Close(), qt.IsNil)
+	_, _, err = tm.CreateTestDatabase(ctx, "sentinel_external_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseAlreadyExists), qt.IsTrue, qt.Commentf("got %v", err))
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsFalse)
+	// The driver error remains accessible and its message is unchanged.
+	var pgErr *mockPgError
+	c.Assert(errors.As(err, &pgErr), qt.IsTrue)
+	c.Assert(pgErr.SQLState(), qt.Equals, "42P04")
+	c.Assert(err, qt.ErrorMatches, `failed to create test database "sentinel_external_db": database "sentinel_external_db" already exists`)
+
+	err = tm.DropTestDatabase(ctx, "sentinel_missing_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+
+	_, err = tm.ResetTestDatabase(ctx, "sentinel_missing_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+
+	// A missing arbitrary template is not reported as uninitialized.
+	_, _, err = tm.CreateTestDatabaseFromTemplate(ctx, "sentinel_missing_template", "")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+	c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsFalse)
+}
+
+// TestSentinelErrorsWithoutSQLState verifies that driver errors
+// without a SQLSTATE are not matched by message.
+func TestSentinelErrorsWithoutSQLState(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: setupTestConnectionProvider(),
+			beforeExec:         failingDrops("sentinel_plain_error_db"),
+		},
+		MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.DropTestDatabase(ctx, "sentinel_plain_error_db")
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsFalse)
+}
+
+// TestSQLState verifies that the SQLSTATE of driver errors
+// can be extracted from the errors of the template manager.
+func TestSQLState(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	providers := []struct {
+		name     string
+		provider pgdbtemplate.ConnectionProvider
+	}{{
+		name:     "Mock",
+		provider: setupTestConnectionProvider(),
+	}, {
+		name:     "Fake",
+		provider: pgdbtemplatetest.NewFakeConnectionProvider(),
+	}}
+
+	for _, test := range providers {
+		c.Run(test.name, func(c *qt.C) {
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: test.provider,
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:       "sqlstate_template",
+			})
+			c.Assert(err, qt.IsNil)
+			c.Assert(tm.Initialize(ctx), qt.IsNil)
+			defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+			conn, _, err := tm.CreateTestDatabase(ctx, "sqlstate_db")
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+
+			adminConn, err := test.provider.Connect(ctx, "postgres")
+			c.Assert(err, qt.IsNil)
+			_, err = adminConn.ExecContext(ctx, `CREATE DATABASE "sqlstate_external_db"`)
+			c.Assert(err, qt.IsNil)
+			c.Assert(adminConn.Close(), qt.IsNil)
+			_, _, err = tm.CreateTestDatabase(ctx, "sqlstate_external_db")
+			c.Assert(pgdbtemplate.SQLState(err), qt.Equals, "42P04")
+
+			err = tm.DropTestDatabase(ctx, "sqlstate_missing_db")
+			c.Assert(pgdbtemplate.SQLState(err), qt.Equals, "3D000")
+		})
+	}
+
+	c.Assert(pgdbtemplate.SQLState(nil), qt.Equals, "")
+	c.Assert(pgdbtemplate.SQLState(errors.New("plain error")), qt.Equals, "")
+	c.Assert(pgdbtemplate.SQLState(fmt.Errorf("wrapped: %w", &mockPgError{code: "55006"})), qt.Equals, "55006")
+}
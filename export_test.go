@@ -0,0 +1,7 @@
+package pgdbtemplate
+
+// SetRandReadForTesting replaces the source of the random test database
+// names generated by tm with UseRandomNames.
+func SetRandReadForTesting(tm *TemplateManager, randRead func(b []byte) (int, error)) {
+	tm.randRead = randRead
+}
@@ -0,0 +1,108 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestOnTestDatabaseCreated verifies that the hook runs after a test
+// database is created, and that its failure drops the database.
+func TestOnTestDatabaseCreated(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Hook receives the new database", func(c *qt.C) {
+		var hookNames []string
+		connProvider := setupTestConnectionProvider()
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "hook_created_template",
+			OnTestDatabaseCreated: func(ctx context.Context, conn pgdbtemplate.DatabaseConnection, name string) error {
+				c.Assert(conn, qt.IsNotNil)
+				hookNames = append(hookNames, name)
+				return nil
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		_, testDBName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(hookNames, qt.DeepEquals, []string{testDBName})
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+
+	c.Run("Failing hook drops the database", func(c *qt.C) {
+		hookErr := errors.New("seeding failed")
+		connProvider := setupTestConnectionProvider()
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "hook_created_fail_template",
+			OnTestDatabaseCreated: func(context.Context, pgdbtemplate.DatabaseConnection, string) error {
+				return hookErr
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		_, _, err = tm.CreateTestDatabase(ctx, "hook_created_fail_db")
+		c.Assert(err, qt.ErrorIs, hookErr)
+		c.Assert(err, qt.ErrorMatches, `OnTestDatabaseCreated failed for test database "hook_created_fail_db": seeding failed`)
+		c.Assert(databaseExists(ctx, connProvider, "hook_created_fail_db"), qt.IsFalse)
+
+		// The database is not tracked, so Cleanup only drops the template.
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+}
+
+// TestOnBeforeDropTestDatabase verifies that the hook runs before
+// a test database is dropped and can prevent dropping it.
+func TestOnBeforeDropTestDatabase(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	hookErr := errors.New("still registered")
+	var failHook bool
+	var hookNames []string
+	connProvider := setupTestConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "hook_before_drop_template",
+		OnBeforeDropTestDatabase: func(ctx context.Context, name string) error {
+			hookNames = append(hookNames, name)
+			if failHook {
+				return hookErr
+			}
+			return nil
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// A failing hook keeps the database.
+	failHook = true
+	err = tm.DropTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.ErrorIs, hookErr)
+	c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsTrue)
+
+	failHook = false
+	err = tm.DropTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsFalse)
+	c.Assert(hookNames, qt.DeepEquals, []string{testDBName, testDBName})
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
@@ -0,0 +1,34 @@
+package pgdbtemplate
+
+import "time"
+
+// Metrics receives aggregate measurements from the template manager.
+//
+// Implementations must be safe for concurrent use, since test databases
+// are typically created and dropped from parallel tests. This lets users
+// wire metrics libraries such as Prometheus without the core package
+// depending on them.
+type Metrics interface {
+	// RecordCreateDuration records how long creating a test database
+	// from the template took.
+	RecordCreateDuration(d time.Duration)
+	// IncCreated is called after a test database has been created.
+	IncCreated()
+	// IncDropped is called after a test database has been dropped,
+	// either by DropTestDatabase or by Cleanup.
+	IncDropped()
+	// IncCreateError is called for every test database which failed
+	// to be created, because either CREATE DATABASE or one of the steps
+	// after it failed, e.g. connecting to the database.
+	// Calls failing before creating any database, e.g. because Initialize
+	// was not called or the name is tracked already, are not counted.
+	IncCreateError()
+}
+
+// noOpMetrics is a Metrics implementation that does nothing.
+type noOpMetrics struct{}
+
+func (noOpMetrics) RecordCreateDuration(time.Duration) {}
+func (noOpMetrics) IncCreated()                        {}
+func (noOpMetrics) IncDropped()                        {}
+func (noOpMetrics) IncCreateError()                    {}
@@ -0,0 +1,133 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestMetricsCreateDropCycle verifies that the metrics hooks are called
+// through a create/drop cycle.
+func TestMetricsCreateDropCycle(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	metrics := &fakeMetrics{}
+	config := pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "metrics_template",
+		Metrics:            metrics,
+	}
+
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	_, testDBName1, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// Creating a database which exists on the server fails.
+	adminConn, err := config.ConnectionProvider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	_, err = adminConn.ExecContext(ctx, `CREATE DATABASE "metrics_existing_db"`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(adminConn.Close(), qt.IsNil)
+	_, _, err = tm.CreateTestDatabase(ctx, "metrics_existing_db")
+	c.Assert(err, qt.IsNotNil)
+
+	// Rejecting the name of a tracked database doesn't count as an error,
+	// since no database is created.
+	_, _, err = tm.CreateTestDatabase(ctx, testDBName1)
+	c.Assert(err, qt.IsNotNil)
+
+	c.Assert(atomic.LoadInt64(&metrics.created), qt.Equals, int64(2))
+	c.Assert(atomic.LoadInt64(&metrics.createErrors), qt.Equals, int64(1))
+	c.Assert(atomic.LoadInt64(&metrics.durations), qt.Equals, int64(2))
+
+	// One database is dropped explicitly, the other one by Cleanup.
+	err = tm.DropTestDatabase(ctx, testDBName1)
+	c.Assert(err, qt.IsNil)
+	c.Assert(atomic.LoadInt64(&metrics.dropped), qt.Equals, int64(1))
+
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(atomic.LoadInt64(&metrics.dropped), qt.Equals, int64(2))
+}
+
+// TestMetricsCreateTestDatabasesFailure verifies that every test database
+// of a failing batch is counted, either as an error or as created
+// and dropped again.
+func TestMetricsCreateTestDatabasesFailure(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var connects int32
+	metrics := &fakeMetrics{}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: setupTestConnectionProvider(),
+			// Fail connecting to all test databases but the first one.
+			beforeConnect: func(_ context.Context, databaseName string) error {
+				if strings.HasPrefix(databaseName, "metrics_batch_") && atomic.AddInt32(&connects, 1) > 1 {
+					return fmt.Errorf("intentional connection failure")
+				}
+				return nil
+			},
+		},
+		MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:    "metrics_template_batch",
+		TestDBPrefix:    "metrics_batch_",
+		Metrics:         metrics,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, _, err = tm.CreateTestDatabases(ctx, 3)
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(atomic.LoadInt64(&metrics.created), qt.Equals, int64(1))
+	c.Assert(atomic.LoadInt64(&metrics.dropped), qt.Equals, int64(1))
+	c.Assert(atomic.LoadInt64(&metrics.createErrors), qt.Equals, int64(2))
+}
+
+// fakeMetrics is a pgdbtemplate.Metrics implementation counting calls.
+type fakeMetrics struct {
+	durations    int64
+	created      int64
+	dropped      int64
+	createErrors int64
+}
+
+// RecordCreateDuration implements pgdbtemplate.Metrics.RecordCreateDuration.
+func (m *fakeMetrics) RecordCreateDuration(time.Duration) {
+	atomic.AddInt64(&m.durations, 1)
+}
+
+// IncCreated implements pgdbtemplate.Metrics.IncCreated.
+func (m *fakeMetrics) IncCreated() {
+	atomic.AddInt64(&m.created, 1)
+}
+
+// IncDropped implements pgdbtemplate.Metrics.IncDropped.
+func (m *fakeMetrics) IncDropped() {
+	atomic.AddInt64(&m.dropped, 1)
+}
+
+// IncCreateError implements pgdbtemplate.Metrics.IncCreateError.
+func (m *fakeMetrics) IncCreateError() {
+	atomic.AddInt64(&m.createErrors, 1)
+}
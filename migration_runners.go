@@ -1,11 +1,17 @@
 package pgdbtemplate
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // NoOpMigrationRunner is a migration runner that does nothing.
@@ -16,10 +22,198 @@ func (*NoOpMigrationRunner) RunMigrations(context.Context, DatabaseConnection) e
 	return nil
 }
 
+// SQLMigrationRunner runs migrations from SQL statements held in memory.
+type SQLMigrationRunner struct {
+	statements []string
+}
+
+// NewSQLMigrationRunner creates a migration runner executing
+// the statements in the order they are provided in.
+func NewSQLMigrationRunner(statements ...string) *SQLMigrationRunner {
+	return &SQLMigrationRunner{statements: statements}
+}
+
+// RunMigrations executes all statements on the connection,
+// stopping at the first failing one, which the error numbers from 1.
+func (r *SQLMigrationRunner) RunMigrations(ctx context.Context, conn DatabaseConnection) error {
+	for i, statement := range r.statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to execute statement %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// gzipExtension is the extension of gzip-compressed migration files,
+// following the extension of the SQL file, e.g. "schema.sql.gz".
+const gzipExtension = ".gz"
+
+// utf8BOM is the byte order mark starting UTF-8 files written
+// by some Windows editors, which PostgreSQL rejects as SQL.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
 // FileMigrationRunner runs migrations from filesystem.
 type FileMigrationRunner struct {
 	migrationPaths []string
 	orderingFunc   func([]string) []string
+	recursive      bool
+	extensions     []string
+	excludes       []string
+	perFileTimeout time.Duration
+	progress       func(done, total int, file string)
+	dryRun         bool
+	pgDumpCompat   bool
+	readFile       func(path string) ([]byte, error)
+	openFile       func(path string) (io.ReadCloser, error)
+	streaming      bool
+	globalOrdering bool
+	ignoreError    func(error) bool
+}
+
+// FileMigrationRunnerOption configures a FileMigrationRunner.
+type FileMigrationRunnerOption func(*FileMigrationRunner)
+
+// WithRecursive makes the runner collect migration files from
+// subdirectories of each path as well, at any depth.
+//
+// The ordering function receives all files found under a path at once,
+// so files are ordered across subdirectories. With the default
+// alphabetical sorting, this means ordering by full path, e.g.
+// "billing/002.sql" runs before "users/001.sql".
+func WithRecursive() FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.recursive = true
+	}
+}
+
+// WithExtensions sets the file extensions of migration files,
+// e.g. ".sql" and ".pgsql", replacing the default ".sql".
+func WithExtensions(extensions ...string) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.extensions = extensions
+	}
+}
+
+// WithExclude skips migration files whose base names match any of the
+// glob patterns, e.g. "*.down.sql". Patterns use filepath.Match syntax.
+func WithExclude(patterns ...string) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.excludes = append(r.excludes, patterns...)
+	}
+}
+
+// WithPerFileTimeout limits the execution time of every migration file.
+// The context passed to ExecContext is cancelled once the timeout
+// elapses, so the driver can abort the running statement.
+//
+// If zero or negative, migration files are not time-limited.
+func WithPerFileTimeout(timeout time.Duration) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.perFileTimeout = timeout
+	}
+}
+
+// WithProgress sets a function called synchronously after every
+// migration file has been executed successfully or skipped, with the number of files
+// executed so far, the total number of files and the path of the file.
+func WithProgress(progress func(done, total int, file string)) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.progress = progress
+	}
+}
+
+// WithDryRun makes RunMigrations resolve and read all migration files
+// without executing them, e.g. to validate the migration paths in CI.
+//
+// Errors such as missing directories or unreadable files are still
+// returned, and the progress function is still called for every file.
+func WithDryRun() FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.dryRun = true
+	}
+}
+
+// WithPgDumpCompat makes the runner accept files written by pg_dump
+// in plain format: psql meta-commands such as \connect are skipped,
+// and COPY ... FROM stdin blocks in text format are translated
+// into INSERT statements.
+//
+// Without it, files containing these constructs are rejected
+// with an error pointing at the offending line.
+func WithPgDumpCompat() FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.pgDumpCompat = true
+	}
+}
+
+// WithFileReader sets the function reading the content of migration
+// files, replacing os.ReadFile, e.g. to decrypt files stored encrypted
+// or to decode files not encoded in UTF-8 to UTF-8.
+// Files are still collected from the filesystem, and gzip-compressed
+// files are decompressed after being read.
+func WithFileReader(readFile func(path string) ([]byte, error)) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.readFile = readFile
+	}
+}
+
+// WithStreaming makes the runner read migration files incrementally and
+// execute their statements one at a time, instead of reading every file
+// into memory at once and sending it as a single query. Memory use is
+// then bounded by the largest statement rather than the largest file,
+// e.g. for seed files of hundreds of megabytes.
+//
+// Statements are split at the semicolons outside of quoted strings,
+// dollar-quoted strings and comments. Unlike whole files, they don't
+// run in an implicit transaction, so the statements preceding a failing
+// one stay applied, and WithIgnoreErrors skips the rest of the file.
+//
+// It cannot be used together with WithPgDumpCompat or WithFileReader;
+// use WithFileOpener instead of the latter.
+func WithStreaming() FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.streaming = true
+	}
+}
+
+// WithFileOpener sets the function opening migration files with
+// WithStreaming, replacing os.Open, e.g. to decrypt files stored
+// encrypted on the fly. Gzip-compressed files are decompressed
+// after being opened.
+func WithFileOpener(openFile func(path string) (io.ReadCloser, error)) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.openFile = openFile
+	}
+}
+
+// WithGlobalOrdering makes the runner collect the files of all paths
+// first and call the ordering function once over the combined list,
+// so the order of files does not depend on the order of the paths.
+//
+// Without it, files of each path are ordered separately, and paths are
+// processed in the order they were provided in. Note that the default
+// alphabetical sorting orders by full path, so files of different
+// directories are only interleaved by an ordering function comparing
+// e.g. their base names.
+func WithGlobalOrdering() FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.globalOrdering = true
+	}
+}
+
+// WithIgnoreErrors makes the runner skip migration files whose execution
+// fails with an error for which ignore returns true, e.g. a duplicate
+// object when re-applying migrations, instead of aborting. The predicate
+// may also log the error, and the progress function is called for
+// the skipped files as well.
+//
+// PostgreSQL runs the statements of a file sent at once in an implicit
+// transaction, so none of the statements of a skipped file are applied.
+// Errors such as unreadable files are never ignored.
+func WithIgnoreErrors(ignore func(error) bool) FileMigrationRunnerOption {
+	return func(r *FileMigrationRunner) {
+		r.ignoreError = ignore
+	}
 }
 
 // NewFileMigrationRunner creates a new file-based migration runner.
@@ -27,44 +221,90 @@ type FileMigrationRunner struct {
 // The caller is responsible for ensuring that the paths slice is not modified
 // after being passed to orderingFunc. Upon the nil function provided, an
 // alphabetical sorting will be used.
-func NewFileMigrationRunner(paths []string, orderingFunc func([]string) []string) *FileMigrationRunner {
+func NewFileMigrationRunner(paths []string, orderingFunc func([]string) []string, opts ...FileMigrationRunnerOption) *FileMigrationRunner {
 	if orderingFunc == nil {
 		orderingFunc = AlphabeticalMigrationFilesSorting
 	}
-	return &FileMigrationRunner{
+	r := &FileMigrationRunner{
 		migrationPaths: paths,
 		orderingFunc:   orderingFunc,
+		extensions:     []string{".sql"},
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
 // RunMigrations executes all migration files on the connection.
+//
+// Every file is read into memory at once and executed as a single query,
+// unless WithStreaming is used. A UTF-8 byte order mark at the start
+// of a file is stripped.
 func (r *FileMigrationRunner) RunMigrations(ctx context.Context, conn DatabaseConnection) error {
+	if r.streaming && r.pgDumpCompat {
+		return fmt.Errorf("WithStreaming cannot be used together with WithPgDumpCompat")
+	}
+	if r.streaming && r.readFile != nil {
+		return fmt.Errorf("WithStreaming cannot be used together with WithFileReader, use WithFileOpener instead")
+	}
+
+	allFiles, err := r.ResolveFiles()
+	if err != nil {
+		return err
+	}
+
+	// Execute each file.
+	for i, file := range allFiles {
+		// Stop before reading the next file once the context is done.
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("migrations interrupted before %q: %w", file, err)
+		}
+		if err := r.executeFile(ctx, conn, file); err != nil {
+			return fmt.Errorf("failed to execute migration %q (%d/%d): %w", file, i+1, len(allFiles), err)
+		}
+		if r.progress != nil {
+			r.progress(i+1, len(allFiles), file)
+		}
+	}
+	return nil
+}
+
+// ResolveFiles returns the migration files in the order RunMigrations
+// would execute them, without executing them.
+//
+// Files of each path are ordered separately, and paths are
+// processed in the order they were provided in, unless
+// WithGlobalOrdering is used.
+func (r *FileMigrationRunner) ResolveFiles() ([]string, error) {
 	var allFiles []string
 
 	// Collect and order files from each path separately.
 	for _, path := range r.migrationPaths {
 		files, err := r.collectSQLFiles(path)
 		if err != nil {
-			return fmt.Errorf("failed to collect files from %q: %w", path, err)
+			return nil, fmt.Errorf("failed to collect files from %q: %w", path, err)
 		}
 
 		// Order files within this directory.
-		if len(files) > 0 {
+		if len(files) > 0 && !r.globalOrdering {
 			files = r.orderingFunc(files)
-			allFiles = append(allFiles, files...)
 		}
+		allFiles = append(allFiles, files...)
 	}
 
-	// Execute each file.
-	for _, file := range allFiles {
-		if err := r.executeFile(ctx, conn, file); err != nil {
-			return fmt.Errorf("failed to execute migration %q: %w", file, err)
-		}
+	// Order files of all directories at once.
+	if len(allFiles) > 0 && r.globalOrdering {
+		allFiles = r.orderingFunc(allFiles)
 	}
-	return nil
+	return allFiles, nil
 }
 
 func (r *FileMigrationRunner) collectSQLFiles(path string) ([]string, error) {
+	if r.recursive {
+		return r.walkSQLFiles(path)
+	}
+
 	entries, err := os.ReadDir(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read directory %q: %w", path, err)
@@ -72,19 +312,191 @@ func (r *FileMigrationRunner) collectSQLFiles(path string) ([]string, error) {
 
 	files := make([]string, 0, len(entries))
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+		if entry.IsDir() {
+			continue
+		}
+		ok, err := r.isMigrationFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
 			files = append(files, filepath.Join(path, entry.Name()))
 		}
 	}
 	return files, nil
 }
 
+// walkSQLFiles collects SQL files from path and all its subdirectories.
+func (r *FileMigrationRunner) walkSQLFiles(path string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		ok, err := r.isMigrationFile(entry.Name())
+		if ok {
+			files = append(files, filePath)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %q: %w", path, err)
+	}
+	return files, nil
+}
+
+// isMigrationFile reports whether the file with the given base name
+// has one of the extensions and matches none of the exclude patterns.
+//
+// Gzip-compressed files are matched as if their ".gz" suffix was absent.
+func (r *FileMigrationRunner) isMigrationFile(name string) (bool, error) {
+	uncompressedName := strings.TrimSuffix(name, gzipExtension)
+	hasExtension := false
+	for _, extension := range r.extensions {
+		if strings.HasSuffix(uncompressedName, extension) {
+			hasExtension = true
+			break
+		}
+	}
+	if !hasExtension {
+		return false, nil
+	}
+
+	for _, pattern := range r.excludes {
+		excluded, err := filepath.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		if !excluded && uncompressedName != name {
+			excluded, _ = filepath.Match(pattern, uncompressedName)
+		}
+		if excluded {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func (r *FileMigrationRunner) executeFile(ctx context.Context, conn DatabaseConnection, filePath string) error {
-	content, err := os.ReadFile(filePath) // #nosec G304 -- Migration files are controlled by the application.
+	if r.streaming {
+		return r.streamFile(ctx, conn, filePath)
+	}
+
+	readFile := r.readFile
+	if readFile == nil {
+		readFile = os.ReadFile
+	}
+	content, err := readFile(filePath) // #nosec G304 -- Migration files are controlled by the application.
 	if err != nil {
 		return fmt.Errorf("failed to read migration file %q: %w", filePath, err)
 	}
+	if strings.HasSuffix(filePath, gzipExtension) {
+		if content, err = gunzip(content); err != nil {
+			return fmt.Errorf("failed to decompress migration file %q: %w", filePath, err)
+		}
+	}
+
+	sql := string(bytes.TrimPrefix(content, utf8BOM))
+	if r.pgDumpCompat {
+		if sql, err = translatePgDump(sql); err != nil {
+			return err
+		}
+	} else if err := checkPgDumpConstructs(sql); err != nil {
+		return err
+	}
+	if r.dryRun {
+		return nil
+	}
+
+	if r.perFileTimeout <= 0 {
+		_, err = conn.ExecContext(ctx, sql)
+		return r.filterError(err)
+	}
+
+	fileCtx, cancel := context.WithTimeout(ctx, r.perFileTimeout)
+	defer cancel()
+	_, err = conn.ExecContext(fileCtx, sql)
+	if err != nil && ctx.Err() == nil && errors.Is(fileCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v: %w", r.perFileTimeout, err)
+	}
+	return r.filterError(err)
+}
+
+// streamFile executes the statements of a migration file one at a time,
+// reading the file incrementally.
+func (r *FileMigrationRunner) streamFile(ctx context.Context, conn DatabaseConnection, filePath string) error {
+	openFile := r.openFile
+	if openFile == nil {
+		openFile = func(path string) (io.ReadCloser, error) {
+			return os.Open(path) // #nosec G304 -- Migration files are controlled by the application.
+		}
+	}
+	file, err := openFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open migration file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(filePath, gzipExtension) {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress migration file %q: %w", filePath, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	fileCtx := ctx
+	if r.perFileTimeout > 0 {
+		var cancel context.CancelFunc
+		fileCtx, cancel = context.WithTimeout(ctx, r.perFileTimeout)
+		defer cancel()
+	}
+
+	statements := newStatementReader(reader)
+	for {
+		statement, line, err := statements.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read migration file %q: %w", filePath, err)
+		}
+		if err := checkPgDumpConstructsFrom(statement, line); err != nil {
+			return err
+		}
+		if r.dryRun {
+			continue
+		}
+
+		trimmed := strings.TrimLeft(statement, " \t\r\n")
+		line += strings.Count(statement[:len(statement)-len(trimmed)], "\n")
+		_, err = conn.ExecContext(fileCtx, strings.TrimSpace(trimmed))
+		if err != nil && ctx.Err() == nil && errors.Is(fileCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %v: %w", r.perFileTimeout, err)
+		}
+		if err != nil {
+			return r.filterError(fmt.Errorf("statement at line %d: %w", line, err))
+		}
+	}
+}
 
-	_, err = conn.ExecContext(ctx, string(content))
+// filterError returns nil if the error of executing a migration file
+// is ignored with WithIgnoreErrors, and err otherwise.
+func (r *FileMigrationRunner) filterError(err error) error {
+	if err != nil && r.ignoreError != nil && r.ignoreError(err) {
+		return nil
+	}
 	return err
 }
+
+// gunzip decompresses gzip-compressed content.
+func gunzip(content []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -13,3 +13,29 @@ func AlphabeticalMigrationFilesSorting(files []string) []string {
 	sort.Strings(sorted)
 	return sorted
 }
+
+// ReverseMigrationFilesSorting makes a copy of the provided slice
+// and sorts migration files in reverse alphabetical order
+// in the copied slice, e.g. to run down migrations.
+//
+// The original slice is not modified.
+func ReverseMigrationFilesSorting(files []string) []string {
+	sorted := AlphabeticalMigrationFilesSorting(files)
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return sorted
+}
+
+// ChainOrdering returns an ordering function ordering migration files
+// by primary and breaking ties by secondary, e.g. ordering by a numeric
+// prefix first and by the full path among files sharing the prefix.
+//
+// The files are ordered by secondary first and then by primary,
+// so primary must be stable (e.g. based on sort.SliceStable)
+// for the ties to keep the order of secondary.
+func ChainOrdering(primary, secondary func([]string) []string) func([]string) []string {
+	return func(files []string) []string {
+		return primary(secondary(files))
+	}
+}
@@ -1,6 +1,9 @@
 package pgdbtemplate_test
 
 import (
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -31,3 +34,77 @@ func TestAlphabeticalMigrationFilesSorting(t *testing.T) {
 	// Verify original slice wasn't modified.
 	c.Assert(files[0], qt.Equals, "/path/003_third.sql")
 }
+
+// TestReverseMigrationFilesSorting tests the reverse sorting function.
+func TestReverseMigrationFilesSorting(t *testing.T) {
+	c := qt.New(t)
+
+	files := []string{
+		"/path/002_second.down.sql",
+		"/path/001_first.down.sql",
+		"/path/003_third.down.sql",
+	}
+
+	sorted := pgdbtemplate.ReverseMigrationFilesSorting(files)
+
+	expected := []string{
+		"/path/003_third.down.sql",
+		"/path/002_second.down.sql",
+		"/path/001_first.down.sql",
+	}
+
+	c.Assert(sorted, qt.DeepEquals, expected)
+
+	// Verify original slice wasn't modified.
+	c.Assert(files, qt.DeepEquals, []string{
+		"/path/002_second.down.sql",
+		"/path/001_first.down.sql",
+		"/path/003_third.down.sql",
+	})
+
+	c.Assert(pgdbtemplate.ReverseMigrationFilesSorting(nil), qt.HasLen, 0)
+}
+
+// TestChainOrdering tests chaining a primary ordering with a tiebreaker.
+func TestChainOrdering(t *testing.T) {
+	c := qt.New(t)
+
+	// byNumericPrefix stably orders files by the number
+	// their base names start with.
+	byNumericPrefix := func(files []string) []string {
+		prefix := func(file string) string {
+			name := filepath.Base(file)
+			return name[:strings.IndexByte(name, '_')]
+		}
+		sorted := append([]string(nil), files...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return prefix(sorted[i]) < prefix(sorted[j])
+		})
+		return sorted
+	}
+
+	files := []string{
+		"/migrations/billing/002_invoices.sql",
+		"/migrations/users/001_users.sql",
+		"/migrations/billing/001_accounts.sql",
+		"/migrations/audit/001_events.sql",
+		"/migrations/audit/002_retention.sql",
+	}
+
+	ordering := pgdbtemplate.ChainOrdering(byNumericPrefix, pgdbtemplate.AlphabeticalMigrationFilesSorting)
+	expected := []string{
+		"/migrations/audit/001_events.sql",
+		"/migrations/billing/001_accounts.sql",
+		"/migrations/users/001_users.sql",
+		"/migrations/audit/002_retention.sql",
+		"/migrations/billing/002_invoices.sql",
+	}
+	c.Assert(ordering(files), qt.DeepEquals, expected)
+
+	// The order of the input doesn't matter.
+	reversed := pgdbtemplate.ReverseMigrationFilesSorting(files)
+	c.Assert(ordering(reversed), qt.DeepEquals, expected)
+
+	// Verify original slice wasn't modified.
+	c.Assert(files[0], qt.Equals, "/migrations/billing/002_invoices.sql")
+}
@@ -0,0 +1,235 @@
+package pgdbtemplate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate/internal/formatters"
+)
+
+var (
+	// copyFromStdinRegexp matches any COPY ... FROM stdin statement.
+	copyFromStdinRegexp = regexp.MustCompile(`(?i)^COPY\s.*\sFROM\s+stdin\b`)
+	// pgDumpCopyRegexp matches the COPY ... FROM stdin statements written
+	// by pg_dump, capturing the table name and the column list.
+	pgDumpCopyRegexp = regexp.MustCompile(`(?i)^COPY\s+(.+?)\s+FROM\s+stdin\s*;\s*$`)
+)
+
+// checkPgDumpConstructs returns an error if the migration contains
+// psql meta-commands or COPY ... FROM stdin blocks, which cannot be
+// executed by the server.
+func checkPgDumpConstructs(sql string) error {
+	return checkPgDumpConstructsFrom(sql, 1)
+}
+
+// checkPgDumpConstructsFrom is checkPgDumpConstructs for a part of
+// a migration starting on the given line.
+func checkPgDumpConstructsFrom(sql string, firstLine int) error {
+	var scanner sqlLineScanner
+	for i, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		switch {
+		case !scanner.inCode():
+		case strings.HasPrefix(line, `\`):
+			return fmt.Errorf("line %d: psql meta-command %q cannot be executed: use WithPgDumpCompat to skip it", firstLine+i, line)
+		case copyFromStdinRegexp.MatchString(line):
+			return fmt.Errorf("line %d: COPY FROM stdin cannot be executed: use WithPgDumpCompat to translate it into INSERT statements", firstLine+i)
+		}
+		scanner.scan(line)
+	}
+	return nil
+}
+
+// translatePgDump removes psql meta-commands from the migration
+// and translates COPY ... FROM stdin blocks into INSERT statements.
+func translatePgDump(sql string) (string, error) {
+	var out strings.Builder
+	var scanner sqlLineScanner
+	lines := strings.Split(sql, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if scanner.inCode() && strings.HasPrefix(line, `\`) {
+			continue
+		}
+		if !scanner.inCode() || !copyFromStdinRegexp.MatchString(line) {
+			out.WriteString(line)
+			out.WriteByte('\n')
+			scanner.scan(line)
+			continue
+		}
+
+		match := pgDumpCopyRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return "", fmt.Errorf("line %d: only COPY ... FROM stdin; in text format is supported", i+1)
+		}
+		start := i
+		for i++; ; i++ {
+			if i == len(lines) {
+				return "", fmt.Errorf(`line %d: COPY FROM stdin block is not terminated with \.`, start+1)
+			}
+			row := strings.TrimSuffix(lines[i], "\r")
+			if row == `\.` {
+				break
+			}
+			out.WriteString("INSERT INTO " + match[1] + " VALUES (" + copyRowValues(row) + ");\n")
+		}
+	}
+	return out.String(), nil
+}
+
+// sqlLineScanner tracks whether the lines of a migration start inside
+// a quoted string or identifier, a dollar-quoted string or a block
+// comment, where psql meta-commands and COPY statements cannot start.
+type sqlLineScanner struct {
+	quote        byte   // Quote of the string the last line ended in.
+	escapes      bool   // Whether backslashes are escapes in the string.
+	dollarTag    string // Tag of the dollar-quoted string the last line ended in.
+	commentDepth int    // Nesting depth of the block comment the last line ended in.
+}
+
+// inCode reports whether the next line starts outside of quotes and comments.
+func (s *sqlLineScanner) inCode() bool {
+	return s.quote == 0 && s.dollarTag == "" && s.commentDepth == 0
+}
+
+// scan updates the state with a line.
+func (s *sqlLineScanner) scan(line string) {
+	// The previous two bytes outside of quotes and comments.
+	var prev, beforePrev byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case s.commentDepth > 0:
+			if strings.HasPrefix(line[i:], "*/") {
+				s.commentDepth--
+				i++
+			} else if strings.HasPrefix(line[i:], "/*") {
+				s.commentDepth++
+				i++
+			}
+			continue
+		case s.quote != 0:
+			if c == '\\' && s.escapes {
+				i++
+			} else if c == s.quote {
+				s.quote = 0
+			}
+			continue
+		case s.dollarTag != "":
+			if strings.HasPrefix(line[i:], s.dollarTag) {
+				i += len(s.dollarTag) - 1
+				s.dollarTag = ""
+			}
+			continue
+		case strings.HasPrefix(line[i:], "--"):
+			return
+		case strings.HasPrefix(line[i:], "/*"):
+			s.commentDepth = 1
+			i++
+		case c == '\'':
+			// Backslashes are escapes in E'...' strings only.
+			s.quote = c
+			s.escapes = (prev == 'E' || prev == 'e') && !isIdentifierByte(beforePrev)
+		case c == '"':
+			s.quote = c
+			s.escapes = false
+		case c == '$' && !isIdentifierByte(prev):
+			if tag := dollarQuoteTag(line[i:]); tag != "" {
+				s.dollarTag = tag
+				i += len(tag) - 1
+			}
+		}
+		beforePrev, prev = prev, c
+	}
+}
+
+// dollarQuoteTag returns the tag, e.g. "$body$", of the dollar-quoted
+// string s starts with, or "" if the $ starts e.g. a parameter instead.
+func dollarQuoteTag(s string) string {
+	i := 1
+	for i < len(s) && isIdentifierByte(s[i]) && !(i == 1 && isDigitByte(s[i])) {
+		i++
+	}
+	if i == len(s) || s[i] != '$' {
+		return ""
+	}
+	return s[:i+1]
+}
+
+// copyRowValues converts a row of COPY text format data
+// into a list of SQL literals.
+func copyRowValues(row string) string {
+	fields := strings.Split(row, "\t")
+	for i, field := range fields {
+		if field == `\N` {
+			fields[i] = "NULL"
+			continue
+		}
+		fields[i] = formatters.QuoteLiteral(unescapeCopyText(field))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// unescapeCopyText resolves the backslash escapes of COPY text format.
+func unescapeCopyText(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch c = field[i]; {
+		case c == 'b':
+			b.WriteByte('\b')
+		case c == 'f':
+			b.WriteByte('\f')
+		case c == 'n':
+			b.WriteByte('\n')
+		case c == 'r':
+			b.WriteByte('\r')
+		case c == 't':
+			b.WriteByte('\t')
+		case c == 'v':
+			b.WriteByte('\v')
+		case c >= '0' && c <= '7':
+			// Up to three octal digits.
+			end := i + 1
+			for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+				end++
+			}
+			value, _ := strconv.ParseUint(field[i:end], 8, 16)
+			b.WriteByte(byte(value))
+			i = end - 1
+		case c == 'x':
+			// Up to two hexadecimal digits.
+			end := i + 1
+			for end < len(field) && end < i+3 && isHexDigit(field[end]) {
+				end++
+			}
+			if end == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			value, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			b.WriteByte(byte(value))
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// isHexDigit reports whether c is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,152 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// pgDumpSnippet is a representative excerpt of a plain pg_dump output.
+const pgDumpSnippet = `--
+-- PostgreSQL database dump
+--
+
+\restrict abc123
+
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+\connect app
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name text,
+    bio text
+);
+
+COPY public.users (id, name, bio) FROM stdin;
+1	Alice	\N
+2	O'Brien	line one\nline two\ttabbed
+3	back\\slash	\101\x42
+\.
+
+\unrestrict abc123
+`
+
+// TestFileMigrationRunnerPgDump tests migrations written by pg_dump.
+func TestFileMigrationRunnerPgDump(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	writeMigration := func(c *qt.C, content string) string {
+		dir := c.TempDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "001_dump.sql"), []byte(content), 0644), qt.IsNil)
+		return dir
+	}
+
+	c.Run("Translated with compat", func(c *qt.C) {
+		conn := &mockDatabaseConnection{}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{writeMigration(c, pgDumpSnippet)}, nil, pgdbtemplate.WithPgDumpCompat())
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.DeepEquals, []string{`--
+-- PostgreSQL database dump
+--
+
+
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name text,
+    bio text
+);
+
+INSERT INTO public.users (id, name, bio) VALUES ('1', 'Alice', NULL);
+INSERT INTO public.users (id, name, bio) VALUES ('2', 'O''Brien', 'line one
+line two	tabbed');
+INSERT INTO public.users (id, name, bio) VALUES ('3',  E'back\\slash', 'AB');
+
+
+`})
+	})
+
+	c.Run("Quoted and commented constructs", func(c *qt.C) {
+		// Lines inside strings, dollar-quoted bodies and block
+		// comments are not meta-commands or COPY statements.
+		const migration = `CREATE FUNCTION escape_path(path text) RETURNS text AS $$
+\\ is doubled by the caller
+SELECT replace(path, '\', '\\');
+$$ LANGUAGE sql;
+/*
+COPY users (id) FROM stdin;
+*/
+COMMENT ON TABLE users IS 'Imported with
+\copy users FROM users.csv
+and E''\'' escapes';
+SELECT E'it\'s
+\connect app';
+`
+		conn := &mockDatabaseConnection{}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{writeMigration(c, migration)}, nil)
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.DeepEquals, []string{migration})
+
+		conn = &mockDatabaseConnection{}
+		runner = pgdbtemplate.NewFileMigrationRunner([]string{writeMigration(c, migration)}, nil, pgdbtemplate.WithPgDumpCompat())
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.DeepEquals, []string{migration + "\n"})
+
+		// Meta-commands after the quoted constructs are still found.
+		runner = pgdbtemplate.NewFileMigrationRunner([]string{writeMigration(c, migration+"\\connect app\n")}, nil)
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*001_dump.sql" \(1/1\): line 13: psql meta-command "\\\\connect app" .*`)
+	})
+
+	tests := []struct {
+		name        string
+		content     string
+		compat      bool
+		expectedErr string
+	}{{
+		name:        "Meta-command without compat",
+		content:     pgDumpSnippet,
+		expectedErr: `failed to execute migration ".*001_dump.sql" \(1/1\): line 5: psql meta-command "\\\\restrict abc123" cannot be executed: use WithPgDumpCompat to skip it`,
+	}, {
+		name:        "COPY without compat",
+		content:     "CREATE TABLE t (id int);\nCOPY t (id) FROM stdin;\n1\n\\.\n",
+		expectedErr: `failed to execute migration ".*001_dump.sql" \(1/1\): line 2: COPY FROM stdin cannot be executed: use WithPgDumpCompat to translate it into INSERT statements`,
+	}, {
+		name:        "Unterminated COPY",
+		content:     "COPY t (id) FROM stdin;\n1\n2\n",
+		compat:      true,
+		expectedErr: `failed to execute migration ".*001_dump.sql" \(1/1\): line 1: COPY FROM stdin block is not terminated with \\.`,
+	}, {
+		name:        "Unsupported COPY format",
+		content:     "COPY t (id) FROM stdin WITH (FORMAT csv);\n1\n\\.\n",
+		compat:      true,
+		expectedErr: `failed to execute migration ".*001_dump.sql" \(1/1\): line 1: only COPY ... FROM stdin; in text format is supported`,
+	}}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			var opts []pgdbtemplate.FileMigrationRunnerOption
+			if test.compat {
+				opts = append(opts, pgdbtemplate.WithPgDumpCompat())
+			}
+			conn := &mockDatabaseConnection{}
+			runner := pgdbtemplate.NewFileMigrationRunner([]string{writeMigration(c, test.content)}, nil, opts...)
+			err := runner.RunMigrations(ctx, conn)
+			c.Assert(err, qt.ErrorMatches, test.expectedErr)
+			c.Assert(conn.executed, qt.HasLen, 0)
+		})
+	}
+}
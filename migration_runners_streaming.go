@@ -0,0 +1,212 @@
+package pgdbtemplate
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// statementReader splits SQL read from a reader into statements at the
+// semicolons outside of quoted strings and identifiers, dollar-quoted
+// strings and comments, holding a single statement in memory at a time.
+type statementReader struct {
+	r    *bufio.Reader
+	buf  strings.Builder
+	line int // Line the reader is on.
+}
+
+// newStatementReader returns a statementReader reading from r,
+// skipping a leading UTF-8 byte order mark.
+func newStatementReader(r io.Reader) *statementReader {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
+	}
+	return &statementReader{r: br, line: 1}
+}
+
+// next returns the next statement without its terminating semicolon,
+// and the line of the file its first line is on. The statement is not
+// trimmed, so that its lines can be matched to the lines of the file.
+//
+// Statements consisting of whitespace and comments only are skipped.
+// io.EOF is returned after the last statement.
+func (sr *statementReader) next() (statement string, line int, err error) {
+	sr.buf.Reset()
+	line = sr.line
+	hasCode := false
+	// The previous two bytes outside of quotes and comments.
+	var prev, beforePrev byte
+	for {
+		c, err := sr.readByte()
+		if err == io.EOF && hasCode {
+			return sr.buf.String(), line, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+
+		isCode := !isSpaceByte(c)
+		switch {
+		case c == ';':
+			if hasCode {
+				return strings.TrimSuffix(sr.buf.String(), ";"), line, nil
+			}
+			// Skip empty statements.
+			sr.buf.Reset()
+			line = sr.line
+			continue
+		case c == '-' && sr.peekIs("-"):
+			isCode = false
+			err = sr.skipLineComment()
+		case c == '/' && sr.peekIs("*"):
+			isCode = false
+			err = sr.skipBlockComment()
+		case c == '\'':
+			// Backslashes are escapes in E'...' strings only.
+			isEscapeString := (prev == 'E' || prev == 'e') && !isIdentifierByte(beforePrev)
+			err = sr.skipQuoted('\'', isEscapeString)
+		case c == '"':
+			err = sr.skipQuoted('"', false)
+		case c == '$' && !isIdentifierByte(prev):
+			err = sr.skipDollarQuoted()
+		}
+		hasCode = hasCode || isCode
+		beforePrev, prev = prev, c
+
+		switch {
+		case err == io.EOF && hasCode:
+			// An unterminated string or comment ends the statement,
+			// and the server reports it.
+			return sr.buf.String(), line, nil
+		case err != nil:
+			return "", 0, err
+		}
+	}
+}
+
+// readByte reads a byte into the statement.
+func (sr *statementReader) readByte() (byte, error) {
+	c, err := sr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	sr.buf.WriteByte(c)
+	if c == '\n' {
+		sr.line++
+	}
+	return c, nil
+}
+
+// peekIs reports whether the next bytes are s, without reading them.
+func (sr *statementReader) peekIs(s string) bool {
+	next, _ := sr.r.Peek(len(s))
+	return string(next) == s
+}
+
+// skipLineComment reads the rest of a -- comment.
+func (sr *statementReader) skipLineComment() error {
+	for {
+		c, err := sr.readByte()
+		if err != nil || c == '\n' {
+			return err
+		}
+	}
+}
+
+// skipBlockComment reads the rest of a /* comment, which may be nested.
+func (sr *statementReader) skipBlockComment() error {
+	if _, err := sr.readByte(); err != nil {
+		return err
+	}
+	for depth := 1; depth > 0; {
+		c, err := sr.readByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '*' && sr.peekIs("/"):
+			depth--
+		case c == '/' && sr.peekIs("*"):
+			depth++
+		default:
+			continue
+		}
+		if _, err := sr.readByte(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipQuoted reads the rest of a string or identifier quoted with quote.
+// Doubled quotes don't need special handling, as they end and restart it.
+func (sr *statementReader) skipQuoted(quote byte, backslashEscapes bool) error {
+	for {
+		c, err := sr.readByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == quote:
+			return nil
+		case c == '\\' && backslashEscapes:
+			if _, err := sr.readByte(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skipDollarQuoted reads the rest of a dollar-quoted string, e.g. a
+// function body, if the $ starts one rather than e.g. a parameter.
+func (sr *statementReader) skipDollarQuoted() error {
+	// The tag is at most as long as an identifier.
+	next, _ := sr.r.Peek(maxIdentifierLength + 1)
+	i := 0
+	for i < len(next) && isIdentifierByte(next[i]) && !(i == 0 && isDigitByte(next[i])) {
+		i++
+	}
+	if i == len(next) || next[i] != '$' {
+		return nil
+	}
+	tag := "$" + string(next[:i+1])
+	for j := 0; j <= i; j++ {
+		if _, err := sr.readByte(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		c, err := sr.readByte()
+		if err != nil {
+			return err
+		}
+		if c != '$' || !sr.peekIs(tag[1:]) {
+			continue
+		}
+		for j := 1; j < len(tag); j++ {
+			if _, err := sr.readByte(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// isIdentifierByte reports whether c can be part of an unquoted
+// identifier. Bytes of multi-byte UTF-8 characters are included.
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= 0x80 || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigitByte(c)
+}
+
+// isDigitByte reports whether c is an ASCII digit.
+func isDigitByte(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isSpaceByte reports whether c is ASCII whitespace.
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,202 @@
+package pgdbtemplate_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestFileMigrationRunnerStreaming tests that streamed migration files
+// are split into statements at the semicolons outside of quoted strings
+// and comments.
+func TestFileMigrationRunnerStreaming(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	schema := "\xef\xbb\xbf-- Users; and their names.\n" +
+		"CREATE TABLE users (id INT, name TEXT DEFAULT 'a;b');\n" +
+		"/* Nested /* comments; */ are skipped; */\n" +
+		`CREATE TABLE "odd;name" (note TEXT DEFAULT E'it\'s;');` + "\n" +
+		";;\n" +
+		"CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END;\n\\ $body$ LANGUAGE plpgsql;\n" +
+		"SELECT $$a;b$$, $1\n"
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql"), []byte(schema), 0644), qt.IsNil)
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte("INSERT INTO users VALUES (1, 'x');\nINSERT INTO users VALUES (2, 'y');\n-- The end."))
+	c.Assert(err, qt.IsNil)
+	c.Assert(writer.Close(), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_seed.sql.gz"), compressed.Bytes(), 0644), qt.IsNil)
+
+	conn := &mockDatabaseConnection{}
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithStreaming())
+	c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+	c.Assert(conn.executed, qt.DeepEquals, []string{
+		"-- Users; and their names.\nCREATE TABLE users (id INT, name TEXT DEFAULT 'a;b')",
+		"/* Nested /* comments; */ are skipped; */\n" + `CREATE TABLE "odd;name" (note TEXT DEFAULT E'it\'s;')`,
+		"CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END;\n\\ $body$ LANGUAGE plpgsql",
+		"SELECT $$a;b$$, $1",
+		"INSERT INTO users VALUES (1, 'x')",
+		"INSERT INTO users VALUES (2, 'y')",
+	})
+}
+
+// TestFileMigrationRunnerStreamingBoundedMemory tests that streamed
+// migration files are not read ahead of the executed statements.
+func TestFileMigrationRunnerStreamingBoundedMemory(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_seed.sql"), nil, 0644), qt.IsNil)
+
+	const statementCount = 200000
+	source := &statementSource{count: statementCount}
+	conn := &readAheadConnection{source: source}
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil,
+		pgdbtemplate.WithStreaming(),
+		pgdbtemplate.WithFileOpener(func(path string) (io.ReadCloser, error) {
+			return source, nil
+		}),
+	)
+	c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+	c.Assert(conn.statements, qt.Equals, statementCount)
+	c.Assert(source.closed, qt.IsTrue)
+	// The file is several megabytes long, but only a buffer
+	// of a few kilobytes is read ahead.
+	c.Assert(source.read > 5<<20, qt.IsTrue, qt.Commentf("read %d bytes", source.read))
+	c.Assert(conn.maxReadAhead <= 8<<10, qt.IsTrue, qt.Commentf("read %d bytes ahead", conn.maxReadAhead))
+}
+
+// TestFileMigrationRunnerStreamingErrors tests the errors
+// of streamed migration files.
+func TestFileMigrationRunnerStreamingErrors(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Failing statement", func(c *qt.C) {
+		dir := c.TempDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql"),
+			[]byte("CREATE TABLE users (id INT);\n\n-- Broken.\nTHIS IS NOT VALID;\nCREATE TABLE orders (id INT);"), 0644), qt.IsNil)
+
+		conn := &mockDatabaseConnection{failOnInvalid: true}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithStreaming())
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*001_schema.sql" \(1/1\): statement at line 3: invalid SQL`)
+		// The preceding statements stay applied.
+		c.Assert(conn.executed, qt.DeepEquals, []string{"CREATE TABLE users (id INT)"})
+	})
+
+	c.Run("psql meta-command", func(c *qt.C) {
+		dir := c.TempDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "001_dump.sql"),
+			[]byte("CREATE TABLE users (id INT);\n\\connect app\nCREATE TABLE orders (id INT);"), 0644), qt.IsNil)
+
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithStreaming())
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*001_dump.sql" \(1/1\): `+
+			`line 2: psql meta-command "\\\\connect app" cannot be executed: use WithPgDumpCompat to skip it`)
+	})
+
+	c.Run("Opening fails", func(c *qt.C) {
+		dir := c.TempDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql"), nil, 0644), qt.IsNil)
+
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil,
+			pgdbtemplate.WithStreaming(),
+			pgdbtemplate.WithFileOpener(func(path string) (io.ReadCloser, error) {
+				return nil, fmt.Errorf("key not found")
+			}),
+		)
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*001_schema.sql" \(1/1\): `+
+			`failed to open migration file ".*001_schema.sql": key not found`)
+	})
+
+	c.Run("Incompatible options", func(c *qt.C) {
+		runner := pgdbtemplate.NewFileMigrationRunner(nil, nil, pgdbtemplate.WithStreaming(), pgdbtemplate.WithPgDumpCompat())
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, "WithStreaming cannot be used together with WithPgDumpCompat")
+
+		runner = pgdbtemplate.NewFileMigrationRunner(nil, nil, pgdbtemplate.WithStreaming(), pgdbtemplate.WithFileReader(os.ReadFile))
+		err = runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, "WithStreaming cannot be used together with WithFileReader, use WithFileOpener instead")
+	})
+}
+
+// statementSource generates a migration file of count INSERT
+// statements on the fly, counting the bytes read from it.
+type statementSource struct {
+	count int
+
+	generated int
+	pending   []byte
+	read      int
+	closed    bool
+}
+
+// Read implements io.Reader.Read.
+func (s *statementSource) Read(p []byte) (int, error) {
+	for len(s.pending) < len(p) && s.generated < s.count {
+		s.pending = append(s.pending, statementSourceLine(s.generated)...)
+		s.generated++
+	}
+	if len(s.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	s.read += n
+	return n, nil
+}
+
+// Close implements io.Closer.Close.
+func (s *statementSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// statementSourceLine returns the i-th line generated by statementSource.
+func statementSourceLine(i int) string {
+	return fmt.Sprintf("INSERT INTO events VALUES (%d, 'some payload');\n", i)
+}
+
+// readAheadConnection records how far the statementSource
+// was read ahead of the executed statements.
+type readAheadConnection struct {
+	mockDatabaseConnection
+	source *statementSource
+
+	statements   int
+	executed     int
+	maxReadAhead int
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *readAheadConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	line := statementSourceLine(c.statements)
+	if query != strings.TrimSuffix(line, ";\n") {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	c.statements++
+	c.executed += len(line)
+	if readAhead := c.source.read - c.executed; readAhead > c.maxReadAhead {
+		c.maxReadAhead = readAhead
+	}
+	return nil, nil
+}
@@ -1,9 +1,16 @@
 package pgdbtemplate_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -107,6 +114,592 @@ func TestFileMigrationRunnerErrors(t *testing.T) {
 	})
 }
 
+// TestFileMigrationRunnerResolveFiles tests that ResolveFiles returns
+// the files in execution order across multiple directories.
+func TestFileMigrationRunnerResolveFiles(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	dir1 := c.TempDir()
+	dir2 := c.TempDir()
+	for _, file := range []string{
+		filepath.Join(dir1, "002_orders.sql"),
+		filepath.Join(dir1, "001_users.sql"),
+		filepath.Join(dir1, "README.md"),
+		filepath.Join(dir2, "001_seed.sql"),
+	} {
+		c.Assert(os.WriteFile(file, []byte("SELECT 1;"), 0644), qt.IsNil)
+	}
+
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir2, dir1}, nil)
+	files, err := runner.ResolveFiles()
+	c.Assert(err, qt.IsNil)
+	c.Assert(files, qt.DeepEquals, []string{
+		filepath.Join(dir2, "001_seed.sql"),
+		filepath.Join(dir1, "001_users.sql"),
+		filepath.Join(dir1, "002_orders.sql"),
+	})
+
+	runner = pgdbtemplate.NewFileMigrationRunner([]string{dir1, "/non/existent/path"}, nil)
+	_, err = runner.ResolveFiles()
+	c.Assert(err, qt.ErrorMatches, `failed to collect files from "/non/existent/path": .*`)
+}
+
+// TestFileMigrationRunnerGlobalOrdering tests ordering files
+// of all paths at once instead of per path.
+func TestFileMigrationRunnerGlobalOrdering(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	coreDir := c.TempDir()
+	billingDir := c.TempDir()
+	for _, file := range []string{
+		filepath.Join(coreDir, "001_users.sql"),
+		filepath.Join(coreDir, "003_orders.sql"),
+		filepath.Join(billingDir, "002_invoices.sql"),
+	} {
+		c.Assert(os.WriteFile(file, []byte("SELECT 1;"), 0644), qt.IsNil)
+	}
+	byBaseName := func(files []string) []string {
+		sorted := append([]string(nil), files...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return filepath.Base(sorted[i]) < filepath.Base(sorted[j])
+		})
+		return sorted
+	}
+
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{coreDir, billingDir}, byBaseName)
+	files, err := runner.ResolveFiles()
+	c.Assert(err, qt.IsNil)
+	c.Assert(files, qt.DeepEquals, []string{
+		filepath.Join(coreDir, "001_users.sql"),
+		filepath.Join(coreDir, "003_orders.sql"),
+		filepath.Join(billingDir, "002_invoices.sql"),
+	})
+
+	runner = pgdbtemplate.NewFileMigrationRunner([]string{coreDir, billingDir}, byBaseName, pgdbtemplate.WithGlobalOrdering())
+	files, err = runner.ResolveFiles()
+	c.Assert(err, qt.IsNil)
+	c.Assert(files, qt.DeepEquals, []string{
+		filepath.Join(coreDir, "001_users.sql"),
+		filepath.Join(billingDir, "002_invoices.sql"),
+		filepath.Join(coreDir, "003_orders.sql"),
+	})
+
+	// The order of the paths no longer matters.
+	runner = pgdbtemplate.NewFileMigrationRunner([]string{billingDir, coreDir}, byBaseName, pgdbtemplate.WithGlobalOrdering())
+	reversedFiles, err := runner.ResolveFiles()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reversedFiles, qt.DeepEquals, files)
+}
+
+// TestFileMigrationRunnerRecursive tests collecting migration files
+// from nested directories.
+func TestFileMigrationRunnerRecursive(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	for _, file := range []string{
+		"000_extensions.sql",
+		"users/001_users.sql",
+		"users/002_profiles.sql",
+		"billing/001_invoices.sql",
+		"billing/legacy/003_payments.sql",
+		"billing/README.md",
+	} {
+		path := filepath.Join(dir, file)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), qt.IsNil)
+		c.Assert(os.WriteFile(path, []byte("-- "+file), 0644), qt.IsNil)
+	}
+
+	c.Run("Top level only by default", func(c *qt.C) {
+		files, err := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil).ResolveFiles()
+		c.Assert(err, qt.IsNil)
+		c.Assert(files, qt.DeepEquals, []string{filepath.Join(dir, "000_extensions.sql")})
+	})
+
+	c.Run("Ordered by full path", func(c *qt.C) {
+		conn := &mockDatabaseConnection{}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithRecursive())
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.DeepEquals, []string{
+			"-- 000_extensions.sql",
+			"-- billing/001_invoices.sql",
+			"-- billing/legacy/003_payments.sql",
+			"-- users/001_users.sql",
+			"-- users/002_profiles.sql",
+		})
+	})
+
+	c.Run("Custom ordering across directories", func(c *qt.C) {
+		byBaseName := func(files []string) []string {
+			sorted := append([]string(nil), files...)
+			sort.SliceStable(sorted, func(i, j int) bool {
+				return filepath.Base(sorted[i]) < filepath.Base(sorted[j])
+			})
+			return sorted
+		}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, byBaseName, pgdbtemplate.WithRecursive())
+		files, err := runner.ResolveFiles()
+		c.Assert(err, qt.IsNil)
+		c.Assert(files, qt.DeepEquals, []string{
+			filepath.Join(dir, "000_extensions.sql"),
+			filepath.Join(dir, "billing/001_invoices.sql"),
+			filepath.Join(dir, "users/001_users.sql"),
+			filepath.Join(dir, "users/002_profiles.sql"),
+			filepath.Join(dir, "billing/legacy/003_payments.sql"),
+		})
+	})
+
+	c.Run("Non-existent directory", func(c *qt.C) {
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{"/non/existent/path"}, nil, pgdbtemplate.WithRecursive())
+		_, err := runner.ResolveFiles()
+		c.Assert(err, qt.ErrorMatches, `failed to collect files from "/non/existent/path": failed to walk directory .*`)
+	})
+}
+
+// TestFileMigrationRunnerFileFilters tests selecting migration files
+// by extension and excluding them by glob patterns.
+func TestFileMigrationRunnerFileFilters(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	dir := c.TempDir()
+	for _, file := range []string{
+		"001_users.up.sql",
+		"001_users.down.sql",
+		"002_functions.pgsql",
+		"003_views.ddl",
+		"004_orders.sql",
+		"004_orders.down.sql",
+		"notes.txt",
+	} {
+		c.Assert(os.WriteFile(filepath.Join(dir, file), []byte("SELECT 1;"), 0644), qt.IsNil)
+	}
+
+	tests := []struct {
+		name          string
+		opts          []pgdbtemplate.FileMigrationRunnerOption
+		expectedFiles []string
+	}{{
+		name: "Defaults",
+		expectedFiles: []string{
+			"001_users.down.sql",
+			"001_users.up.sql",
+			"004_orders.down.sql",
+			"004_orders.sql",
+		},
+	}, {
+		name: "Exclude down migrations",
+		opts: []pgdbtemplate.FileMigrationRunnerOption{pgdbtemplate.WithExclude("*.down.sql")},
+		expectedFiles: []string{
+			"001_users.up.sql",
+			"004_orders.sql",
+		},
+	}, {
+		name: "Mixed extensions",
+		opts: []pgdbtemplate.FileMigrationRunnerOption{
+			pgdbtemplate.WithExtensions(".sql", ".pgsql", ".ddl"),
+			pgdbtemplate.WithExclude("*.down.sql"),
+		},
+		expectedFiles: []string{
+			"001_users.up.sql",
+			"002_functions.pgsql",
+			"003_views.ddl",
+			"004_orders.sql",
+		},
+	}, {
+		name: "Several exclude patterns",
+		opts: []pgdbtemplate.FileMigrationRunnerOption{
+			pgdbtemplate.WithExtensions(".sql", ".pgsql"),
+			pgdbtemplate.WithExclude("*.down.sql", "00[24]_*"),
+		},
+		expectedFiles: []string{
+			"001_users.up.sql",
+		},
+	}}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, test.opts...)
+			files, err := runner.ResolveFiles()
+			c.Assert(err, qt.IsNil)
+
+			var names []string
+			for _, file := range files {
+				names = append(names, filepath.Base(file))
+			}
+			c.Assert(names, qt.DeepEquals, test.expectedFiles)
+		})
+	}
+
+	c.Run("Invalid exclude pattern", func(c *qt.C) {
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithExclude("[*.sql"))
+		_, err := runner.ResolveFiles()
+		c.Assert(err, qt.ErrorMatches, `failed to collect files from ".*": invalid exclude pattern "\[\*\.sql": syntax error in pattern`)
+	})
+}
+
+// TestSQLMigrationRunner tests the in-memory migration runner.
+func TestSQLMigrationRunner(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Statements executed in order", func(c *qt.C) {
+		conn := &mockDatabaseConnection{}
+		runner := pgdbtemplate.NewSQLMigrationRunner(
+			"CREATE TABLE users (id INT)",
+			"INSERT INTO users VALUES (1)",
+		)
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.DeepEquals, []string{
+			"CREATE TABLE users (id INT)",
+			"INSERT INTO users VALUES (1)",
+		})
+	})
+
+	c.Run("No statements", func(c *qt.C) {
+		conn := &mockDatabaseConnection{}
+		c.Assert(pgdbtemplate.NewSQLMigrationRunner().RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.HasLen, 0)
+	})
+
+	c.Run("Failure stops execution", func(c *qt.C) {
+		conn := &mockDatabaseConnection{failOnInvalid: true}
+		runner := pgdbtemplate.NewSQLMigrationRunner(
+			"CREATE TABLE users (id INT)",
+			"THIS IS NOT VALID SQL",
+			"INSERT INTO users VALUES (1)",
+		)
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, "failed to execute statement 2: invalid SQL")
+		c.Assert(conn.executed, qt.DeepEquals, []string{"CREATE TABLE users (id INT)"})
+	})
+}
+
+// TestFileMigrationRunnerPerFileTimeout tests that a migration file
+// exceeding the per-file timeout is cancelled.
+func TestFileMigrationRunnerPerFileTimeout(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql"), []byte("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_backfill.sql"), []byte("SELECT pg_sleep(3600);"), 0644), qt.IsNil)
+
+	c.Run("Slow file is cancelled", func(c *qt.C) {
+		conn := &blockingDatabaseConnection{block: "pg_sleep"}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithPerFileTimeout(10*time.Millisecond))
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*002_backfill.sql" \(2/2\): timed out after 10ms: context deadline exceeded`)
+		c.Assert(errors.Is(err, context.DeadlineExceeded), qt.IsTrue)
+		c.Assert(conn.executed, qt.DeepEquals, []string{"CREATE TABLE users (id INT);"})
+	})
+
+	c.Run("Parent context cancellation is not a timeout", func(c *qt.C) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		conn := &blockingDatabaseConnection{block: "pg_sleep"}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithPerFileTimeout(time.Hour))
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*002_backfill.sql" \(2/2\): context deadline exceeded`)
+	})
+
+	c.Run("Fast files are not affected", func(c *qt.C) {
+		conn := &blockingDatabaseConnection{}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithPerFileTimeout(time.Hour))
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.HasLen, 2)
+	})
+}
+
+// TestFileMigrationRunnerProgress tests that the progress function
+// is called after every executed migration file.
+func TestFileMigrationRunnerProgress(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir1 := c.TempDir()
+	dir2 := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir1, "001_users.sql"), []byte("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir1, "002_invalid.sql"), []byte("THIS IS NOT VALID SQL;"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir2, "001_seed.sql"), []byte("INSERT INTO users VALUES (1);"), 0644), qt.IsNil)
+
+	type progressCall struct {
+		Done, Total int
+		File        string
+	}
+
+	c.Run("All files", func(c *qt.C) {
+		var calls []progressCall
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir1, dir2}, nil, pgdbtemplate.WithProgress(func(done, total int, file string) {
+			calls = append(calls, progressCall{done, total, file})
+		}))
+		c.Assert(runner.RunMigrations(ctx, &mockDatabaseConnection{}), qt.IsNil)
+		c.Assert(calls, qt.DeepEquals, []progressCall{
+			{1, 3, filepath.Join(dir1, "001_users.sql")},
+			{2, 3, filepath.Join(dir1, "002_invalid.sql")},
+			{3, 3, filepath.Join(dir2, "001_seed.sql")},
+		})
+	})
+
+	c.Run("Failing file is not reported", func(c *qt.C) {
+		var calls []progressCall
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir1, dir2}, nil, pgdbtemplate.WithProgress(func(done, total int, file string) {
+			calls = append(calls, progressCall{done, total, file})
+		}))
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{failOnInvalid: true})
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*002_invalid.sql" \(2/3\): invalid SQL`)
+		c.Assert(calls, qt.DeepEquals, []progressCall{
+			{1, 3, filepath.Join(dir1, "001_users.sql")},
+		})
+	})
+}
+
+// TestFileMigrationRunnerErrorPosition tests that errors report the position
+// of the failing file among all files, which tells apart files with
+// the same name in different directories.
+func TestFileMigrationRunnerErrorPosition(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	coreDir := c.TempDir()
+	pluginDir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(coreDir, "001_init.sql"), []byte("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(coreDir, "002_orders.sql"), []byte("CREATE TABLE orders (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(pluginDir, "001_init.sql"), []byte("THIS IS NOT VALID SQL;"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(pluginDir, "002_seed.sql"), []byte("INSERT INTO users VALUES (1);"), 0644), qt.IsNil)
+
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{coreDir, pluginDir}, nil)
+	err := runner.RunMigrations(ctx, &mockDatabaseConnection{failOnInvalid: true})
+	c.Assert(err, qt.ErrorMatches, fmt.Sprintf(`failed to execute migration "%s" \(3/4\): invalid SQL`,
+		regexp.QuoteMeta(filepath.Join(pluginDir, "001_init.sql"))))
+}
+
+// TestFileMigrationRunnerCancellation tests that no further files
+// are read or executed once the context is cancelled.
+func TestFileMigrationRunnerCancellation(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	dir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_users.sql"), []byte("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_orders.sql"), []byte("CREATE TABLE orders (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "003_seed.sql"), []byte("INSERT INTO users VALUES (1);"), 0644), qt.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel the context once the first file has been executed.
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithProgress(func(done, total int, file string) {
+		cancel()
+	}))
+	conn := &mockDatabaseConnection{}
+	err := runner.RunMigrations(ctx, conn)
+	c.Assert(err, qt.ErrorMatches, `migrations interrupted before ".*002_orders.sql": context canceled`)
+	c.Assert(errors.Is(err, context.Canceled), qt.IsTrue)
+	c.Assert(conn.executed, qt.DeepEquals, []string{"CREATE TABLE users (id INT);"})
+}
+
+// TestFileMigrationRunnerDryRun tests that no queries are executed
+// in dry-run mode, while resolution errors are still reported.
+func TestFileMigrationRunnerDryRun(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_users.sql"), []byte("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_invalid.sql"), []byte("THIS IS NOT VALID SQL;"), 0644), qt.IsNil)
+
+	c.Run("No queries executed", func(c *qt.C) {
+		conn := &mockDatabaseConnection{failOnInvalid: true}
+		var done []string
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil,
+			pgdbtemplate.WithDryRun(),
+			pgdbtemplate.WithProgress(func(_, _ int, file string) {
+				done = append(done, filepath.Base(file))
+			}),
+		)
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.HasLen, 0)
+		c.Assert(done, qt.DeepEquals, []string{"001_users.sql", "002_invalid.sql"})
+	})
+
+	c.Run("Missing directory", func(c *qt.C) {
+		conn := &mockDatabaseConnection{}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir, "/non/existent/path"}, nil, pgdbtemplate.WithDryRun())
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to collect files from "/non/existent/path": .*`)
+		c.Assert(conn.executed, qt.HasLen, 0)
+	})
+}
+
+// TestFileMigrationRunnerGzip tests running gzip-compressed migrations.
+func TestFileMigrationRunnerGzip(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte("CREATE TABLE users (id INT);"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(writer.Close(), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql.gz"), compressed.Bytes(), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.down.sql.gz"), compressed.Bytes(), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_data.sql"), []byte("INSERT INTO users VALUES (1);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "003_archive.tar.gz"), compressed.Bytes(), 0644), qt.IsNil)
+
+	c.Run("Decompressed and ordered with plain files", func(c *qt.C) {
+		conn := &mockDatabaseConnection{}
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithExclude("*.down.sql"))
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+		c.Assert(conn.executed, qt.DeepEquals, []string{
+			"CREATE TABLE users (id INT);",
+			"INSERT INTO users VALUES (1);",
+		})
+	})
+
+	c.Run("Corrupted file", func(c *qt.C) {
+		dir := c.TempDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql.gz"), []byte("this is not gzip content"), 0644), qt.IsNil)
+		runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil)
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*001_schema.sql.gz" \(1/1\): failed to decompress migration file ".*001_schema.sql.gz": gzip: invalid header`)
+	})
+}
+
+// TestFileMigrationRunnerFileReader tests that migration files
+// are read with the custom reader.
+func TestFileMigrationRunnerFileReader(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	encode := func(sql string) []byte {
+		return []byte(base64.StdEncoding.EncodeToString([]byte(sql)))
+	}
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql"), encode("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_data.sql"), encode("INSERT INTO users VALUES (1);"), 0644), qt.IsNil)
+
+	base64Reader := func(path string) ([]byte, error) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(string(content))
+	}
+
+	conn := &mockDatabaseConnection{}
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil, pgdbtemplate.WithFileReader(base64Reader))
+	c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+	c.Assert(conn.executed, qt.DeepEquals, []string{
+		"CREATE TABLE users (id INT);",
+		"INSERT INTO users VALUES (1);",
+	})
+
+	c.Run("Reader error", func(c *qt.C) {
+		c.Assert(os.WriteFile(filepath.Join(dir, "003_corrupted.sql"), []byte("not base64!"), 0644), qt.IsNil)
+		err := runner.RunMigrations(ctx, &mockDatabaseConnection{})
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*003_corrupted.sql" \(3/3\): `+
+			`failed to read migration file ".*003_corrupted.sql": illegal base64 data at input byte 3`)
+	})
+}
+
+// TestFileMigrationRunnerBOM tests that a UTF-8 byte order mark
+// is stripped from migration files.
+func TestFileMigrationRunnerBOM(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_schema.sql"), []byte("\xef\xbb\xbfCREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_data.sql"), []byte("INSERT INTO users VALUES ('\xef\xbb\xbf');"), 0644), qt.IsNil)
+
+	conn := &mockDatabaseConnection{}
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil)
+	c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+	c.Assert(conn.executed, qt.DeepEquals, []string{
+		"CREATE TABLE users (id INT);",
+		// Only a leading byte order mark is stripped.
+		"INSERT INTO users VALUES ('\xef\xbb\xbf');",
+	})
+}
+
+// TestFileMigrationRunnerIgnoreErrors tests skipping migration files
+// failing with ignored errors.
+func TestFileMigrationRunnerIgnoreErrors(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "001_users.sql"), []byte("CREATE TABLE users (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "002_orders.sql"), []byte("CREATE TABLE orders (id INT);"), 0644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "003_seed.sql"), []byte("INSERT INTO orders VALUES (1);"), 0644), qt.IsNil)
+
+	var ignored []string
+	ignoreDuplicates := func(err error) bool {
+		if pgdbtemplate.SQLState(err) != "42P07" {
+			return false
+		}
+		ignored = append(ignored, err.Error())
+		return true
+	}
+	var progress []int
+	conn := &duplicateTableConnection{existingTables: []string{"orders"}}
+	runner := pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil,
+		pgdbtemplate.WithIgnoreErrors(ignoreDuplicates),
+		pgdbtemplate.WithProgress(func(done, total int, file string) {
+			progress = append(progress, done)
+		}),
+	)
+	c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+	c.Assert(conn.executed, qt.DeepEquals, []string{
+		"CREATE TABLE users (id INT);",
+		"INSERT INTO orders VALUES (1);",
+	})
+	c.Assert(ignored, qt.DeepEquals, []string{`relation "orders" already exists`})
+	c.Assert(progress, qt.DeepEquals, []int{1, 2, 3})
+
+	c.Run("Other errors abort", func(c *qt.C) {
+		conn := &duplicateTableConnection{existingTables: []string{"orders"}}
+		conn.failOnInvalid = true
+		c.Assert(os.WriteFile(filepath.Join(dir, "004_invalid.sql"), []byte("THIS IS NOT VALID"), 0644), qt.IsNil)
+
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to execute migration ".*004_invalid.sql" \(4/4\): invalid SQL`)
+	})
+}
+
+// duplicateTableConnection is a mockDatabaseConnection
+// failing to create the existing tables.
+type duplicateTableConnection struct {
+	mockDatabaseConnection
+	existingTables []string
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *duplicateTableConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	for _, table := range c.existingTables {
+		if strings.HasPrefix(query, "CREATE TABLE "+table+" ") {
+			return nil, &mockPgError{code: "42P07", message: fmt.Sprintf("relation %q already exists", table)}
+		}
+	}
+	return c.mockDatabaseConnection.ExecContext(ctx, query, args...)
+}
+
 // TestNewFileMigrationRunnerBranches tests both branches of NewFileMigrationRunner.
 func TestNewFileMigrationRunnerBranches(t *testing.T) {
 	c := qt.New(t)
@@ -149,3 +742,18 @@ type migrationMockRow struct{}
 func (r *migrationMockRow) Scan(dest ...any) error {
 	return nil
 }
+
+// blockingDatabaseConnection is a mock connection blocking queries
+// containing block until their context is done.
+type blockingDatabaseConnection struct {
+	mockDatabaseConnection
+	block string
+}
+
+func (m *blockingDatabaseConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	if m.block != "" && strings.Contains(query, m.block) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return m.mockDatabaseConnection.ExecContext(ctx, query, args...)
+}
@@ -13,7 +13,12 @@ import (
 // databaseProvider is an interface for providers that manage databases.
 type databaseProvider interface {
 	getDatabases() map[string]bool
+	// getTemplates returns the databases marked as templates.
+	getTemplates() map[string]bool
 	getMutex() *sync.RWMutex
+	// getAdvisoryLocks returns the advisory locks taken on the
+	// connections, as channels holding a value while locked.
+	getAdvisoryLocks() *sync.Map
 }
 
 // sharedMockDatabaseConnection is a shared mock implementation of DatabaseConnection
@@ -23,9 +28,24 @@ type sharedMockDatabaseConnection struct {
 	dbName   string
 }
 
+// advisoryLock returns the advisory lock with the given key.
+func (m *sharedMockDatabaseConnection) advisoryLock(key string) chan struct{} {
+	lock, _ := m.provider.getAdvisoryLocks().LoadOrStore(key, make(chan struct{}, 1))
+	return lock.(chan struct{})
+}
+
 // ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
 func (m *sharedMockDatabaseConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
-	if strings.Contains(query, "CREATE DATABASE") {
+	if _, key, ok := strings.Cut(query, "pg_advisory_lock"); ok {
+		select {
+		case m.advisoryLock(key) <- struct{}{}:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else if _, key, ok := strings.Cut(query, "pg_advisory_unlock"); ok {
+		<-m.advisoryLock(key)
+	} else if strings.Contains(query, "CREATE DATABASE") {
 		parts := strings.Fields(query)
 		if len(parts) >= 3 {
 			dbName := strings.Trim(parts[2], `"`)
@@ -34,7 +54,10 @@ func (m *sharedMockDatabaseConnection) ExecContext(ctx context.Context, query st
 			databases := m.provider.getDatabases()
 			if databases[dbName] {
 				mu.Unlock()
-				return nil, fmt.Errorf("database %q already exists", dbName)
+				return nil, &mockPgError{
+					code:    "42P04",
+					message: fmt.Sprintf("database %q already exists", dbName),
+				}
 			}
 			databases[dbName] = true
 			mu.Unlock()
@@ -55,13 +78,31 @@ func (m *sharedMockDatabaseConnection) ExecContext(ctx context.Context, query st
 			databases := m.provider.getDatabases()
 			if databases[dbName] {
 				delete(databases, dbName)
+				delete(m.provider.getTemplates(), dbName)
 			} else if !(len(parts) >= 5 && parts[2] == "IF" && parts[3] == "EXISTS") {
 				// Only error if it's not an "IF EXISTS" query.
 				mu.Unlock()
-				return nil, fmt.Errorf("database %q does not exist", dbName)
+				return nil, &mockPgError{
+					code:    "3D000",
+					message: fmt.Sprintf("database %q does not exist", dbName),
+				}
 			}
 			mu.Unlock()
 		}
+	} else if strings.HasPrefix(query, "ALTER DATABASE") && strings.Contains(query, "WITH is_template") {
+		// Handle "ALTER DATABASE dbname WITH is_template TRUE|FALSE".
+		parts := strings.Fields(query)
+		dbName := strings.Trim(parts[2], `"`)
+		mu := m.provider.getMutex()
+		mu.Lock()
+		defer mu.Unlock()
+		if !m.provider.getDatabases()[dbName] {
+			return nil, &mockPgError{
+				code:    "3D000",
+				message: fmt.Sprintf("database %q does not exist", dbName),
+			}
+		}
+		m.provider.getTemplates()[dbName] = parts[len(parts)-1] == "TRUE"
 	}
 	return nil, nil
 }
@@ -101,6 +142,18 @@ func (m *sharedMockDatabaseConnection) QueryRowContext(ctx context.Context, quer
 		}
 		return &sharedMockRow{err: sql.ErrNoRows}
 	}
+	if strings.Contains(query, "SELECT datistemplate FROM pg_database WHERE datname =") {
+		// Literal query: SELECT datistemplate FROM pg_database WHERE datname = 'db_name' LIMIT 1
+		dbName := strings.TrimSpace(strings.Split(query, "WHERE datname =")[1])
+		dbName = strings.Trim(strings.TrimSuffix(dbName, " LIMIT 1"), "'")
+		mu := m.provider.getMutex()
+		mu.RLock()
+		defer mu.RUnlock()
+		if m.provider.getDatabases()[dbName] {
+			return &sharedMockRow{data: []any{m.provider.getTemplates()[dbName]}}
+		}
+		return &sharedMockRow{err: sql.ErrNoRows}
+	}
 	if strings.Contains(query, "SELECT 1 FROM pg_database") {
 		if len(args) > 0 {
 			if dbName, ok := args[0].(string); ok {
@@ -116,6 +169,9 @@ func (m *sharedMockDatabaseConnection) QueryRowContext(ctx context.Context, quer
 		}
 		return &sharedMockRow{err: sql.ErrNoRows}
 	}
+	if query == "SELECT current_database()" {
+		return &sharedMockRow{data: []any{m.dbName}}
+	}
 	if strings.Contains(query, "SELECT 1 FROM information_schema.tables") {
 		// Assume test_table exists if db exists
 		mu := m.provider.getMutex()
@@ -136,6 +192,23 @@ func (m *sharedMockDatabaseConnection) Close() error {
 	return nil
 }
 
+// mockPgError mimics the errors of PostgreSQL drivers,
+// such as *pq.Error and *pgconn.PgError, which expose the SQLSTATE.
+type mockPgError struct {
+	code    string
+	message string
+}
+
+// Error implements error.Error.
+func (e *mockPgError) Error() string {
+	return e.message
+}
+
+// SQLState returns the PostgreSQL error code.
+func (e *mockPgError) SQLState() string {
+	return e.code
+}
+
 // sharedMockRow is a shared mock implementation of pgdbtemplate.Row.
 type sharedMockRow struct {
 	data []any
@@ -146,6 +219,9 @@ func (r *sharedMockRow) Scan(dest ...any) error {
 	if r.err != nil {
 		return r.err
 	}
+	if r.data != nil && len(dest) != len(r.data) {
+		return fmt.Errorf("sql: expected %d destination arguments in Scan, not %d", len(r.data), len(dest))
+	}
 	for i, d := range dest {
 		if i < len(r.data) {
 			switch v := d.(type) {
@@ -155,8 +231,172 @@ func (r *sharedMockRow) Scan(dest ...any) error {
 				*v = r.data[i].(int)
 			case *bool:
 				*v = r.data[i].(bool)
+			case *any:
+				*v = r.data[i]
 			}
 		}
 	}
 	return nil
 }
+
+// recordingConnectionProvider wraps a ConnectionProvider and records
+// the databases connected to and the queries executed on them, other
+// than the advisory locks taken while creating the template.
+type recordingConnectionProvider struct {
+	pgdbtemplate.ConnectionProvider
+
+	mu        sync.Mutex
+	connects  []string
+	queries   []string
+	queriesOn map[string][]string
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *recordingConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	p.mu.Lock()
+	p.connects = append(p.connects, databaseName)
+	p.mu.Unlock()
+
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingDatabaseConnection{DatabaseConnection: conn, provider: p, databaseName: databaseName}, nil
+}
+
+// recordedConnects returns the names of the databases connected to.
+func (p *recordingConnectionProvider) recordedConnects() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.connects...)
+}
+
+// recordedQueries returns the executed queries in order.
+func (p *recordingConnectionProvider) recordedQueries() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.queries...)
+}
+
+// recordedQueriesOn returns the queries executed on databaseName in order.
+func (p *recordingConnectionProvider) recordedQueriesOn(databaseName string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.queriesOn[databaseName]...)
+}
+
+// recordingDatabaseConnection records the queries executed on it
+// into its provider.
+type recordingDatabaseConnection struct {
+	pgdbtemplate.DatabaseConnection
+	provider     *recordingConnectionProvider
+	databaseName string
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *recordingDatabaseConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	if !strings.Contains(query, "pg_advisory_") {
+		c.provider.mu.Lock()
+		c.provider.queries = append(c.provider.queries, query)
+		if c.provider.queriesOn == nil {
+			c.provider.queriesOn = make(map[string][]string)
+		}
+		c.provider.queriesOn[c.databaseName] = append(c.provider.queriesOn[c.databaseName], query)
+		c.provider.mu.Unlock()
+	}
+	return c.DatabaseConnection.ExecContext(ctx, query, args...)
+}
+
+// hookedConnectionProvider wraps a ConnectionProvider, calling the hooks
+// which are set before connecting and around the queries executed on its
+// connections, e.g. to fail some of them or to pause the test.
+type hookedConnectionProvider struct {
+	pgdbtemplate.ConnectionProvider
+	// beforeConnect fails connecting with the error it returns, if any.
+	beforeConnect func(ctx context.Context, databaseName string) error
+	// beforeQueryRow replaces the row of the query with the one
+	// it returns, if any.
+	beforeQueryRow func(query string) pgdbtemplate.Row
+	// beforeExec fails the statement with the error it returns, if any,
+	// without executing it.
+	beforeExec func(ctx context.Context, query string) error
+	afterExec  func(query string, err error)
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *hookedConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	if p.beforeConnect != nil {
+		if err := p.beforeConnect(ctx, databaseName); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedConnection{DatabaseConnection: conn, provider: p}, nil
+}
+
+// hookedConnection is the connection of hookedConnectionProvider.
+type hookedConnection struct {
+	pgdbtemplate.DatabaseConnection
+	provider *hookedConnectionProvider
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *hookedConnection) ExecContext(ctx context.Context, query string, args ...any) (result any, err error) {
+	if c.provider.beforeExec != nil {
+		err = c.provider.beforeExec(ctx, query)
+	}
+	if err == nil {
+		result, err = c.DatabaseConnection.ExecContext(ctx, query, args...)
+	}
+	if c.provider.afterExec != nil {
+		c.provider.afterExec(query, err)
+	}
+	return result, err
+}
+
+// QueryRowContext implements pgdbtemplate.DatabaseConnection.QueryRowContext.
+func (c *hookedConnection) QueryRowContext(ctx context.Context, query string, args ...any) pgdbtemplate.Row {
+	if c.provider.beforeQueryRow != nil {
+		if row := c.provider.beforeQueryRow(query); row != nil {
+			return row
+		}
+	}
+	return c.DatabaseConnection.QueryRowContext(ctx, query, args...)
+}
+
+// failingExec returns a beforeExec hook of hookedConnectionProvider
+// failing failQuery.
+func failingExec(failQuery string) func(ctx context.Context, query string) error {
+	return func(_ context.Context, query string) error {
+		if query == failQuery {
+			return fmt.Errorf("exec error")
+		}
+		return nil
+	}
+}
+
+// failingDrops returns a beforeExec hook of hookedConnectionProvider
+// failing to drop the given databases.
+func failingDrops(dbNames ...string) func(ctx context.Context, query string) error {
+	return func(_ context.Context, query string) error {
+		for _, dbName := range dbNames {
+			if droppedDatabase(query) == dbName {
+				return fmt.Errorf("intentional drop failure")
+			}
+		}
+		return nil
+	}
+}
+
+// droppedDatabase returns the name of the database dropped by query,
+// or "" if it is not a DROP DATABASE statement.
+func droppedDatabase(query string) string {
+	parts := strings.Fields(query)
+	if len(parts) < 3 || parts[0] != "DROP" || parts[1] != "DATABASE" {
+		return ""
+	}
+	return strings.Trim(parts[len(parts)-1], `"`)
+}
@@ -0,0 +1,162 @@
+package pgdbtemplate
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/andrei-polukhin/pgdbtemplate/internal/formatters"
+)
+
+// CreateOption configures a test database created by
+// CreateTestDatabaseWithOptions.
+type CreateOption interface {
+	applyCreate(*createOptions)
+}
+
+// createOptions holds the options of a test database to create.
+type createOptions struct {
+	name            string
+	connectionLimit *int
+	tablespace      *string
+	extraMigrations MigrationRunner
+	adminDBName     string
+}
+
+// createOptionFunc adapts a function to CreateOption.
+type createOptionFunc func(*createOptions)
+
+// applyCreate implements CreateOption.
+func (f createOptionFunc) applyCreate(opts *createOptions) {
+	f(opts)
+}
+
+// WithName sets the name of the test database.
+//
+// If not set, a unique name is generated.
+func WithName(name string) CreateOption {
+	return createOptionFunc(func(opts *createOptions) {
+		opts.name = name
+	})
+}
+
+// WithConnectionLimit sets how many concurrent connections
+// can be made to the test database. -1 means no limit.
+func WithConnectionLimit(limit int) CreateOption {
+	return createOptionFunc(func(opts *createOptions) {
+		opts.connectionLimit = &limit
+	})
+}
+
+// WithTablespace sets the tablespace the test database is created in.
+func WithTablespace(tablespace string) CreateOption {
+	return createOptionFunc(func(opts *createOptions) {
+		opts.tablespace = &tablespace
+	})
+}
+
+// WithExtraMigrations runs migrations on the test database after
+// creating it, e.g. a table or seed data only a single test needs,
+// so that the shared template doesn't have to contain them.
+//
+// If they fail, the test database is dropped.
+func WithExtraMigrations(runner MigrationRunner) CreateOption {
+	return createOptionFunc(func(opts *createOptions) {
+		opts.extraMigrations = runner
+	})
+}
+
+// newCreateOptions applies and validates the options.
+func newCreateOptions(opts []CreateOption) (createOptions, error) {
+	var options createOptions
+	for _, opt := range opts {
+		opt.applyCreate(&options)
+	}
+
+	if options.connectionLimit != nil && *options.connectionLimit < -1 {
+		return createOptions{}, fmt.Errorf("invalid connection limit %d: must be -1 or greater", *options.connectionLimit)
+	}
+	if options.tablespace != nil && *options.tablespace == "" {
+		return createOptions{}, fmt.Errorf("tablespace must not be empty")
+	}
+	return options, nil
+}
+
+// clauses returns the CREATE DATABASE clauses for the options,
+// each one preceded by a space.
+func (opts createOptions) clauses() string {
+	var clauses string
+	if opts.connectionLimit != nil {
+		clauses += " CONNECTION LIMIT " + strconv.Itoa(*opts.connectionLimit)
+	}
+	if opts.tablespace != nil {
+		clauses += " TABLESPACE " + formatters.QuoteIdentifier(*opts.tablespace)
+	}
+	return clauses
+}
+
+// DropOption configures how DropTestDatabase drops a test database.
+type DropOption interface {
+	applyDrop(*dropOptions)
+}
+
+// dropOptions holds the options of dropping a test database.
+type dropOptions struct {
+	ifExists    bool
+	adminDBName string
+}
+
+// dropOptionFunc adapts a function to DropOption.
+type dropOptionFunc func(*dropOptions)
+
+// applyDrop implements DropOption.
+func (f dropOptionFunc) applyDrop(opts *dropOptions) {
+	f(opts)
+}
+
+// IfExists makes DropTestDatabase succeed if the database doesn't exist,
+// e.g. in teardown code which may run twice.
+func IfExists() DropOption {
+	return dropOptionFunc(func(opts *dropOptions) {
+		opts.ifExists = true
+	})
+}
+
+// CreateDropOption configures both CreateTestDatabaseWithOptions
+// and DropTestDatabase.
+type CreateDropOption interface {
+	CreateOption
+	DropOption
+}
+
+// adminDatabaseOption is the option returned by WithAdminDatabase.
+type adminDatabaseOption string
+
+// applyCreate implements CreateOption.
+func (o adminDatabaseOption) applyCreate(opts *createOptions) {
+	opts.adminDBName = string(o)
+}
+
+// applyDrop implements DropOption.
+func (o adminDatabaseOption) applyDrop(opts *dropOptions) {
+	opts.adminDBName = string(o)
+}
+
+// WithAdminDatabase makes a single creation or drop of a test database
+// connect to the given database instead of Config.AdminDBName, e.g. in
+// environments only allowing DDL from a specific database.
+//
+// Like Config.AdminDBName, it must be neither the template nor template0
+// or template1 when creating a test database. The connection is closed
+// afterwards even with Config.ReuseAdminConnection.
+func WithAdminDatabase(name string) CreateDropOption {
+	return adminDatabaseOption(name)
+}
+
+// newDropOptions applies the options.
+func newDropOptions(opts []DropOption) dropOptions {
+	var options dropOptions
+	for _, opt := range opts {
+		opt.applyDrop(&options)
+	}
+	return options
+}
@@ -0,0 +1,230 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestCreateTestDatabaseWithOptions tests that the creation options
+// are appended to the CREATE DATABASE statement.
+func TestCreateTestDatabaseWithOptions(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "options_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	tests := []struct {
+		name          string
+		opts          []pgdbtemplate.CreateOption
+		expectedQuery string
+	}{{
+		name:          "No options",
+		opts:          []pgdbtemplate.CreateOption{pgdbtemplate.WithName("options_plain_db")},
+		expectedQuery: `CREATE DATABASE "options_plain_db" TEMPLATE "options_template"`,
+	}, {
+		name: "Connection limit",
+		opts: []pgdbtemplate.CreateOption{
+			pgdbtemplate.WithName("options_limited_db"),
+			pgdbtemplate.WithConnectionLimit(5),
+		},
+		expectedQuery: `CREATE DATABASE "options_limited_db" TEMPLATE "options_template" CONNECTION LIMIT 5`,
+	}, {
+		name: "Unlimited connections and tablespace",
+		opts: []pgdbtemplate.CreateOption{
+			pgdbtemplate.WithName("options_tablespace_db"),
+			pgdbtemplate.WithConnectionLimit(-1),
+			pgdbtemplate.WithTablespace(`fast"ssd`),
+		},
+		expectedQuery: `CREATE DATABASE "options_tablespace_db" TEMPLATE "options_template" CONNECTION LIMIT -1 TABLESPACE "fast""ssd"`,
+	}}
+
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			conn, dbName, err := tm.CreateTestDatabaseWithOptions(ctx, test.opts...)
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+			queries := provider.recordedQueries()
+			c.Assert(queries[len(queries)-1], qt.Equals, test.expectedQuery)
+			c.Assert(databaseExists(ctx, provider, dbName), qt.IsTrue)
+		})
+	}
+
+	c.Run("Generated name", func(c *qt.C) {
+		conn, dbName, err := tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithConnectionLimit(1))
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		queries := provider.recordedQueries()
+		c.Assert(queries[len(queries)-1], qt.Equals,
+			fmt.Sprintf(`CREATE DATABASE "%s" TEMPLATE "options_template" CONNECTION LIMIT 1`, dbName))
+	})
+}
+
+// TestCreateTestDatabaseWithInvalidOptions tests that invalid
+// creation options are rejected before creating the database.
+func TestCreateTestDatabaseWithInvalidOptions(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		AutoInitialize:     true,
+	})
+	c.Assert(err, qt.IsNil)
+
+	_, _, err = tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithConnectionLimit(-2))
+	c.Assert(err, qt.ErrorMatches, "invalid connection limit -2: must be -1 or greater")
+
+	_, _, err = tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithTablespace(""))
+	c.Assert(err, qt.ErrorMatches, "tablespace must not be empty")
+
+	c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+}
+
+// TestCreateTestDatabaseWithExtraMigrations tests that extra migrations
+// run on the test database only, and that it is dropped if they fail.
+func TestCreateTestDatabaseWithExtraMigrations(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id INT)"),
+		TemplateName:       "extra_migrations_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	c.Run("Extra table", func(c *qt.C) {
+		var migratedDB string
+		overlay := migrationRunnerFunc(func(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+			if err := conn.QueryRowContext(ctx, "SELECT current_database()").Scan(&migratedDB); err != nil {
+				return err
+			}
+			_, err := conn.ExecContext(ctx, "CREATE TABLE audit_log (id INT)")
+			return err
+		})
+
+		conn, dbName, err := tm.CreateTestDatabaseWithOptions(ctx,
+			pgdbtemplate.WithName("extra_migrations_db"),
+			pgdbtemplate.WithExtraMigrations(overlay),
+		)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(migratedDB, qt.Equals, dbName)
+	})
+
+	c.Run("Failing migrations", func(c *qt.C) {
+		_, _, err := tm.CreateTestDatabaseWithOptions(ctx,
+			pgdbtemplate.WithName("extra_migrations_failing_db"),
+			pgdbtemplate.WithExtraMigrations(&failingMigrationRunner{errorMsg: "table already exists"}),
+		)
+		c.Assert(err, qt.ErrorMatches,
+			`failed to run extra migrations on test database "extra_migrations_failing_db": table already exists`)
+		c.Assert(databaseExists(ctx, connProvider, "extra_migrations_failing_db"), qt.IsFalse)
+	})
+}
+
+// TestDropTestDatabaseIfExists tests that dropping a missing database
+// with IfExists succeeds.
+func TestDropTestDatabaseIfExists(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "drop_if_exists_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	conn, dbName, err := tm.CreateTestDatabase(ctx, "drop_if_exists_db")
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	// Dropping twice is harmless.
+	c.Assert(tm.DropTestDatabase(ctx, dbName, pgdbtemplate.IfExists()), qt.IsNil)
+	c.Assert(databaseExists(ctx, provider, dbName), qt.IsFalse)
+	c.Assert(tm.DropTestDatabase(ctx, dbName, pgdbtemplate.IfExists()), qt.IsNil)
+
+	queries := provider.recordedQueries()
+	c.Assert(queries[len(queries)-1], qt.Equals, `DROP DATABASE IF EXISTS "drop_if_exists_db"`)
+
+	// Without the option, a missing database is still an error.
+	err = tm.DropTestDatabase(ctx, dbName)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+}
+
+// TestWithAdminDatabase tests that creating and dropping a test database
+// connect to the admin database given by the option.
+func TestWithAdminDatabase(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:   provider,
+		MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:         "admin_option_template",
+		ReuseAdminConnection: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+	connects := len(provider.recordedConnects())
+
+	_, optionDBName, err := tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithAdminDatabase("ddl_db"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.DropTestDatabase(ctx, optionDBName, pgdbtemplate.WithAdminDatabase("ddl_db")), qt.IsNil)
+	// The default admin database is still used without the option.
+	_, dbName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+
+	// The reused connection to the default admin database
+	// was opened by Initialize.
+	c.Assert(provider.recordedConnects()[connects:], qt.DeepEquals, []string{
+		"ddl_db",
+		optionDBName,
+		"ddl_db",
+		dbName,
+	})
+
+	c.Run("Template as admin database", func(c *qt.C) {
+		_, _, err := tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithAdminDatabase("admin_option_template"))
+		c.Assert(err, qt.ErrorMatches, `admin database must not be "admin_option_template", since a template cannot be copied while connected to`)
+	})
+
+	c.Run("Source template as admin database", func(c *qt.C) {
+		// The default admin database is checked too, without connecting.
+		connects := len(provider.recordedConnects())
+		_, _, err := tm.CreateTestDatabaseFromTemplate(ctx, "postgres", "")
+		c.Assert(err, qt.ErrorMatches, `admin database must not be "postgres", since a template cannot be copied while connected to`)
+		c.Assert(provider.recordedConnects(), qt.HasLen, connects)
+	})
+}
@@ -0,0 +1,101 @@
+package pgdbtemplate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/andrei-polukhin/pgdbtemplate/internal/formatters"
+)
+
+// DropOrphanedTemplatesOption configures DropOrphanedTemplates.
+type DropOrphanedTemplatesOption interface {
+	applyDropOrphanedTemplates(*dropOrphanedTemplatesOptions)
+}
+
+// dropOrphanedTemplatesOptions holds the options of DropOrphanedTemplates.
+type dropOrphanedTemplatesOptions struct {
+	adminDBName string
+}
+
+// dropOrphanedTemplatesOptionFunc adapts a function
+// to DropOrphanedTemplatesOption.
+type dropOrphanedTemplatesOptionFunc func(*dropOrphanedTemplatesOptions)
+
+// applyDropOrphanedTemplates implements DropOrphanedTemplatesOption.
+func (f dropOrphanedTemplatesOptionFunc) applyDropOrphanedTemplates(opts *dropOrphanedTemplatesOptions) {
+	f(opts)
+}
+
+// WithOrphanedTemplatesAdminDatabase makes DropOrphanedTemplates connect
+// to the given database instead of "postgres", e.g. in environments
+// without a "postgres" database.
+func WithOrphanedTemplatesAdminDatabase(name string) DropOrphanedTemplatesOption {
+	return dropOrphanedTemplatesOptionFunc(func(opts *dropOrphanedTemplatesOptions) {
+		opts.adminDBName = name
+	})
+}
+
+// DropOrphanedTemplates drops all databases marked as templates whose
+// names match the LIKE pattern namePattern, e.g. "template_%", such as
+// templates left behind by crashed CI jobs.
+//
+// It connects to the "postgres" database, unless another one is set with
+// WithOrphanedTemplatesAdminDatabase, and terminates the connections
+// to each template before unmarking and dropping it. template0 and
+// template1 are never dropped. Failing templates don't stop the others,
+// and all errors are returned.
+func DropOrphanedTemplates(ctx context.Context, provider ConnectionProvider, namePattern string, opts ...DropOrphanedTemplatesOption) error {
+	options := dropOrphanedTemplatesOptions{adminDBName: defaultAdminDBName}
+	for _, opt := range opts {
+		opt.applyDropOrphanedTemplates(&options)
+	}
+	adminConn, err := provider.Connect(ctx, options.adminDBName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer adminConn.Close()
+
+	// Aggregate the names into a single row, as DatabaseConnection
+	// cannot read multiple rows.
+	listQuery := fmt.Sprintf(`
+		SELECT COALESCE(json_agg(datname ORDER BY datname), '[]')::text
+		FROM pg_database
+		WHERE datistemplate AND datname LIKE %s AND datname NOT IN ('template0', 'template1')
+	`, formatters.QuoteLiteral(namePattern))
+	var namesJSON string
+	if err := adminConn.QueryRowContext(ctx, listQuery).Scan(&namesJSON); err != nil {
+		return fmt.Errorf("failed to list templates matching %q: %w", namePattern, err)
+	}
+	var templateNames []string
+	if err := json.Unmarshal([]byte(namesJSON), &templateNames); err != nil {
+		return fmt.Errorf("failed to list templates matching %q: %w", namePattern, err)
+	}
+
+	dialect := PostgresQueryDialect{}
+	var errs error
+	for _, templateName := range templateNames {
+		if err := dropOrphanedTemplate(ctx, adminConn, dialect, templateName); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to drop template %q: %w", templateName, err))
+		}
+	}
+	return errs
+}
+
+// dropOrphanedTemplate terminates the connections to the template,
+// unmarks it and drops it.
+func dropOrphanedTemplate(ctx context.Context, adminConn DatabaseConnection, dialect QueryDialect, templateName string) error {
+	var terminated int
+	if err := adminConn.QueryRowContext(ctx, dialect.TerminateConnectionsQuery([]string{templateName})).Scan(&terminated); err != nil {
+		return fmt.Errorf("failed to terminate connections: %w", err)
+	}
+	if _, err := adminConn.ExecContext(ctx, dialect.MarkTemplateQuery(templateName, false)); err != nil {
+		return fmt.Errorf("failed to unmark template: %w", classifyError(err))
+	}
+	dropQuery := "DROP DATABASE " + formatters.QuoteIdentifier(templateName)
+	if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
+		return classifyError(err)
+	}
+	return nil
+}
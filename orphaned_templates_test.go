@@ -0,0 +1,131 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestDropOrphanedTemplates tests that the listed templates
+// are unmarked and dropped.
+func TestDropOrphanedTemplates(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	templateNames := []string{"orphan_template_1", "orphan_template_2", "orphan_template_3"}
+	adminConn, err := connProvider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	for _, templateName := range templateNames {
+		_, err := adminConn.ExecContext(ctx, `CREATE DATABASE "`+templateName+`"`)
+		c.Assert(err, qt.IsNil)
+		_, err = adminConn.ExecContext(ctx, `ALTER DATABASE "`+templateName+`" WITH is_template TRUE`)
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(adminConn.Close(), qt.IsNil)
+
+	lister := &templateLister{templateNames: templateNames}
+	provider := &recordingConnectionProvider{ConnectionProvider: &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		beforeQueryRow:     lister.queryRow,
+		beforeExec:         failingDrops("orphan_template_2"),
+	}}
+	err = pgdbtemplate.DropOrphanedTemplates(ctx, provider, "orphan_%")
+	c.Assert(err, qt.ErrorMatches, `failed to drop template "orphan_template_2": intentional drop failure`)
+	c.Assert(lister.listQuery, qt.Contains, "datname LIKE 'orphan_%'")
+	c.Assert(lister.listQuery, qt.Contains, "datistemplate")
+
+	// The failing template doesn't stop the others.
+	c.Assert(databaseExists(ctx, connProvider, "orphan_template_1"), qt.IsFalse)
+	c.Assert(databaseExists(ctx, connProvider, "orphan_template_2"), qt.IsTrue)
+	c.Assert(databaseExists(ctx, connProvider, "orphan_template_3"), qt.IsFalse)
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`ALTER DATABASE "orphan_template_1" WITH is_template FALSE`,
+		`DROP DATABASE "orphan_template_1"`,
+		`ALTER DATABASE "orphan_template_2" WITH is_template FALSE`,
+		`DROP DATABASE "orphan_template_2"`,
+		`ALTER DATABASE "orphan_template_3" WITH is_template FALSE`,
+		`DROP DATABASE "orphan_template_3"`,
+	})
+	for _, dbName := range provider.recordedConnects() {
+		c.Assert(dbName, qt.Equals, "postgres")
+	}
+
+	c.Run("Admin database", func(c *qt.C) {
+		adminConn, err := connProvider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer adminConn.Close()
+		for _, query := range []string{
+			`CREATE DATABASE "orphan_admin_db"`,
+			`CREATE DATABASE "orphan_admin_template"`,
+			`ALTER DATABASE "orphan_admin_template" WITH is_template TRUE`,
+		} {
+			_, err := adminConn.ExecContext(ctx, query)
+			c.Assert(err, qt.IsNil)
+		}
+		defer func() {
+			_, err := adminConn.ExecContext(ctx, `DROP DATABASE "orphan_admin_db"`)
+			c.Assert(err, qt.IsNil)
+		}()
+
+		recordingProvider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+		provider := &hookedConnectionProvider{
+			ConnectionProvider: recordingProvider,
+			beforeQueryRow:     (&templateLister{templateNames: []string{"orphan_admin_template"}}).queryRow,
+		}
+		err = pgdbtemplate.DropOrphanedTemplates(ctx, provider, "orphan_admin_%", pgdbtemplate.WithOrphanedTemplatesAdminDatabase("orphan_admin_db"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(recordingProvider.recordedConnects(), qt.DeepEquals, []string{"orphan_admin_db"})
+		c.Assert(databaseExists(ctx, connProvider, "orphan_admin_template"), qt.IsFalse)
+	})
+
+	c.Run("Dropped concurrently", func(c *qt.C) {
+		provider := &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeQueryRow:     (&templateLister{templateNames: []string{"orphan_vanished_template"}}).queryRow,
+		}
+		err := pgdbtemplate.DropOrphanedTemplates(ctx, provider, "orphan_%")
+		c.Assert(err, qt.ErrorIs, pgdbtemplate.ErrDatabaseDoesNotExist)
+	})
+
+	c.Run("Listing fails", func(c *qt.C) {
+		provider := &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeQueryRow:     (&templateLister{listErr: errors.New("permission denied for table pg_database")}).queryRow,
+		}
+		err := pgdbtemplate.DropOrphanedTemplates(ctx, provider, "orphan_%")
+		c.Assert(err, qt.ErrorMatches, `failed to list templates matching "orphan_%": permission denied for table pg_database`)
+	})
+}
+
+// templateLister answers the query listing templates with templateNames,
+// or fails it with listErr, keeping the query in listQuery.
+type templateLister struct {
+	templateNames []string
+	listErr       error
+
+	listQuery string
+}
+
+// queryRow is the beforeQueryRow hook of hookedConnectionProvider.
+func (l *templateLister) queryRow(query string) pgdbtemplate.Row {
+	if !strings.Contains(query, "json_agg(datname") {
+		return nil
+	}
+	l.listQuery = query
+	if l.listErr != nil {
+		return &sharedMockRow{err: l.listErr}
+	}
+	namesJSON, err := json.Marshal(l.templateNames)
+	if err != nil {
+		return &sharedMockRow{err: err}
+	}
+	return &sharedMockRow{data: []any{string(namesJSON)}}
+}
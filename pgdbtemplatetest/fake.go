@@ -0,0 +1,415 @@
+// Package pgdbtemplatetest provides an in-memory fake of a PostgreSQL
+// server, so that code built on top of pgdbtemplate can be unit-tested
+// without running one.
+package pgdbtemplatetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// PostgreSQL error codes (SQLSTATE) returned by the fake.
+const (
+	sqlStateDuplicateDatabase  = "42P04"
+	sqlStateInvalidCatalogName = "3D000"
+	sqlStateObjectInUse        = "55006"
+	sqlStateWrongObjectType    = "42809"
+	sqlStateAdminShutdown      = "57P01"
+)
+
+// errConnectionClosed is returned when using a closed connection.
+var errConnectionClosed = errors.New("pgdbtemplatetest: connection is closed")
+
+// Error is an error of the fake server. Like the errors of PostgreSQL
+// drivers, such as *pq.Error and *pgconn.PgError, it exposes its SQLSTATE,
+// so that the template manager classifies it the same way.
+type Error struct {
+	// Code is the SQLSTATE of the error, e.g. "3D000".
+	Code string
+	// Message is the message of the error.
+	Message string
+}
+
+// Error implements error.Error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// SQLState returns the SQLSTATE of the error.
+func (e *Error) SQLState() string {
+	return e.Code
+}
+
+// FakeConnectionProvider is an in-memory pgdbtemplate.ConnectionProvider
+// keeping a registry of databases, initially "postgres", "template0"
+// and "template1".
+//
+// Its connections understand the statements the template manager runs
+// with pgdbtemplate.PostgresQueryDialect: creating and dropping databases,
+// marking them as templates, checking whether they exist, terminating
+// connections to them and SELECT current_database(). All other statements,
+// such as migrations, succeed without any effect, and all other queries
+// fail when their row is scanned.
+//
+// Like PostgreSQL, it refuses to copy or drop a database other
+// connections are open to, and to drop a database marked as a template.
+type FakeConnectionProvider struct {
+	mu        sync.Mutex
+	databases map[string]*fakeDatabase
+}
+
+// fakeDatabase is a database of the fake server.
+type fakeDatabase struct {
+	isTemplate bool
+	conns      map[*fakeConnection]struct{}
+}
+
+// NewFakeConnectionProvider creates a fake connection provider
+// with the databases of a fresh PostgreSQL server.
+func NewFakeConnectionProvider() *FakeConnectionProvider {
+	return &FakeConnectionProvider{
+		databases: map[string]*fakeDatabase{
+			"postgres":  newFakeDatabase(false),
+			"template0": newFakeDatabase(true),
+			"template1": newFakeDatabase(true),
+		},
+	}
+}
+
+// newFakeDatabase creates a database without connections.
+func newFakeDatabase(isTemplate bool) *fakeDatabase {
+	return &fakeDatabase{isTemplate: isTemplate, conns: map[*fakeConnection]struct{}{}}
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *FakeConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	db, ok := p.databases[databaseName]
+	if !ok {
+		return nil, &Error{Code: sqlStateInvalidCatalogName, Message: fmt.Sprintf("database %q does not exist", databaseName)}
+	}
+	conn := &fakeConnection{provider: p, dbName: databaseName}
+	db.conns[conn] = struct{}{}
+	return conn, nil
+}
+
+// GetNoRowsSentinel implements pgdbtemplate.ConnectionProvider.GetNoRowsSentinel.
+func (p *FakeConnectionProvider) GetNoRowsSentinel() error {
+	return sql.ErrNoRows
+}
+
+// DatabaseExists reports whether the database exists.
+func (p *FakeConnectionProvider) DatabaseExists(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.databases[name]
+	return ok
+}
+
+// IsTemplate reports whether the database exists and is marked as a template.
+func (p *FakeConnectionProvider) IsTemplate(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	db, ok := p.databases[name]
+	return ok && db.isTemplate
+}
+
+// Databases returns the names of all databases, sorted.
+func (p *FakeConnectionProvider) Databases() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.databases))
+	for name := range p.databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenConnections returns the number of open connections to the database,
+// e.g. to check that the code under test closes its connections.
+func (p *FakeConnectionProvider) OpenConnections(name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if db, ok := p.databases[name]; ok {
+		return len(db.conns)
+	}
+	return 0
+}
+
+// fakeConnection is a connection to a database of FakeConnectionProvider.
+// Its fields are guarded by the mutex of the provider.
+type fakeConnection struct {
+	provider   *FakeConnectionProvider
+	dbName     string
+	closed     bool
+	terminated bool
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *fakeConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := c.provider
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	tokens := tokenize(query)
+	switch {
+	case matchKeywords(tokens, "CREATE", "DATABASE"):
+		return nil, c.createDatabase(tokens[2:])
+	case matchKeywords(tokens, "DROP", "DATABASE"):
+		return nil, c.dropDatabase(tokens[2:])
+	case matchKeywords(tokens, "ALTER", "DATABASE"):
+		return nil, c.alterDatabase(tokens[2:])
+	}
+	return nil, nil
+}
+
+// QueryRowContext implements pgdbtemplate.DatabaseConnection.QueryRowContext.
+func (c *fakeConnection) QueryRowContext(ctx context.Context, query string, args ...any) pgdbtemplate.Row {
+	if err := ctx.Err(); err != nil {
+		return &fakeRow{err: err}
+	}
+
+	p := c.provider
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := c.checkOpen(); err != nil {
+		return &fakeRow{err: err}
+	}
+
+	tokens := tokenize(query)
+	switch {
+	case strings.Contains(query, "pg_terminate_backend"):
+		return &fakeRow{values: []any{c.terminateConnections(literals(tokens))}}
+	case matchKeywords(tokens, "SELECT", "datistemplate", "FROM", "pg_database"):
+		names := literals(tokens)
+		if len(names) != 1 {
+			break
+		}
+		db, ok := p.databases[names[0]]
+		if !ok {
+			return &fakeRow{err: sql.ErrNoRows}
+		}
+		return &fakeRow{values: []any{db.isTemplate}}
+	case strings.EqualFold(strings.TrimSpace(query), "SELECT current_database()"):
+		return &fakeRow{values: []any{c.dbName}}
+	}
+	return &fakeRow{err: fmt.Errorf("pgdbtemplatetest: unsupported query %q", query)}
+}
+
+// Close implements pgdbtemplate.DatabaseConnection.Close.
+func (c *fakeConnection) Close() error {
+	p := c.provider
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.detach()
+	return nil
+}
+
+// checkOpen returns an error if the connection was closed or terminated.
+func (c *fakeConnection) checkOpen() error {
+	if c.terminated {
+		return &Error{Code: sqlStateAdminShutdown, Message: "terminating connection due to administrator command"}
+	}
+	if c.closed {
+		return errConnectionClosed
+	}
+	return nil
+}
+
+// detach removes the connection from its database and closes it.
+func (c *fakeConnection) detach() {
+	if db, ok := c.provider.databases[c.dbName]; ok {
+		delete(db.conns, c)
+	}
+	c.closed = true
+}
+
+// otherConnections returns the number of connections
+// to the database other than c.
+func (c *fakeConnection) otherConnections(db *fakeDatabase) int {
+	n := len(db.conns)
+	if _, ok := db.conns[c]; ok {
+		n--
+	}
+	return n
+}
+
+// createDatabase handles CREATE DATABASE name [...] [TEMPLATE source] [...].
+func (c *fakeConnection) createDatabase(tokens []token) error {
+	if len(tokens) == 0 || !tokens[0].isIdentifier() {
+		return fmt.Errorf("pgdbtemplatetest: unsupported CREATE DATABASE statement")
+	}
+	name := tokens[0].identifier()
+	source := "template1"
+	for i := 1; i+1 < len(tokens); i++ {
+		if tokens[i].isKeyword("TEMPLATE") && tokens[i+1].isIdentifier() {
+			source = tokens[i+1].identifier()
+		}
+	}
+
+	p := c.provider
+	if _, ok := p.databases[name]; ok {
+		return &Error{Code: sqlStateDuplicateDatabase, Message: fmt.Sprintf("database %q already exists", name)}
+	}
+	sourceDB, ok := p.databases[source]
+	if !ok {
+		return &Error{Code: sqlStateInvalidCatalogName, Message: fmt.Sprintf("template database %q does not exist", source)}
+	}
+	if c.otherConnections(sourceDB) > 0 {
+		return &Error{Code: sqlStateObjectInUse, Message: fmt.Sprintf("source database %q is being accessed by other users", source)}
+	}
+	p.databases[name] = newFakeDatabase(false)
+	return nil
+}
+
+// dropDatabase handles DROP DATABASE [IF EXISTS] name.
+func (c *fakeConnection) dropDatabase(tokens []token) error {
+	ifExists := len(tokens) >= 2 && tokens[0].isKeyword("IF") && tokens[1].isKeyword("EXISTS")
+	if ifExists {
+		tokens = tokens[2:]
+	}
+	if len(tokens) == 0 || !tokens[0].isIdentifier() {
+		return fmt.Errorf("pgdbtemplatetest: unsupported DROP DATABASE statement")
+	}
+	name := tokens[0].identifier()
+
+	p := c.provider
+	db, ok := p.databases[name]
+	switch {
+	case !ok && ifExists:
+		return nil
+	case !ok:
+		return &Error{Code: sqlStateInvalidCatalogName, Message: fmt.Sprintf("database %q does not exist", name)}
+	case name == c.dbName:
+		return &Error{Code: sqlStateObjectInUse, Message: "cannot drop the currently open database"}
+	case db.isTemplate:
+		return &Error{Code: sqlStateWrongObjectType, Message: "cannot drop a template database"}
+	case len(db.conns) > 0:
+		return &Error{Code: sqlStateObjectInUse, Message: fmt.Sprintf("database %q is being accessed by other users", name)}
+	}
+	delete(p.databases, name)
+	return nil
+}
+
+// alterDatabase handles ALTER DATABASE name WITH is_template TRUE|FALSE,
+// and ignores other changes.
+func (c *fakeConnection) alterDatabase(tokens []token) error {
+	if len(tokens) == 0 || !tokens[0].isIdentifier() {
+		return fmt.Errorf("pgdbtemplatetest: unsupported ALTER DATABASE statement")
+	}
+	name := tokens[0].identifier()
+
+	db, ok := c.provider.databases[name]
+	if !ok {
+		return &Error{Code: sqlStateInvalidCatalogName, Message: fmt.Sprintf("database %q does not exist", name)}
+	}
+	for i, tok := range tokens {
+		if !tok.isKeyword("is_template") {
+			continue
+		}
+		for _, value := range tokens[i+1:] {
+			switch {
+			case value.isKeyword("TRUE"):
+				db.isTemplate = true
+				return nil
+			case value.isKeyword("FALSE"):
+				db.isTemplate = false
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// terminateConnections terminates the connections to the databases,
+// other than c, and returns their number.
+func (c *fakeConnection) terminateConnections(dbNames []string) int {
+	terminated := 0
+	for _, dbName := range dbNames {
+		db, ok := c.provider.databases[dbName]
+		if !ok {
+			continue
+		}
+		for conn := range db.conns {
+			if conn == c {
+				continue
+			}
+			conn.detach()
+			conn.terminated = true
+			terminated++
+		}
+	}
+	return terminated
+}
+
+// fakeRow is the row of a query of fakeConnection.
+type fakeRow struct {
+	values []any
+	err    error
+}
+
+// Scan implements pgdbtemplate.Row.Scan.
+func (r *fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(dest) != len(r.values) {
+		return fmt.Errorf("pgdbtemplatetest: expected %d destination arguments in Scan, not %d", len(r.values), len(dest))
+	}
+	for i, value := range r.values {
+		if err := scanValue(dest[i], value); err != nil {
+			return fmt.Errorf("pgdbtemplatetest: cannot scan column %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// scanValue assigns value to the destination pointer.
+func scanValue(dest, value any) error {
+	switch d := dest.(type) {
+	case *any:
+		*d = value
+		return nil
+	case *bool:
+		if v, ok := value.(bool); ok {
+			*d = v
+			return nil
+		}
+	case *string:
+		if v, ok := value.(string); ok {
+			*d = v
+			return nil
+		}
+	case *int:
+		if v, ok := value.(int); ok {
+			*d = v
+			return nil
+		}
+	case *int64:
+		if v, ok := value.(int); ok {
+			*d = int64(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported conversion from %T to %T", value, dest)
+}
@@ -0,0 +1,209 @@
+package pgdbtemplatetest_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/andrei-polukhin/pgdbtemplate/pgdbtemplatetest"
+)
+
+// TestFakeConnectionProviderWithTemplateManager tests the full lifecycle
+// of a template manager on top of the fake.
+func TestFakeConnectionProviderWithTemplateManager(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatetest.NewFakeConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:     provider,
+		MigrationRunner:        pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id INT)"),
+		TemplateName:           "fake_template",
+		VerifyTestDBConnection: true,
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(provider.IsTemplate("fake_template"), qt.IsTrue)
+	c.Assert(provider.OpenConnections("fake_template"), qt.Equals, 0)
+
+	conn, dbName, err := tm.CreateTestDatabase(ctx, "fake_test_db")
+	c.Assert(err, qt.IsNil)
+	c.Assert(provider.DatabaseExists(dbName), qt.IsTrue)
+	c.Assert(provider.IsTemplate(dbName), qt.IsFalse)
+	c.Assert(provider.OpenConnections(dbName), qt.Equals, 1)
+
+	// The test database name is taken.
+	_, _, err = tm.CreateTestDatabase(ctx, "fake_test_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseAlreadyExists), qt.IsTrue, qt.Commentf("got %v", err))
+
+	// Dropping terminates the leaked connection.
+	terminated, err := tm.DropTestDatabaseWithCount(ctx, dbName)
+	c.Assert(err, qt.IsNil)
+	c.Assert(terminated, qt.Equals, 1)
+	c.Assert(provider.DatabaseExists(dbName), qt.IsFalse)
+	_, err = conn.ExecContext(ctx, "SELECT 1")
+	c.Assert(err, qt.ErrorMatches, "terminating connection due to administrator command")
+
+	err = tm.DropTestDatabase(ctx, dbName)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(provider.Databases(), qt.DeepEquals, []string{"postgres", "template0", "template1"})
+}
+
+// TestFakeConnectionProvider tests the statements and queries
+// understood by the fake.
+func TestFakeConnectionProvider(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatetest.NewFakeConnectionProvider()
+	admin, err := provider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	defer admin.Close()
+
+	exec := func(query string) error {
+		_, err := admin.ExecContext(ctx, query)
+		return err
+	}
+	isTemplate := func(name string) (bool, error) {
+		var isTemplate bool
+		query := pgdbtemplate.PostgresQueryDialect{}.DatabaseExistsQuery(name)
+		err := admin.QueryRowContext(ctx, query).Scan(&isTemplate)
+		return isTemplate, err
+	}
+
+	c.Run("Create and drop", func(c *qt.C) {
+		name := `it's a "db" \ name`
+		c.Assert(exec("CREATE DATABASE "+pgdbtemplate.QuoteIdentifier(name)+" TEMPLATE template0"), qt.IsNil)
+		c.Assert(provider.DatabaseExists(name), qt.IsTrue)
+
+		got, err := isTemplate(name)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.IsFalse)
+
+		c.Assert(exec("DROP DATABASE "+pgdbtemplate.QuoteIdentifier(name)), qt.IsNil)
+		_, err = isTemplate(name)
+		c.Assert(err, qt.Equals, sql.ErrNoRows)
+		c.Assert(exec("DROP DATABASE IF EXISTS "+pgdbtemplate.QuoteIdentifier(name)), qt.IsNil)
+	})
+
+	c.Run("Unquoted names are folded to lower case", func(c *qt.C) {
+		c.Assert(exec("CREATE DATABASE Folded"), qt.IsNil)
+		c.Assert(provider.DatabaseExists("folded"), qt.IsTrue)
+		c.Assert(exec(`DROP DATABASE "folded"`), qt.IsNil)
+	})
+
+	c.Run("Errors", func(c *qt.C) {
+		tests := []struct {
+			name  string
+			query string
+
+			expectedCode string
+		}{{
+			name:         "Duplicate database",
+			query:        "CREATE DATABASE postgres",
+			expectedCode: "42P04",
+		}, {
+			name:         "Missing source template",
+			query:        `CREATE DATABASE "copy" TEMPLATE "missing"`,
+			expectedCode: "3D000",
+		}, {
+			name:         "Source template in use",
+			query:        `CREATE DATABASE "copy" TEMPLATE "postgres"`,
+			expectedCode: "55006",
+		}, {
+			name:         "Missing database",
+			query:        `DROP DATABASE "missing"`,
+			expectedCode: "3D000",
+		}, {
+			name:         "Current database",
+			query:        `DROP DATABASE "postgres"`,
+			expectedCode: "55006",
+		}, {
+			name:         "Template database",
+			query:        `DROP DATABASE "template1"`,
+			expectedCode: "42809",
+		}, {
+			name:         "Marking a missing database",
+			query:        `ALTER DATABASE "missing" WITH is_template TRUE`,
+			expectedCode: "3D000",
+		}}
+
+		// Another connection to the admin database makes it in use.
+		other, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer other.Close()
+
+		for _, test := range tests {
+			c.Run(test.name, func(c *qt.C) {
+				var pgErr *pgdbtemplatetest.Error
+				c.Assert(errors.As(exec(test.query), &pgErr), qt.IsTrue)
+				c.Assert(pgErr.SQLState(), qt.Equals, test.expectedCode)
+			})
+		}
+	})
+
+	c.Run("Marking templates", func(c *qt.C) {
+		c.Assert(exec(`CREATE DATABASE "marked"`), qt.IsNil)
+		c.Assert(exec(pgdbtemplate.PostgresQueryDialect{}.MarkTemplateQuery("marked", true)), qt.IsNil)
+		c.Assert(provider.IsTemplate("marked"), qt.IsTrue)
+
+		got, err := isTemplate("marked")
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.IsTrue)
+
+		c.Assert(exec(pgdbtemplate.PostgresQueryDialect{}.MarkTemplateQuery("marked", false)), qt.IsNil)
+		c.Assert(provider.IsTemplate("marked"), qt.IsFalse)
+		c.Assert(exec(`DROP DATABASE "marked"`), qt.IsNil)
+	})
+
+	c.Run("Terminating connections", func(c *qt.C) {
+		c.Assert(exec(`CREATE DATABASE "busy"`), qt.IsNil)
+		conns := make([]pgdbtemplate.DatabaseConnection, 2)
+		for i := range conns {
+			conns[i], err = provider.Connect(ctx, "busy")
+			c.Assert(err, qt.IsNil)
+		}
+
+		var terminated int
+		query := pgdbtemplate.PostgresQueryDialect{}.TerminateConnectionsQuery([]string{"busy", "missing"})
+		c.Assert(admin.QueryRowContext(ctx, query).Scan(&terminated), qt.IsNil)
+		c.Assert(terminated, qt.Equals, 2)
+		c.Assert(provider.OpenConnections("busy"), qt.Equals, 0)
+		c.Assert(conns[0].QueryRowContext(ctx, "SELECT current_database()").Scan(new(string)), qt.ErrorMatches,
+			"terminating connection due to administrator command")
+		c.Assert(exec(`DROP DATABASE "busy"`), qt.IsNil)
+	})
+
+	c.Run("Connections", func(c *qt.C) {
+		_, err := provider.Connect(ctx, "missing")
+		var pgErr *pgdbtemplatetest.Error
+		c.Assert(errors.As(err, &pgErr), qt.IsTrue)
+		c.Assert(pgErr.SQLState(), qt.Equals, "3D000")
+
+		conn, err := provider.Connect(ctx, "template1")
+		c.Assert(err, qt.IsNil)
+		var currentDB string
+		c.Assert(conn.QueryRowContext(ctx, "SELECT current_database()").Scan(&currentDB), qt.IsNil)
+		c.Assert(currentDB, qt.Equals, "template1")
+		c.Assert(provider.OpenConnections("template1"), qt.Equals, 1)
+
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(provider.OpenConnections("template1"), qt.Equals, 0)
+		_, err = conn.ExecContext(ctx, "SELECT 1")
+		c.Assert(err, qt.ErrorMatches, "pgdbtemplatetest: connection is closed")
+	})
+
+	c.Run("Unsupported query", func(c *qt.C) {
+		err := admin.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(new(int))
+		c.Assert(err, qt.ErrorMatches, `pgdbtemplatetest: unsupported query "SELECT count\(\*\) FROM users"`)
+	})
+}
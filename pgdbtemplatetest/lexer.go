@@ -0,0 +1,137 @@
+package pgdbtemplatetest
+
+import "strings"
+
+// tokenKind is the kind of a token of an SQL statement.
+type tokenKind int
+
+const (
+	// tokenWord is a keyword or an unquoted identifier.
+	tokenWord tokenKind = iota
+	// tokenQuotedIdentifier is a double-quoted identifier.
+	tokenQuotedIdentifier
+	// tokenLiteral is a single-quoted string literal.
+	tokenLiteral
+	// tokenSymbol is any other character, e.g. a parenthesis.
+	tokenSymbol
+)
+
+// token is a token of an SQL statement. The value of quoted identifiers
+// and literals is unquoted.
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// isKeyword reports whether the token is the keyword, case-insensitively.
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.value, keyword)
+}
+
+// isIdentifier reports whether the token can be an identifier.
+func (t token) isIdentifier() bool {
+	return t.kind == tokenWord || t.kind == tokenQuotedIdentifier
+}
+
+// identifier returns the name the identifier token refers to:
+// unquoted identifiers are folded to lower case, like PostgreSQL does.
+func (t token) identifier() string {
+	if t.kind == tokenWord {
+		return strings.ToLower(t.value)
+	}
+	return t.value
+}
+
+// tokenize splits an SQL statement into tokens, skipping whitespace.
+//
+// It supports the quoting of pgdbtemplate.QuoteIdentifier and
+// pgdbtemplate.QuoteLiteral, including E'...' escape strings.
+func tokenize(query string) []token {
+	var tokens []token
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f':
+			i++
+		case ch == '"':
+			value, next := readQuoted(query, i+1, '"', false)
+			tokens = append(tokens, token{kind: tokenQuotedIdentifier, value: value})
+			i = next
+		case ch == '\'':
+			value, next := readQuoted(query, i+1, '\'', false)
+			tokens = append(tokens, token{kind: tokenLiteral, value: value})
+			i = next
+		case (ch == 'E' || ch == 'e') && i+1 < len(query) && query[i+1] == '\'':
+			value, next := readQuoted(query, i+2, '\'', true)
+			tokens = append(tokens, token{kind: tokenLiteral, value: value})
+			i = next
+		case isWordChar(ch):
+			start := i
+			for i < len(query) && isWordChar(query[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: query[start:i]})
+		default:
+			tokens = append(tokens, token{kind: tokenSymbol, value: string(ch)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// readQuoted reads a quoted token starting at i, right after the opening
+// quote, and returns its unquoted value and the index after the closing
+// quote. A doubled quote stands for the quote itself, and if backslashEscapes
+// is set, a backslash escapes the following character.
+func readQuoted(query string, i int, quote byte, backslashEscapes bool) (string, int) {
+	var value strings.Builder
+	for i < len(query) {
+		ch := query[i]
+		switch {
+		case backslashEscapes && ch == '\\' && i+1 < len(query):
+			value.WriteByte(query[i+1])
+			i += 2
+		case ch == quote && i+1 < len(query) && query[i+1] == quote:
+			value.WriteByte(quote)
+			i += 2
+		case ch == quote:
+			return value.String(), i + 1
+		default:
+			value.WriteByte(ch)
+			i++
+		}
+	}
+	return value.String(), i
+}
+
+// isWordChar reports whether ch can be part of a keyword
+// or an unquoted identifier.
+func isWordChar(ch byte) bool {
+	return ch == '_' || ch == '$' ||
+		('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') ||
+		ch >= 0x80
+}
+
+// matchKeywords reports whether the tokens start with the keywords.
+func matchKeywords(tokens []token, keywords ...string) bool {
+	if len(tokens) < len(keywords) {
+		return false
+	}
+	for i, keyword := range keywords {
+		if !tokens[i].isKeyword(keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// literals returns the values of all literal tokens.
+func literals(tokens []token) []string {
+	var values []string
+	for _, tok := range tokens {
+		if tok.kind == tokenLiteral {
+			values = append(values, tok.value)
+		}
+	}
+	return values
+}
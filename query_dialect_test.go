@@ -0,0 +1,127 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestCustomQueryDialect tests that the queries of a custom
+// QueryDialect are used instead of the default ones.
+func TestCustomQueryDialect(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &customQueryProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "query_dialect_template",
+		QueryDialect:       customQueryDialect{},
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	_, dbName, err := tm.CreateTestDatabase(ctx, "query_dialect_db")
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		// The template is checked again once its creation is locked.
+		"SELECT datistemplate FROM pg_database WHERE datname = 'query_dialect_template' LIMIT 1",
+		"SELECT datistemplate FROM pg_database WHERE datname = 'query_dialect_template' LIMIT 1",
+		`ALTER DATABASE "query_dialect_template" WITH is_template TRUE`,
+		"SELECT COUNT(rds_terminate_backend(pid)) FROM pg_stat_activity WHERE datname IN ('query_dialect_db')",
+		"SELECT COUNT(rds_terminate_backend(pid)) FROM pg_stat_activity WHERE datname IN ('query_dialect_template')",
+		`ALTER DATABASE "query_dialect_template" WITH is_template FALSE`,
+	})
+}
+
+// customQueryPrefix marks the queries of customQueryDialect.
+const customQueryPrefix = "/* custom */ "
+
+// customQueryDialect overrides the terminate query, like managed services
+// require, and marks all its queries with customQueryPrefix.
+type customQueryDialect struct {
+	pgdbtemplate.PostgresQueryDialect
+}
+
+// TerminateConnectionsQuery implements pgdbtemplate.QueryDialect.TerminateConnectionsQuery.
+func (customQueryDialect) TerminateConnectionsQuery(dbNames []string) string {
+	quotedNames := make([]string, len(dbNames))
+	for i, dbName := range dbNames {
+		quotedNames[i] = pgdbtemplate.QuoteLiteral(dbName)
+	}
+	return customQueryPrefix + "SELECT COUNT(rds_terminate_backend(pid)) FROM pg_stat_activity WHERE datname IN (" +
+		strings.Join(quotedNames, ", ") + ")"
+}
+
+// DatabaseExistsQuery implements pgdbtemplate.QueryDialect.DatabaseExistsQuery.
+func (d customQueryDialect) DatabaseExistsQuery(dbName string) string {
+	return customQueryPrefix + d.PostgresQueryDialect.DatabaseExistsQuery(dbName)
+}
+
+// MarkTemplateQuery implements pgdbtemplate.QueryDialect.MarkTemplateQuery.
+func (d customQueryDialect) MarkTemplateQuery(dbName string, isTemplate bool) string {
+	return customQueryPrefix + d.PostgresQueryDialect.MarkTemplateQuery(dbName, isTemplate)
+}
+
+// customQueryProvider wraps a ConnectionProvider, recording the queries
+// of customQueryDialect and passing them on without their prefix.
+type customQueryProvider struct {
+	pgdbtemplate.ConnectionProvider
+
+	mu      sync.Mutex
+	queries []string
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *customQueryProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	return &customQueryConnection{DatabaseConnection: conn, provider: p}, nil
+}
+
+// record records the query if it is a custom one and strips its prefix.
+func (p *customQueryProvider) record(query string) string {
+	if !strings.HasPrefix(query, customQueryPrefix) {
+		return query
+	}
+	query = strings.TrimPrefix(query, customQueryPrefix)
+	p.mu.Lock()
+	p.queries = append(p.queries, query)
+	p.mu.Unlock()
+	return query
+}
+
+// recordedQueries returns the custom queries in order.
+func (p *customQueryProvider) recordedQueries() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.queries...)
+}
+
+// customQueryConnection is the connection of customQueryProvider.
+type customQueryConnection struct {
+	pgdbtemplate.DatabaseConnection
+	provider *customQueryProvider
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *customQueryConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	return c.DatabaseConnection.ExecContext(ctx, c.provider.record(query), args...)
+}
+
+// QueryRowContext implements pgdbtemplate.DatabaseConnection.QueryRowContext.
+func (c *customQueryConnection) QueryRowContext(ctx context.Context, query string, args ...any) pgdbtemplate.Row {
+	return c.DatabaseConnection.QueryRowContext(ctx, c.provider.record(query), args...)
+}
@@ -0,0 +1,21 @@
+package pgdbtemplate
+
+import "github.com/andrei-polukhin/pgdbtemplate/internal/formatters"
+
+// QuoteIdentifier quotes an "identifier" (e.g. a database or a table name)
+// to be used as part of an SQL statement, exactly as the template manager
+// quotes database names.
+//
+// This is useful for custom connection providers and migration runners.
+func QuoteIdentifier(name string) string {
+	return formatters.QuoteIdentifier(name)
+}
+
+// QuoteLiteral quotes a 'literal' (e.g. a parameter of a DDL statement,
+// which does not accept parameters) to be used as part of an SQL statement,
+// exactly as the template manager quotes literals.
+//
+// This is useful for custom connection providers and migration runners.
+func QuoteLiteral(literal string) string {
+	return formatters.QuoteLiteral(literal)
+}
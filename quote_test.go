@@ -0,0 +1,58 @@
+package pgdbtemplate_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	var cases = []struct {
+		input string
+		want  string
+	}{
+		{`foo`, `"foo"`},
+		{`foo bar baz`, `"foo bar baz"`},
+		{`foo"bar`, `"foo""bar"`},
+		{"foo\x00bar", `"foo"`},
+		{"\x00foo", `""`},
+		{`тест_база`, `"тест_база"`},
+	}
+
+	for _, test := range cases {
+		got := pgdbtemplate.QuoteIdentifier(test.input)
+		c.Assert(got, qt.Equals, test.want)
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	var cases = []struct {
+		input string
+		want  string
+	}{
+		{`foo`, `'foo'`},
+		{`foo bar baz`, `'foo bar baz'`},
+		{`foo'bar`, `'foo''bar'`},
+		{`foo\bar`, ` E'foo\\bar'`},
+		{`foo\ba'r`, ` E'foo\\ba''r'`},
+		{`foo"bar`, `'foo"bar'`},
+		{`'`, `''''`},
+		{`\`, ` E'\\'`},
+		{`'abc'; DROP TABLE users;`, `'''abc''; DROP TABLE users;'`},
+		{`E'\'abc\'; DROP TABLE users;'`, ` E'E''\\''abc\\''; DROP TABLE users;'''`},
+		{`тест_база`, `'тест_база'`},
+	}
+
+	for _, test := range cases {
+		got := pgdbtemplate.QuoteLiteral(test.input)
+		c.Assert(got, qt.Equals, test.want)
+	}
+}
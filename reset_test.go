@@ -0,0 +1,228 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestResetTestDatabase verifies that resetting a test database restores
+// the template state under the same name.
+func TestResetTestDatabase(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := newRowTrackingProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    insertRowMigrationRunner{},
+		TemplateName:       "reset_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	conn, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(countRows(ctx, c, conn), qt.Equals, 1)
+
+	// Add data which must not survive the reset.
+	_, err = conn.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ('extra')")
+	c.Assert(err, qt.IsNil)
+	c.Assert(countRows(ctx, c, conn), qt.Equals, 2)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	conn, err = tm.ResetTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.IsNil)
+	c.Assert(countRows(ctx, c, conn), qt.Equals, 1)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	// The reset database is still tracked and dropped by Cleanup.
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(provider.exists(testDBName), qt.IsFalse)
+}
+
+// TestResetTestDatabaseOptions verifies that resetting a test database
+// drops it like DropTestDatabase, and creates it again with the options
+// it was created with.
+func TestResetTestDatabaseOptions(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var terminateQueries int
+	var droppedNames []string
+	metrics := &fakeMetrics{}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: setupTestConnectionProvider(),
+			beforeQueryRow: func(query string) pgdbtemplate.Row {
+				if strings.Contains(query, "pg_terminate_backend") {
+					terminateQueries++
+				}
+				return nil
+			},
+		},
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "reset_options_template",
+		SkipTerminateOnDrop: true,
+		Metrics:             metrics,
+		OnBeforeDropTestDatabase: func(ctx context.Context, name string) error {
+			droppedNames = append(droppedNames, name)
+			return nil
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	extraMigrations := &countingMigrationRunner{}
+	conn, testDBName, err := tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithExtraMigrations(extraMigrations))
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	conn, err = tm.ResetTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	c.Assert(droppedNames, qt.DeepEquals, []string{testDBName})
+	c.Assert(terminateQueries, qt.Equals, 0)
+	c.Assert(extraMigrations.count(), qt.Equals, int64(2))
+	c.Assert(metrics.created, qt.Equals, int64(2))
+	c.Assert(metrics.dropped, qt.Equals, int64(1))
+	c.Assert(tm.IsTracked(testDBName), qt.IsTrue)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+// TestResetTestDatabaseUntracked verifies that resetting a database
+// which the manager didn't create fails.
+func TestResetTestDatabaseUntracked(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "reset_untracked_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	_, err = tm.ResetTestDatabase(ctx, "reset_missing_db")
+	c.Assert(err, qt.ErrorMatches, `test database "reset_missing_db" cannot be reset, `+
+		`since it was not created by the manager or was dropped: database does not exist`)
+	c.Assert(tm.IsTracked("reset_missing_db"), qt.IsFalse)
+
+	// Dropped test databases cannot be reset either.
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.DropTestDatabase(ctx, testDBName), qt.IsNil)
+	_, err = tm.ResetTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.ErrorMatches, `test database ".*" cannot be reset, since it was not created by the manager or was dropped: database does not exist`)
+	c.Assert(tm.IsTracked(testDBName), qt.IsFalse)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+func countRows(ctx context.Context, c *qt.C, conn pgdbtemplate.DatabaseConnection) int {
+	var count int
+	err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+	c.Assert(err, qt.IsNil)
+	return count
+}
+
+// insertRowMigrationRunner is a migration runner inserting a single row.
+type insertRowMigrationRunner struct{}
+
+// RunMigrations implements pgdbtemplate.MigrationRunner.RunMigrations.
+func (insertRowMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	_, err := conn.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ('template')")
+	return err
+}
+
+// rowTrackingProvider models databases holding a number of rows,
+// which are copied when a database is created from a template.
+type rowTrackingProvider struct {
+	mu   sync.Mutex
+	rows map[string]int
+}
+
+func newRowTrackingProvider() *rowTrackingProvider {
+	return &rowTrackingProvider{rows: map[string]int{"postgres": 0}}
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *rowTrackingProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	return &rowTrackingConnection{provider: p, dbName: databaseName}, nil
+}
+
+// GetNoRowsSentinel implements pgdbtemplate.ConnectionProvider.GetNoRowsSentinel.
+func (*rowTrackingProvider) GetNoRowsSentinel() error {
+	return sql.ErrNoRows
+}
+
+func (p *rowTrackingProvider) exists(dbName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.rows[dbName]
+	return ok
+}
+
+// rowTrackingConnection is a connection to a rowTrackingProvider database.
+type rowTrackingConnection struct {
+	provider *rowTrackingProvider
+	dbName   string
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c *rowTrackingConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	p := c.provider
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parts := strings.Fields(query)
+	switch {
+	case strings.HasPrefix(query, "CREATE DATABASE"):
+		dbName := strings.Trim(parts[2], `"`)
+		if _, ok := p.rows[dbName]; ok {
+			return nil, fmt.Errorf("database %q already exists", dbName)
+		}
+		source := ""
+		if len(parts) >= 5 && parts[3] == "TEMPLATE" {
+			source = strings.Trim(parts[4], `"`)
+		}
+		p.rows[dbName] = p.rows[source]
+	case strings.HasPrefix(query, "DROP DATABASE"):
+		dbName := strings.Trim(parts[2], `"`)
+		if _, ok := p.rows[dbName]; !ok {
+			return nil, fmt.Errorf("database %q does not exist", dbName)
+		}
+		delete(p.rows, dbName)
+	case strings.HasPrefix(query, "INSERT INTO"):
+		p.rows[c.dbName]++
+	}
+	return nil, nil
+}
+
+// QueryRowContext implements pgdbtemplate.DatabaseConnection.QueryRowContext.
+func (c *rowTrackingConnection) QueryRowContext(ctx context.Context, query string, args ...any) pgdbtemplate.Row {
+	p := c.provider
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if strings.Contains(query, "FROM pg_database") {
+		return &sharedMockRow{err: sql.ErrNoRows}
+	}
+	return &sharedMockRow{data: []any{p.rows[c.dbName]}}
+}
+
+// Close implements pgdbtemplate.DatabaseConnection.Close.
+func (*rowTrackingConnection) Close() error {
+	return nil
+}
@@ -0,0 +1,220 @@
+package pgdbtemplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andrei-polukhin/pgdbtemplate/internal/formatters"
+)
+
+// SchemaConfig holds configuration for the schema template manager.
+type SchemaConfig struct {
+	// ConnectionProvider provides connections to DatabaseName,
+	// on which test schemas are created and dropped.
+	//
+	// This field is required.
+	ConnectionProvider ConnectionProvider
+	// MigrationRunner runs migrations on every test schema.
+	//
+	// This field is required.
+	MigrationRunner MigrationRunner
+	// DatabaseName is the name of the existing database
+	// holding the test schemas.
+	//
+	// This field is required.
+	DatabaseName string
+	// ConnectSchema connects to DatabaseName with the search_path set to
+	// the schema, so that migrations and tests create and use their
+	// objects in it. It is typically implemented by adding
+	// "options=-csearch_path=<schema>" to the connection string.
+	//
+	// The search_path is set on connection rather than with SET,
+	// since DatabaseConnection may be a pool of connections.
+	//
+	// This field is required.
+	ConnectSchema func(ctx context.Context, schemaName string) (DatabaseConnection, error)
+	// TestSchemaPrefix is the prefix of generated test schema names.
+	//
+	// If empty, "test_schema_" is used.
+	TestSchemaPrefix string
+	// Clock is used for the timestamps embedded in generated names.
+	//
+	// If nil, the system clock is used.
+	Clock Clock
+}
+
+// SchemaTemplateManager isolates tests in separate schemas of a single
+// database, for PostgreSQL servers where creating databases is not
+// allowed, e.g. on shared hosting.
+//
+// PostgreSQL cannot copy schemas, so migrations are run on every test
+// schema, which makes creating them slower than copying a template
+// database with TemplateManager.
+type SchemaTemplateManager struct {
+	provider      ConnectionProvider
+	migrator      MigrationRunner
+	databaseName  string
+	connectSchema func(ctx context.Context, schemaName string) (DatabaseConnection, error)
+	testPrefix    string
+	clock         Clock
+
+	createdTestSchemas sync.Map // Tracks created test schemas for cleanup.
+}
+
+// NewSchemaTemplateManager creates a new schema template manager.
+func NewSchemaTemplateManager(config SchemaConfig) (*SchemaTemplateManager, error) {
+	if config.ConnectionProvider == nil {
+		return nil, fmt.Errorf("ConnectionProvider is required")
+	}
+	if config.MigrationRunner == nil {
+		return nil, fmt.Errorf("MigrationRunner is required")
+	}
+	if config.DatabaseName == "" {
+		return nil, fmt.Errorf("DatabaseName is required")
+	}
+	if config.ConnectSchema == nil {
+		return nil, fmt.Errorf("ConnectSchema is required")
+	}
+
+	testPrefix := config.TestSchemaPrefix
+	if testPrefix == "" {
+		testPrefix = "test_schema_"
+	}
+	if len(testPrefix) > maxIdentifierLength-maxGeneratedSuffixLength {
+		return nil, fmt.Errorf(
+			"TestSchemaPrefix %q is %d bytes long, but at most %d bytes are allowed, since generated names add up to %d bytes to it",
+			testPrefix, len(testPrefix), maxIdentifierLength-maxGeneratedSuffixLength, maxGeneratedSuffixLength,
+		)
+	}
+
+	clock := config.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &SchemaTemplateManager{
+		provider:      config.ConnectionProvider,
+		migrator:      config.MigrationRunner,
+		databaseName:  config.DatabaseName,
+		connectSchema: config.ConnectSchema,
+		testPrefix:    testPrefix,
+		clock:         clock,
+	}, nil
+}
+
+// CreateTestSchema creates a test schema, runs the migrations on it
+// and returns a connection using it.
+//
+// If testSchemaName is not provided, a unique name is generated.
+// The schema is tracked and dropped by Cleanup.
+func (sm *SchemaTemplateManager) CreateTestSchema(ctx context.Context, testSchemaName ...string) (_ DatabaseConnection, _ string, err error) {
+	var schemaName string
+	if len(testSchemaName) > 0 && testSchemaName[0] != "" {
+		schemaName = testSchemaName[0]
+	} else {
+		schemaName = fmt.Sprintf("%s%d_%d", sm.testPrefix, sm.clock.Now().UnixNano(), atomic.AddInt64(&globalTestDBCounter, 1))
+	}
+	if err := checkIdentifierLength("test schema name", schemaName); err != nil {
+		return nil, "", err
+	}
+
+	adminConn, err := sm.provider.Connect(ctx, sm.databaseName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to database %q: %w", sm.databaseName, err)
+	}
+	defer adminConn.Close()
+
+	createQuery := "CREATE SCHEMA " + formatters.QuoteIdentifier(schemaName)
+	if _, err := adminConn.ExecContext(ctx, createQuery); err != nil {
+		return nil, "", fmt.Errorf("failed to create test schema %q: %w", schemaName, err)
+	}
+
+	// Should any further steps fail, ensure we drop the created schema.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if dropErr := sm.dropSchema(ctx, adminConn, schemaName); dropErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to drop test schema: %w", dropErr))
+		}
+	}()
+
+	conn, err := sm.connectSchema(ctx, schemaName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to test schema %q: %w", schemaName, err)
+	}
+	if err := sm.migrator.RunMigrations(ctx, conn); err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("failed to run migrations on test schema %q: %w", schemaName, err)
+	}
+
+	sm.createdTestSchemas.Store(schemaName, true)
+	return conn, schemaName, nil
+}
+
+// DropTestSchema drops a test schema with all its objects.
+//
+// Connections using the schema should be closed first,
+// since dropping it waits for their locks to be released.
+func (sm *SchemaTemplateManager) DropTestSchema(ctx context.Context, schemaName string) error {
+	adminConn, err := sm.provider.Connect(ctx, sm.databaseName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database %q: %w", sm.databaseName, err)
+	}
+	defer adminConn.Close()
+
+	if err := sm.dropSchema(ctx, adminConn, schemaName); err != nil {
+		return fmt.Errorf("failed to drop test schema %q: %w", schemaName, err)
+	}
+	sm.createdTestSchemas.Delete(schemaName)
+	return nil
+}
+
+// Cleanup drops all tracked test schemas.
+//
+// Schemas which failed to be dropped stay tracked,
+// so Cleanup can be called again.
+func (sm *SchemaTemplateManager) Cleanup(ctx context.Context) error {
+	schemaNames := sm.trackedTestSchemas()
+	if len(schemaNames) == 0 {
+		return nil
+	}
+
+	adminConn, err := sm.provider.Connect(ctx, sm.databaseName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database %q: %w", sm.databaseName, err)
+	}
+	defer adminConn.Close()
+
+	var errs error
+	for _, schemaName := range schemaNames {
+		if err := sm.dropSchema(ctx, adminConn, schemaName); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to drop test schema %q: %w", schemaName, err))
+			continue
+		}
+		sm.createdTestSchemas.Delete(schemaName)
+	}
+	return errs
+}
+
+// dropSchema drops the schema with all its objects.
+func (sm *SchemaTemplateManager) dropSchema(ctx context.Context, adminConn DatabaseConnection, schemaName string) error {
+	dropQuery := fmt.Sprintf("DROP SCHEMA %s CASCADE", formatters.QuoteIdentifier(schemaName))
+	_, err := adminConn.ExecContext(ctx, dropQuery)
+	return err
+}
+
+// trackedTestSchemas returns the sorted names of the tracked test schemas.
+func (sm *SchemaTemplateManager) trackedTestSchemas() []string {
+	var schemaNames []string
+	sm.createdTestSchemas.Range(func(key, _ any) bool {
+		schemaNames = append(schemaNames, key.(string))
+		return true
+	})
+	sort.Strings(schemaNames)
+	return schemaNames
+}
@@ -0,0 +1,158 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestSchemaTemplateManager tests the lifecycle of test schemas.
+func TestSchemaTemplateManager(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	var schemaConnects []string
+	sm, err := pgdbtemplate.NewSchemaTemplateManager(pgdbtemplate.SchemaConfig{
+		ConnectionProvider: provider,
+		MigrationRunner:    pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id INT)"),
+		DatabaseName:       "app",
+		ConnectSchema: func(ctx context.Context, schemaName string) (pgdbtemplate.DatabaseConnection, error) {
+			schemaConnects = append(schemaConnects, schemaName)
+			return provider.Connect(ctx, "app")
+		},
+		Clock: fixedClock{now: time.Unix(0, 42)},
+	})
+	c.Assert(err, qt.IsNil)
+
+	conn, schemaName, err := sm.CreateTestSchema(ctx, "users_schema")
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+	c.Assert(schemaName, qt.Equals, "users_schema")
+	c.Assert(schemaConnects, qt.DeepEquals, []string{"users_schema"})
+
+	conn, generatedName, err := sm.CreateTestSchema(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+	c.Assert(generatedName, qt.Matches, `test_schema_42_\d+`)
+
+	c.Assert(sm.DropTestSchema(ctx, "users_schema"), qt.IsNil)
+	c.Assert(sm.Cleanup(ctx), qt.IsNil)
+	// Nothing is left to drop.
+	c.Assert(sm.Cleanup(ctx), qt.IsNil)
+
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`CREATE SCHEMA "users_schema"`,
+		"CREATE TABLE users (id INT)",
+		`CREATE SCHEMA "` + generatedName + `"`,
+		"CREATE TABLE users (id INT)",
+		`DROP SCHEMA "users_schema" CASCADE`,
+		`DROP SCHEMA "` + generatedName + `" CASCADE`,
+	})
+	for _, dbName := range provider.recordedConnects() {
+		c.Assert(dbName, qt.Equals, "app")
+	}
+}
+
+// TestSchemaTemplateManagerErrors tests that test schemas
+// are dropped if they cannot be set up.
+func TestSchemaTemplateManagerErrors(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Migrations fail", func(c *qt.C) {
+		provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+		sm, err := pgdbtemplate.NewSchemaTemplateManager(pgdbtemplate.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    &failingMigrationRunner{errorMsg: "migration error"},
+			DatabaseName:       "app",
+			ConnectSchema: func(ctx context.Context, schemaName string) (pgdbtemplate.DatabaseConnection, error) {
+				return provider.Connect(ctx, "app")
+			},
+		})
+		c.Assert(err, qt.IsNil)
+
+		_, _, err = sm.CreateTestSchema(ctx, "failing_schema")
+		c.Assert(err, qt.ErrorMatches, `failed to run migrations on test schema "failing_schema": migration error`)
+		c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+			`CREATE SCHEMA "failing_schema"`,
+			`DROP SCHEMA "failing_schema" CASCADE`,
+		})
+
+		// The schema is not tracked.
+		c.Assert(sm.Cleanup(ctx), qt.IsNil)
+		c.Assert(provider.recordedQueries(), qt.HasLen, 2)
+	})
+
+	c.Run("Connecting to the schema fails", func(c *qt.C) {
+		provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+		sm, err := pgdbtemplate.NewSchemaTemplateManager(pgdbtemplate.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			DatabaseName:       "app",
+			ConnectSchema: func(ctx context.Context, schemaName string) (pgdbtemplate.DatabaseConnection, error) {
+				return nil, errors.New("connect error")
+			},
+		})
+		c.Assert(err, qt.IsNil)
+
+		_, _, err = sm.CreateTestSchema(ctx, "unreachable_schema")
+		c.Assert(err, qt.ErrorMatches, `failed to connect to test schema "unreachable_schema": connect error`)
+		c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+			`CREATE SCHEMA "unreachable_schema"`,
+			`DROP SCHEMA "unreachable_schema" CASCADE`,
+		})
+	})
+
+	c.Run("Invalid configuration", func(c *qt.C) {
+		connectSchema := func(ctx context.Context, schemaName string) (pgdbtemplate.DatabaseConnection, error) {
+			return nil, nil
+		}
+		tests := []struct {
+			name   string
+			config pgdbtemplate.SchemaConfig
+
+			expectedErr string
+		}{{
+			name: "No database name",
+			config: pgdbtemplate.SchemaConfig{
+				ConnectionProvider: setupTestConnectionProvider(),
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				ConnectSchema:      connectSchema,
+			},
+			expectedErr: "DatabaseName is required",
+		}, {
+			name: "No ConnectSchema",
+			config: pgdbtemplate.SchemaConfig{
+				ConnectionProvider: setupTestConnectionProvider(),
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				DatabaseName:       "app",
+			},
+			expectedErr: "ConnectSchema is required",
+		}, {
+			name: "Long prefix",
+			config: pgdbtemplate.SchemaConfig{
+				ConnectionProvider: setupTestConnectionProvider(),
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				DatabaseName:       "app",
+				ConnectSchema:      connectSchema,
+				TestSchemaPrefix:   "a_very_long_test_schema_prefix_of_forty_bytes",
+			},
+			expectedErr: `TestSchemaPrefix ".*" is 45 bytes long, but at most 36 bytes are allowed, .*`,
+		}}
+
+		for _, test := range tests {
+			c.Run(test.name, func(c *qt.C) {
+				_, err := pgdbtemplate.NewSchemaTemplateManager(test.config)
+				c.Assert(err, qt.ErrorMatches, test.expectedErr)
+			})
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestAssumeTemplateReady tests that a template built by another manager
+// is used without creating or migrating it.
+func TestAssumeTemplateReady(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	newConsumer := func(c *qt.C, templateName string) (*pgdbtemplate.TemplateManager, *recordingConnectionProvider) {
+		provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:  provider,
+			MigrationRunner:     pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id INT)"),
+			TemplateName:        templateName,
+			AssumeTemplateReady: true,
+		})
+		c.Assert(err, qt.IsNil)
+		return tm, provider
+	}
+
+	c.Run("Ready", func(c *qt.C) {
+		seeder, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "ready_template",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(seeder.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(seeder.Cleanup(ctx), qt.IsNil) }()
+
+		tm, provider := newConsumer(c, "ready_template")
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+
+		conn, dbName, err := tm.CreateTestDatabase(ctx, "ready_test_db")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+			`CREATE DATABASE "ready_test_db" TEMPLATE "ready_template"`,
+		})
+
+		// The template is kept for other consumers.
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+		c.Assert(databaseExists(ctx, connProvider, "ready_template"), qt.IsTrue)
+	})
+
+	c.Run("Missing", func(c *qt.C) {
+		tm, provider := newConsumer(c, "missing_template")
+		err := tm.Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, `failed to verify template database: database "missing_template" does not exist: `+
+			`template database is not initialized`)
+		c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsTrue)
+		c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+		c.Assert(databaseExists(ctx, connProvider, "missing_template"), qt.IsFalse)
+	})
+
+	c.Run("Not marked as a template", func(c *qt.C) {
+		adminConn, err := connProvider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		_, err = adminConn.ExecContext(ctx, `CREATE DATABASE "unmarked_template"`)
+		c.Assert(err, qt.IsNil)
+
+		tm, provider := newConsumer(c, "unmarked_template")
+		err = tm.Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, `failed to verify template database: database "unmarked_template" exists, `+
+			`but is not marked as a template yet: template database is not initialized`)
+		c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsTrue)
+		c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+	})
+
+	c.Run("Invalid configuration", func(c *qt.C) {
+		_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:  connProvider,
+			MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+			AssumeTemplateReady: true,
+		})
+		c.Assert(err, qt.ErrorMatches, "TemplateName is required with AssumeTemplateReady")
+
+		_, err = pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:  connProvider,
+			MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:        "ready_template",
+			AssumeTemplateReady: true,
+			ForceRecreate:       true,
+		})
+		c.Assert(err, qt.ErrorMatches, "ForceRecreate cannot be used together with AssumeTemplateReady")
+	})
+}
+
+// TestExternalTemplate tests copying a template built by a separate
+// tool, which is neither checked nor dropped.
+func TestExternalTemplate(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	adminConn, err := connProvider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	// The template is not marked as a template by the separate tool.
+	_, err = adminConn.ExecContext(ctx, `CREATE DATABASE "external_template"`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(adminConn.Close(), qt.IsNil)
+
+	provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:  provider,
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "external_template",
+		AssumeTemplateReady: true,
+		ExternalTemplate:    true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(provider.recordedConnects(), qt.HasLen, 0)
+
+	conn, dbName, err := tm.CreateTestDatabase(ctx, "external_test_db")
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+	c.Assert(databaseExists(ctx, connProvider, "external_template"), qt.IsTrue)
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`CREATE DATABASE "external_test_db" TEMPLATE "external_template"`,
+		`DROP DATABASE "external_test_db"`,
+	})
+
+	c.Run("Without AssumeTemplateReady", func(c *qt.C) {
+		_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "external_template",
+			ExternalTemplate:   true,
+		})
+		c.Assert(err, qt.ErrorMatches, "ExternalTemplate requires AssumeTemplateReady")
+	})
+}
+
+// TestSeedTemplate tests seeding the template repeatedly
+// and using it from another manager.
+func TestSeedTemplate(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+	config := pgdbtemplate.Config{
+		ConnectionProvider:   provider,
+		MigrationRunner:      pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id INT)"),
+		TemplateName:         "seeded_template",
+		ReuseAdminConnection: true,
+	}
+
+	c.Assert(pgdbtemplate.SeedTemplate(ctx, config), qt.IsNil)
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`CREATE DATABASE "seeded_template"`,
+		"CREATE TABLE users (id INT)",
+		`ALTER DATABASE "seeded_template" WITH is_template TRUE`,
+	})
+
+	// Seeding again is a no-op.
+	c.Assert(pgdbtemplate.SeedTemplate(ctx, config), qt.IsNil)
+	c.Assert(provider.recordedQueries(), qt.HasLen, 3)
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:  connProvider,
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "seeded_template",
+		AssumeTemplateReady: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	conn, dbName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsTrue)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+
+	c.Run("Invalid configuration", func(c *qt.C) {
+		err := pgdbtemplate.SeedTemplate(ctx, pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.ErrorMatches, "TemplateName is required to seed the template")
+
+		err = pgdbtemplate.SeedTemplate(ctx, pgdbtemplate.Config{
+			ConnectionProvider:  connProvider,
+			MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:        "seeded_template",
+			AssumeTemplateReady: true,
+		})
+		c.Assert(err, qt.ErrorMatches, "AssumeTemplateReady cannot be used to seed the template")
+	})
+}
@@ -2,8 +2,11 @@ package pgdbtemplate
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,6 +19,35 @@ import (
 // per PostgreSQL conventions.
 const defaultAdminDBName = "postgres"
 
+const (
+	// maxIdentifierLength is the maximum length of identifiers in bytes,
+	// beyond which PostgreSQL silently truncates them.
+	maxIdentifierLength = 63
+	// maxGeneratedSuffixLength is the length in bytes of the suffix
+	// appended to TestDBPrefix by generateTestDBName: a nanosecond timestamp
+	// of 19 digits, an underscore and a counter of up to 7 digits.
+	// Longer names, generated after ten million test databases,
+	// are still rejected by CreateTestDatabase.
+	maxGeneratedSuffixLength = 27
+)
+
+// defaultTerminateBatchSize is the default maximum number of databases
+// whose connections are terminated by a single query.
+const defaultTerminateBatchSize = 1000
+
+// maxRandomNameAttempts is how many random test database names are
+// generated with UseRandomNames before giving up on finding one
+// which is not tracked yet.
+const maxRandomNameAttempts = 10
+
+// templatePollInterval is how often Initialize checks whether a template
+// database created concurrently by another manager is ready.
+const templatePollInterval = 50 * time.Millisecond
+
+// errTemplateDropped is returned when waiting for a template database
+// which the manager creating it dropped, e.g. as its migrations failed.
+var errTemplateDropped = errors.New("template database was dropped while being created concurrently")
+
 // Atomic counters for thread-safe unique name generation.
 var (
 	// globalTemplateCounter is a global atomic counter for unique template names
@@ -27,6 +59,18 @@ var (
 	globalTestDBCounter int64
 )
 
+// ResetCountersForTesting resets the counters embedded in generated
+// template and test database names, so that the next generated names
+// use the counter 1 again, e.g. in tests asserting name generation.
+//
+// It is meant for tests only: generated names are no longer unique
+// across the process after it is called, so it must not be called while
+// template managers are in use, nor from parallel tests.
+func ResetCountersForTesting() {
+	atomic.StoreInt64(&globalTemplateCounter, 0)
+	atomic.StoreInt64(&globalTestDBCounter, 0)
+}
+
 // Row represents a database row result that can be scanned.
 type Row interface {
 	// Scan scans the row into the provided destination variables.
@@ -43,6 +87,19 @@ type DatabaseConnection interface {
 	Close() error
 }
 
+// Unwrapper is optionally implemented by a DatabaseConnection
+// to expose the underlying driver object, so that application code
+// under test can use it directly:
+//
+//	db := conn.(pgdbtemplate.Unwrapper).Unwrap().(*sql.DB)
+//
+// The connections of the pgdbtemplate-pq provider return a *sql.DB,
+// and the connections of the pgdbtemplate-pgx provider a *pgxpool.Pool.
+type Unwrapper interface {
+	// Unwrap returns the underlying driver object.
+	Unwrap() any
+}
+
 // ConnectionProvider creates PostgreSQL database connections.
 type ConnectionProvider interface {
 	// Connect creates a connection to the specified database.
@@ -55,6 +112,19 @@ type ConnectionProvider interface {
 	GetNoRowsSentinel() error
 }
 
+// DatabaseCloser is optionally implemented by a ConnectionProvider
+// keeping connections open after they are closed by the template manager,
+// e.g. in a pool per database like the pgdbtemplate-pgx provider.
+//
+// PostgreSQL refuses to copy a database other sessions are connected to,
+// so CloseDatabase is called with the source template before every test
+// database is copied from it.
+type DatabaseCloser interface {
+	// CloseDatabase closes all connections kept open to the database.
+	// It must succeed if there are none.
+	CloseDatabase(databaseName string) error
+}
+
 // MigrationRunner executes migrations on a PostgreSQL database connection.
 type MigrationRunner interface {
 	// RunMigrations runs all migrations on the provided connection.
@@ -66,15 +136,64 @@ type MigrationRunner interface {
 type TemplateManager struct {
 	provider ConnectionProvider
 	migrator MigrationRunner
+	tracer   Tracer
+	metrics  Metrics
+	clock    Clock
 
-	templateName string
-	testPrefix   string
-	adminDBName  string
+	onTestDatabaseCreated    func(ctx context.Context, conn DatabaseConnection, name string) error
+	onBeforeDropTestDatabase func(ctx context.Context, name string) error
 
-	mu          sync.Mutex
-	initialized bool
+	extensions       []string
+	postMigrationSQL []string
+	verifyQuery      string
 
-	createdTestDBs sync.Map // Tracks created test databases for cleanup.
+	templateName   string
+	testPrefix     string
+	testDBNameFunc func() string
+	randomNames    bool
+	randRead       func(b []byte) (int, error)
+	adminDBName    string
+	dialect        Dialect
+	queryDialect   QueryDialect
+
+	templateOwner     string
+	templateEncoding  string
+	templateLCCollate string
+	templateLCCtype   string
+
+	createTemplateSQLFunc func(templateName string) string
+	createTestDBSQLFunc   func(dbName, templateName string) string
+
+	mu             sync.Mutex
+	initialized    bool
+	initErr        error // Error of the last failed template creation.
+	closed         bool  // Set by Cleanup, reset by Initialize.
+	providerClosed bool  // Set by Close.
+	closeOnce      sync.Once
+	autoInitialize bool
+	forceRecreate  bool
+	assumeReady    bool
+	external       bool
+	skipMarking    bool
+	keepOnFailure  bool
+
+	templateWaitTimeout time.Duration
+
+	// adminMu guards adminConn, and is held while adminConn is in use.
+	// It is separate from mu, since Initialize and Cleanup use the admin
+	// connection while holding mu.
+	adminMu        sync.Mutex
+	adminConn      DatabaseConnection
+	reuseAdminConn bool
+
+	batchCreateConcurrency int
+	cleanupConcurrency     int
+	terminateBatchSize     int
+	skipTerminateOnDrop    bool
+	verifyTestDBConn       bool
+	analyzeTestDBs         bool
+
+	createdTestDBs sync.Map // Tracks created test databases for cleanup, as trackedTestDatabase.
 }
 
 // Config holds configuration for the template manager.
@@ -88,18 +207,270 @@ type Config struct {
 	// This field is required.
 	MigrationRunner MigrationRunner
 	// TemplateName is the name of the template database.
+	// It may be at most 63 bytes long.
 	//
 	// If empty, a unique name will be generated.
 	TemplateName string
 	// TestDBPrefix is the prefix for test database names.
+	// It may be at most 36 bytes long, so that generated names
+	// don't exceed PostgreSQL's limit of 63 bytes.
 	//
 	// If empty, "test_" will be used.
 	TestDBPrefix string
+	// StrictTestDBPrefix makes NewTemplateManager reject a TemplateName
+	// starting with TestDBPrefix, e.g. when external tooling drops
+	// leftover test databases by their prefix, which would match
+	// the template as well.
+	StrictTestDBPrefix bool
+	// TestDBNameFunc generates the names of test databases created without
+	// an explicit name, e.g. from t.Name() and a short hash, instead of
+	// TestDBPrefix followed by a timestamp and a counter.
+	//
+	// The names are used as is. Names of test databases which are still
+	// tracked by the manager are rejected with ErrDatabaseAlreadyExists.
+	// If nil, the default naming scheme is used.
+	TestDBNameFunc func() string
+	// UseRandomNames makes generated test database names consist of
+	// TestDBPrefix followed by 8 random hexadecimal digits from
+	// crypto/rand, instead of a timestamp and a process-wide counter.
+	// Such names do not depend on the clock or on the counter, which
+	// can collide across processes resetting it.
+	//
+	// Names of test databases which are still tracked by the manager
+	// are generated again.
+	//
+	// It cannot be used together with TestDBNameFunc.
+	UseRandomNames bool
 	// AdminDBName is the name of the administrative database to connect to
-	// for creating and dropping databases.
+	// for all administrative operations: checking whether the template
+	// exists, creating, marking and dropping databases and terminating
+	// connections to them. Only migrations and test connections use
+	// the template and test databases themselves.
 	//
+	// It must be neither TemplateName nor template0 or template1, since
+	// PostgreSQL cannot copy a template while someone is connected to it.
 	// If empty, "postgres" will be used.
 	AdminDBName string
+	// ReuseAdminConnection makes the manager open a single connection
+	// to the admin database on first use and reuse it for all operations
+	// until Cleanup closes it. Since a connection cannot be used
+	// concurrently, operations using it wait for each other, and Cleanup
+	// waits for the operations in progress before closing it.
+	// OnTestDatabaseCreated is called while the connection is in use,
+	// so it must not call back into the manager.
+	//
+	// If false, every operation opens and closes its own admin connection.
+	ReuseAdminConnection bool
+	// BatchCreateConcurrency is the maximum number of test databases
+	// CreateTestDatabases creates concurrently. Every concurrent creation
+	// beyond the first one uses its own admin connection.
+	//
+	// If zero or negative, databases are created one at a time.
+	BatchCreateConcurrency int
+	// CleanupConcurrency is the maximum number of tracked test databases
+	// Cleanup drops concurrently. Every concurrent drop beyond the first
+	// one uses its own admin connection.
+	//
+	// If zero or negative, databases are dropped one at a time.
+	CleanupConcurrency int
+	// TerminateBatchSize is the maximum number of databases whose
+	// connections are terminated by a single query, so that the query
+	// stays small when thousands of test databases are cleaned up.
+	//
+	// If zero or negative, 1000 is used.
+	TerminateBatchSize int
+	// SkipTerminateOnDrop makes DropTestDatabase drop the database
+	// right away instead of terminating connections to it first,
+	// saving a round trip when tests close their connections.
+	//
+	// If the database is still being accessed, connections to it
+	// are terminated and dropping it is retried.
+	SkipTerminateOnDrop bool
+	// VerifyTestDBConnection makes CreateTestDatabase check with
+	// SELECT current_database() that the connection to a new test
+	// database is connected to it, catching connection providers which
+	// ignore the database name and connect to the admin database instead.
+	//
+	// If the check fails, the test database is dropped and
+	// CreateTestDatabase returns an error.
+	VerifyTestDBConnection bool
+	// AnalyzeTestDatabases makes CreateTestDatabase run ANALYZE on every
+	// new test database before returning it, e.g. for tests asserting
+	// on query plans. Copies inherit the planner statistics of the
+	// template, so this is usually redundant and slows creation down.
+	//
+	// If ANALYZE fails, the test database is dropped and
+	// CreateTestDatabase returns an error.
+	AnalyzeTestDatabases bool
+	// ForceRecreate makes Initialize drop and recreate a database
+	// named TemplateName which exists but is not marked as a template,
+	// e.g. because a previous Initialize was interrupted. A database
+	// another manager is still creating is waited for instead.
+	//
+	// If false, Initialize fails if such a database exists.
+	ForceRecreate bool
+	// TemplateWaitTimeout limits how long Initialize waits for
+	// the template database being created concurrently by another
+	// manager, e.g. in another test binary, to be marked as a template.
+	// Managers creating the template hold a PostgreSQL advisory lock
+	// on its name, on the admin connection, which the others wait for.
+	//
+	// If zero or negative, Initialize waits until its context is done.
+	TemplateWaitTimeout time.Duration
+	// KeepOnFailure keeps the template database if its migrations fail,
+	// and a test database if setting it up after copying the template
+	// fails, e.g. to connect to it and inspect the partial schema in CI.
+	// The error names the kept database, which is not dropped by Cleanup
+	// and must be dropped manually. Set ForceRecreate to rebuild a kept
+	// template.
+	//
+	// If false, such databases are dropped before the error is returned.
+	KeepOnFailure bool
+	// AssumeTemplateReady makes Initialize only check that the database
+	// named TemplateName exists and is marked as a template, without
+	// creating or migrating it, e.g. when a single setup step builds the
+	// template for the test binaries of all packages. Cleanup then drops
+	// the test databases, but keeps the template.
+	//
+	// TemplateName is required, and ForceRecreate cannot be used with it.
+	AssumeTemplateReady bool
+	// ExternalTemplate makes Initialize with AssumeTemplateReady skip
+	// checking the template, when it is built and owned by a separate tool,
+	// which does not necessarily mark it as a template. Initialize then
+	// only records the template name, and a missing template is reported
+	// by CreateTestDatabase.
+	//
+	// It requires AssumeTemplateReady.
+	ExternalTemplate bool
+	// SkipTemplateMarking makes the template manager use the template
+	// as an ordinary database to copy from, without marking it with
+	// ALTER DATABASE ... WITH is_template TRUE, e.g. on managed platforms
+	// not granting the privileges to do so. PostgreSQL only lets
+	// superusers and the owner of a database copy it if it is not
+	// marked, and only while nobody is connected to it.
+	//
+	// Since readiness cannot be told from the marking, a database named
+	// TemplateName which already exists is used as the template,
+	// even if a previous Initialize was interrupted.
+	SkipTemplateMarking bool
+	// Extensions are the names of extensions installed in order on the
+	// template database before migrations, e.g. "pgcrypto", with
+	// CREATE EXTENSION IF NOT EXISTS. With DialectCockroach, they are
+	// installed on every test database before its migrations instead.
+	Extensions []string
+	// PostMigrationSQL holds statements executed in order on the template
+	// database after migrations and before it is marked as a template,
+	// e.g. "ANALYZE" so that test databases start with fresh statistics.
+	//
+	// Each statement is executed on its own outside of a transaction,
+	// so statements like VACUUM are allowed. If any of them fails,
+	// the template database is dropped and Initialize returns the error.
+	// With DialectCockroach, they run on every test database
+	// after its migrations instead.
+	PostMigrationSQL []string
+	// TemplateVerifyQuery is a query run on the template database after
+	// PostMigrationSQL and before it is marked as a template, to confirm
+	// that the migrations left it in the expected state, e.g.
+	// "SELECT count(*) FROM schema_migrations". It must return exactly
+	// one column, which is scanned, and if that fails, e.g. because
+	// the query fails, returns no rows or returns more columns,
+	// the template database is dropped and Initialize returns the error.
+	// With DialectCockroach, it runs on every test database
+	// after PostMigrationSQL instead.
+	//
+	// If empty, the template is not verified.
+	TemplateVerifyQuery string
+	// Dialect is the SQL dialect of the database server.
+	//
+	// If zero, DialectPostgres will be used.
+	Dialect Dialect
+	// QueryDialect provides the queries terminating connections,
+	// checking whether the template exists and marking it as a template,
+	// e.g. for managed services restricting pg_terminate_backend.
+	//
+	// If nil, PostgresQueryDialect will be used.
+	QueryDialect QueryDialect
+	// AutoInitialize makes CreateTestDatabase and CreateTestDatabases
+	// call Initialize on first use instead of returning
+	// ErrTemplateNotInitialized.
+	//
+	// Initialization errors are returned from the creating call.
+	AutoInitialize bool
+	// Tracer starts spans around Initialize, CreateTestDatabase,
+	// DropTestDatabase and Cleanup.
+	//
+	// If nil, no spans are started.
+	Tracer Tracer
+	// TemplateOwner is the role that will own the template database.
+	// Test databases copied from the template are owned by the role
+	// creating them, i.e. the one connected to the admin database.
+	//
+	// The role creating the template must be a member of TemplateOwner
+	// (or a superuser), and stays able to mark and unmark the database
+	// as a template through that membership.
+	// Note that objects created by migrations are owned by the role the
+	// ConnectionProvider connects to the template with, not by the
+	// database owner.
+	//
+	// If empty, the template is owned by the role creating it.
+	TemplateOwner string
+	// TemplateEncoding is the character set encoding of the template
+	// database, e.g. "UTF8". Test databases inherit it from the template.
+	//
+	// If empty, the server default is used.
+	TemplateEncoding string
+	// TemplateLCCollate is the collation order (LC_COLLATE) of the template
+	// database, e.g. "C". Test databases inherit it from the template.
+	//
+	// It must be set together with TemplateLCCtype.
+	// If empty, the server default is used.
+	TemplateLCCollate string
+	// TemplateLCCtype is the character classification (LC_CTYPE) of the
+	// template database, e.g. "C". Test databases inherit it from the template.
+	//
+	// It must be set together with TemplateLCCollate.
+	// If empty, the server default is used.
+	TemplateLCCtype string
+	// CreateTemplateSQLFunc returns the statement creating the template
+	// database, for options not covered by the other fields, e.g.
+	// an ICU locale. The statement is executed verbatim, so names and
+	// values in it must be quoted, e.g. with QuoteIdentifier.
+	//
+	// It cannot be used together with TemplateOwner, TemplateEncoding,
+	// TemplateLCCollate and TemplateLCCtype.
+	// If nil, the statement is built from these fields.
+	CreateTemplateSQLFunc func(templateName string) string
+	// CreateTestDBSQLFunc returns the statement creating the test database
+	// dbName from templateName, which is executed verbatim like the one of
+	// CreateTemplateSQLFunc. Clauses of CreateTestDatabaseWithOptions
+	// are appended to it.
+	//
+	// With DialectCockroach, templateName is empty, since test databases
+	// are created empty instead of being copied.
+	//
+	// If nil, CREATE DATABASE dbName TEMPLATE templateName is used.
+	CreateTestDBSQLFunc func(dbName, templateName string) string
+	// Metrics receives counts and timings of test database operations.
+	//
+	// If nil, no metrics are recorded.
+	Metrics Metrics
+	// Clock is used wherever the manager needs the current time,
+	// e.g. for the timestamps embedded in generated names.
+	//
+	// If nil, the system clock is used.
+	Clock Clock
+	// OnTestDatabaseCreated is called by CreateTestDatabase after a test
+	// database has been created and connected to, e.g. to seed fixtures
+	// that cannot be part of the migrations.
+	//
+	// If it returns an error, the connection is closed, the test database
+	// is dropped and CreateTestDatabase returns the error.
+	OnTestDatabaseCreated func(ctx context.Context, conn DatabaseConnection, name string) error
+	// OnBeforeDropTestDatabase is called by DropTestDatabase before
+	// the test database is dropped.
+	//
+	// If it returns an error, the database is not dropped.
+	OnBeforeDropTestDatabase func(ctx context.Context, name string) error
 }
 
 // NewTemplateManager creates a new template manager and checks for PostgreSQL.
@@ -112,79 +483,590 @@ func NewTemplateManager(config Config) (*TemplateManager, error) {
 		return nil, fmt.Errorf("MigrationRunner is required")
 	}
 
+	if (config.TemplateLCCollate == "") != (config.TemplateLCCtype == "") {
+		return nil, fmt.Errorf("TemplateLCCollate and TemplateLCCtype must be set together")
+	}
+	if config.CreateTemplateSQLFunc != nil && (config.TemplateOwner != "" || config.TemplateEncoding != "" || config.TemplateLCCollate != "") {
+		return nil, fmt.Errorf("TemplateOwner, TemplateEncoding, TemplateLCCollate and TemplateLCCtype cannot be used together with CreateTemplateSQLFunc")
+	}
+	if config.Dialect != DialectPostgres && config.Dialect != DialectCockroach {
+		return nil, fmt.Errorf("unknown Dialect %v", config.Dialect)
+	}
+	if config.AssumeTemplateReady && config.TemplateName == "" {
+		return nil, fmt.Errorf("TemplateName is required with AssumeTemplateReady")
+	}
+	if config.AssumeTemplateReady && config.ForceRecreate {
+		return nil, fmt.Errorf("ForceRecreate cannot be used together with AssumeTemplateReady")
+	}
+	if config.ExternalTemplate && !config.AssumeTemplateReady {
+		return nil, fmt.Errorf("ExternalTemplate requires AssumeTemplateReady")
+	}
+	if config.UseRandomNames && config.TestDBNameFunc != nil {
+		return nil, fmt.Errorf("UseRandomNames cannot be used together with TestDBNameFunc")
+	}
+
+	clock := config.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
 	templateName := config.TemplateName
 	if templateName == "" {
-		templateName = fmt.Sprintf("template_db_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&globalTemplateCounter, 1))
+		templateName = fmt.Sprintf("template_db_%d_%d", clock.Now().UnixNano(), atomic.AddInt64(&globalTemplateCounter, 1))
+	}
+
+	if err := checkIdentifierLength("TemplateName", templateName); err != nil {
+		return nil, err
 	}
 
 	testPrefix := config.TestDBPrefix
 	if testPrefix == "" {
 		testPrefix = "test_"
 	}
+	if len(testPrefix) > maxIdentifierLength-maxGeneratedSuffixLength {
+		return nil, fmt.Errorf(
+			"TestDBPrefix %q is %d bytes long, but at most %d bytes are allowed, since generated names add up to %d bytes to it",
+			testPrefix, len(testPrefix), maxIdentifierLength-maxGeneratedSuffixLength, maxGeneratedSuffixLength,
+		)
+	}
+	if config.StrictTestDBPrefix && strings.HasPrefix(templateName, testPrefix) {
+		return nil, fmt.Errorf(
+			"TemplateName %q starts with TestDBPrefix %q, so the template could be dropped as a test database",
+			templateName, testPrefix,
+		)
+	}
 
 	adminDBName := config.AdminDBName
 	if adminDBName == "" {
 		adminDBName = defaultAdminDBName
 	}
+	if adminDBName == templateName || adminDBName == "template0" || adminDBName == "template1" {
+		return nil, fmt.Errorf("AdminDBName must not be %q, since a template cannot be copied while connected to", adminDBName)
+	}
+
+	batchCreateConcurrency := config.BatchCreateConcurrency
+	if batchCreateConcurrency <= 0 {
+		batchCreateConcurrency = 1
+	}
+
+	cleanupConcurrency := config.CleanupConcurrency
+	if cleanupConcurrency <= 0 {
+		cleanupConcurrency = 1
+	}
+	terminateBatchSize := config.TerminateBatchSize
+	if terminateBatchSize <= 0 {
+		terminateBatchSize = defaultTerminateBatchSize
+	}
+
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = noOpMetrics{}
+	}
+
+	queryDialect := config.QueryDialect
+	if queryDialect == nil {
+		queryDialect = PostgresQueryDialect{}
+	}
 
 	return &TemplateManager{
-		provider:     config.ConnectionProvider,
-		migrator:     config.MigrationRunner,
-		templateName: templateName,
-		testPrefix:   testPrefix,
-		adminDBName:  adminDBName,
+		provider:                 config.ConnectionProvider,
+		migrator:                 config.MigrationRunner,
+		tracer:                   config.Tracer,
+		metrics:                  metrics,
+		clock:                    clock,
+		onTestDatabaseCreated:    config.OnTestDatabaseCreated,
+		onBeforeDropTestDatabase: config.OnBeforeDropTestDatabase,
+		templateName:             templateName,
+		testPrefix:               testPrefix,
+		testDBNameFunc:           config.TestDBNameFunc,
+		randomNames:              config.UseRandomNames,
+		randRead:                 rand.Read,
+		adminDBName:              adminDBName,
+		dialect:                  config.Dialect,
+		queryDialect:             queryDialect,
+		reuseAdminConn:           config.ReuseAdminConnection,
+		batchCreateConcurrency:   batchCreateConcurrency,
+		cleanupConcurrency:       cleanupConcurrency,
+		terminateBatchSize:       terminateBatchSize,
+		skipTerminateOnDrop:      config.SkipTerminateOnDrop,
+		verifyTestDBConn:         config.VerifyTestDBConnection,
+		analyzeTestDBs:           config.AnalyzeTestDatabases,
+		autoInitialize:           config.AutoInitialize,
+		forceRecreate:            config.ForceRecreate,
+		templateWaitTimeout:      config.TemplateWaitTimeout,
+		keepOnFailure:            config.KeepOnFailure,
+		assumeReady:              config.AssumeTemplateReady,
+		external:                 config.ExternalTemplate,
+		skipMarking:              config.SkipTemplateMarking,
+		extensions:               config.Extensions,
+		postMigrationSQL:         config.PostMigrationSQL,
+		verifyQuery:              config.TemplateVerifyQuery,
+		templateOwner:            config.TemplateOwner,
+		templateEncoding:         config.TemplateEncoding,
+		templateLCCollate:        config.TemplateLCCollate,
+		templateLCCtype:          config.TemplateLCCtype,
+		createTemplateSQLFunc:    config.CreateTemplateSQLFunc,
+		createTestDBSQLFunc:      config.CreateTestDBSQLFunc,
 	}, nil
 }
 
+// TemplateName returns the name of the template database,
+// which is generated if Config.TemplateName is empty.
+func (tm *TemplateManager) TemplateName() string {
+	return tm.templateName
+}
+
+// TestDBPrefix returns the prefix of generated test database names.
+func (tm *TemplateManager) TestDBPrefix() string {
+	return tm.testPrefix
+}
+
+// AdminDBName returns the name of the administrative database.
+func (tm *TemplateManager) AdminDBName() string {
+	return tm.adminDBName
+}
+
+// IsTracked reports whether the test database was created by the manager
+// and has not been dropped yet, i.e. whether Cleanup would drop it.
+// The template database is not tracked.
+func (tm *TemplateManager) IsTracked(name string) bool {
+	_, tracked := tm.createdTestDBs.Load(name)
+	return tracked
+}
+
 // Initialize sets up the template database with all migrations.
-func (tm *TemplateManager) Initialize(ctx context.Context) error {
+// With DialectCockroach, no template database is created.
+//
+// Concurrent and later calls return the same error if creating the template
+// fails, instead of retrying on a template which may be half-created,
+// unless Config.ForceRecreate is set, the context of the failed call
+// was done, or it failed waiting for another manager creating the
+// template. Cleanup resets the error.
+//
+// After Cleanup, it reopens the manager by creating the template again.
+// After Close, ErrManagerClosed is returned.
+func (tm *TemplateManager) Initialize(ctx context.Context) (err error) {
+	ctx, span := tm.startSpan(ctx, "pgdbtemplate.Initialize")
+	defer func() { span.end(err) }()
+
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
+	if tm.providerClosed {
+		return tm.closedError()
+	}
 	if tm.initialized {
 		return nil
 	}
+	if tm.initErr != nil && !tm.forceRecreate {
+		return tm.initErr
+	}
 
-	if err := tm.createTemplateDatabase(ctx); err != nil {
-		return fmt.Errorf("failed to create template database: %w", err)
+	switch {
+	case tm.external:
+		// The template is owned by a separate tool, so it is not checked.
+	case tm.dialect == DialectCockroach:
+		// Test databases are migrated one by one instead of being copied,
+		// so there is no template to create.
+	case tm.assumeReady:
+		if err := tm.verifyTemplateDatabase(ctx); err != nil {
+			return fmt.Errorf("failed to verify template database: %w", err)
+		}
+	default:
+		if err := tm.createTemplateDatabase(ctx); err != nil {
+			err = fmt.Errorf("failed to create template database: %w", err)
+			if ctx.Err() == nil && !isRetryableTemplateError(err) {
+				tm.initErr = err
+			}
+			return err
+		}
 	}
 
+	tm.initErr = nil
 	tm.initialized = true
+	tm.closed = false
 	return nil
 }
 
+// SeedTemplate creates, migrates and marks the template database
+// configured by config, and leaves it in place, e.g. in a CI setup step
+// before the test binaries use it with Config.AssumeTemplateReady.
+//
+// It does nothing if the template already exists, so it can be called
+// repeatedly. config.TemplateName is required.
+func SeedTemplate(ctx context.Context, config Config) (err error) {
+	if config.TemplateName == "" {
+		return fmt.Errorf("TemplateName is required to seed the template")
+	}
+	if config.AssumeTemplateReady {
+		return fmt.Errorf("AssumeTemplateReady cannot be used to seed the template")
+	}
+
+	tm, err := NewTemplateManager(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := tm.closeAdminConnection(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close admin connection: %w", closeErr))
+		}
+	}()
+	return tm.Initialize(ctx)
+}
+
 // CreateTestDatabase creates a new test database from the template.
 //
-// The caller is expected to call Initialize() before using this method.
-func (tm *TemplateManager) CreateTestDatabase(ctx context.Context, testDBName ...string) (_ DatabaseConnection, _ string, err error) {
+// If testDBName is the name of a test database created by the manager
+// and not dropped yet, ErrDatabaseAlreadyExists is returned.
+//
+// Initialize must be called before using this method,
+// otherwise ErrTemplateNotInitialized is returned,
+// unless Config.AutoInitialize is set.
+func (tm *TemplateManager) CreateTestDatabase(ctx context.Context, testDBName ...string) (DatabaseConnection, string, error) {
 	var dbName string
-	if len(testDBName) > 0 && testDBName[0] != "" {
+	if len(testDBName) > 0 {
 		dbName = testDBName[0]
-	} else {
-		dbName = fmt.Sprintf("%s%d_%d", tm.testPrefix, time.Now().UnixNano(), atomic.AddInt64(&globalTestDBCounter, 1))
+	}
+	return tm.createTestDatabase(ctx, "pgdbtemplate.CreateTestDatabase", tm.templateName, createOptions{name: dbName})
+}
+
+// CreateTestDatabaseWithOptions creates a new test database from
+// the template like CreateTestDatabase, configured by the options,
+// e.g. WithConnectionLimit or WithTablespace.
+//
+// Initialize must be called before using this method,
+// otherwise ErrTemplateNotInitialized is returned,
+// unless Config.AutoInitialize is set.
+func (tm *TemplateManager) CreateTestDatabaseWithOptions(ctx context.Context, opts ...CreateOption) (DatabaseConnection, string, error) {
+	options, err := newCreateOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return tm.createTestDatabase(ctx, "pgdbtemplate.CreateTestDatabase", tm.templateName, options)
+}
+
+// CreateTestDatabaseFromTemplate creates a new test database copied from
+// sourceTemplate instead of the managed template, e.g. "template0" for
+// a completely clean database without any schema.
+//
+// If testDBName is empty, a unique name is generated. The created database
+// is tracked and dropped by Cleanup like any other test database.
+// Initialize does not need to be called before using this method.
+func (tm *TemplateManager) CreateTestDatabaseFromTemplate(ctx context.Context, sourceTemplate, testDBName string) (DatabaseConnection, string, error) {
+	return tm.createTestDatabase(ctx, "pgdbtemplate.CreateTestDatabaseFromTemplate", sourceTemplate, createOptions{name: testDBName})
+}
+
+// createTestDatabase creates a new test database copied from sourceTemplate.
+//
+// The operation is traced under the given name.
+func (tm *TemplateManager) createTestDatabase(ctx context.Context, operation, sourceTemplate string, opts createOptions) (_ DatabaseConnection, _ string, err error) {
+	ctx, span := tm.startSpan(ctx, operation)
+	defer func() { span.end(err) }()
+
+	if sourceTemplate == tm.templateName {
+		if err := tm.ensureInitialized(ctx); err != nil {
+			return nil, "", err
+		}
+	} else if err := tm.checkNotClosed(); err != nil {
+		return nil, "", err
+	}
+
+	dbName := opts.name
+	if dbName == "" {
+		if dbName, err = tm.generateTestDBName(); err != nil {
+			return nil, "", err
+		}
+	} else if _, tracked := tm.createdTestDBs.Load(dbName); tracked {
+		// Fail fast without a round trip to the server.
+		return nil, "", fmt.Errorf("test database %q was already created and not dropped: %w", dbName, ErrDatabaseAlreadyExists)
+	}
+	span.setAttributes(Attribute{Key: AttributeDatabaseName, Value: dbName})
+	if err := checkIdentifierLength("test database name", dbName); err != nil {
+		return nil, "", err
 	}
 
 	// Connect to admin database for CREATE DATABASE operations.
 	// We cannot use the template database connection because PostgreSQL
 	// doesn't allow creating databases from a template that has active connections.
-	adminConn, err := tm.provider.Connect(ctx, tm.adminDBName)
+	adminDBName := opts.adminDBName
+	if adminDBName == "" {
+		adminDBName = tm.adminDBName
+	}
+	switch adminDBName {
+	case sourceTemplate, tm.templateName, "template0", "template1":
+		return nil, "", fmt.Errorf("admin database must not be %q, since a template cannot be copied while connected to", adminDBName)
+	}
+	adminConn, releaseAdminConn, err := tm.adminConnectionTo(ctx, adminDBName)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to connect to admin database: %w", err)
 	}
-	defer adminConn.Close()
+	defer releaseAdminConn()
+
+	testConn, err := tm.createTestDatabaseWith(ctx, adminConn, sourceTemplate, dbName, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return testConn, dbName, nil
+}
+
+// CreateTestDatabases creates n test databases from the template
+// with generated names.
+//
+// Up to Config.BatchCreateConcurrency databases are created concurrently,
+// each concurrent creation beyond the first one using its own admin
+// connection.
+// If creating any of the databases fails, all databases created by this
+// call are dropped and their connections closed.
+//
+// Initialize must be called before using this method,
+// otherwise ErrTemplateNotInitialized is returned,
+// unless Config.AutoInitialize is set.
+func (tm *TemplateManager) CreateTestDatabases(ctx context.Context, n int) (_ []DatabaseConnection, _ []string, err error) {
+	ctx, span := tm.startSpan(ctx, "pgdbtemplate.CreateTestDatabases")
+	defer func() { span.end(err) }()
+
+	if n <= 0 {
+		return nil, nil, nil
+	}
+	if err := tm.ensureInitialized(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	adminConn, releaseAdminConn, err := tm.adminConnection(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer releaseAdminConn()
+
+	dbNames := make([]string, n)
+	seen := make(map[string]bool, n)
+	for i := range dbNames {
+		if dbNames[i], err = tm.generateTestDBName(); err != nil {
+			return nil, nil, err
+		}
+		if seen[dbNames[i]] {
+			return nil, nil, fmt.Errorf("TestDBNameFunc returned %q more than once: %w", dbNames[i], ErrDatabaseAlreadyExists)
+		}
+		seen[dbNames[i]] = true
+		if err := checkIdentifierLength("test database name", dbNames[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// A connection cannot execute statements concurrently, so the
+	// databases are created by up to batchCreateConcurrency workers,
+	// each using its own admin connection.
+	conns := make([]DatabaseConnection, n)
+	createErrs := make([]error, n)
+	indexes := make(chan int)
+	workers := tm.batchCreateConcurrency
+	if workers > n {
+		workers = n
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			// The first worker uses the given admin connection, so that
+			// the databases are created even if no more connections
+			// can be opened. Workers failing to connect leave their
+			// share of the databases to the others.
+			conn := adminConn
+			if w > 0 {
+				workerConn, err := tm.provider.Connect(ctx, tm.adminDBName)
+				if err != nil {
+					return
+				}
+				defer workerConn.Close()
+				conn = workerConn
+			}
+
+			for i := range indexes {
+				conns[i], createErrs[i] = tm.createTestDatabaseWith(ctx, conn, tm.templateName, dbNames[i], createOptions{})
+			}
+		}(w)
+	}
+	for i := range dbNames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	errs := errors.Join(createErrs...)
+	if errs == nil {
+		return conns, dbNames, nil
+	}
+
+	// Roll back the databases which were created successfully.
+	for i, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		if err := conn.Close(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to close test database %q: %w", dbNames[i], err))
+		}
+		dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(dbNames[i]))
+		if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to drop test database %q: %w", dbNames[i], err))
+			continue
+		}
+		tm.createdTestDBs.Delete(dbNames[i])
+		tm.metrics.IncDropped()
+	}
+	return nil, nil, errs
+}
+
+// checkInitialized returns an error wrapping ErrTemplateNotInitialized
+// if the template database has not been initialized.
+//
+// It only holds mu for the duration of the check, so it must not be
+// called from Initialize or Cleanup, which hold mu themselves.
+func (tm *TemplateManager) checkInitialized() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if err := tm.closedError(); err != nil {
+		return err
+	}
+	if !tm.initialized {
+		return fmt.Errorf("%w: call Initialize first", ErrTemplateNotInitialized)
+	}
+	return nil
+}
+
+// checkNotClosed returns ErrManagerClosed if Cleanup or Close
+// has been called, regardless of the template.
+func (tm *TemplateManager) checkNotClosed() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.closedError()
+}
+
+// closedError returns ErrManagerClosed with a hint on how to recover,
+// or nil if the manager is not closed. The caller must hold mu.
+func (tm *TemplateManager) closedError() error {
+	if tm.providerClosed {
+		return fmt.Errorf("%w: it cannot be used after Close", ErrManagerClosed)
+	}
+	if tm.closed {
+		return fmt.Errorf("%w: call Initialize to reopen it", ErrManagerClosed)
+	}
+	return nil
+}
+
+// ensureInitialized is like checkInitialized, but initializes
+// the template instead if Config.AutoInitialize is set.
+func (tm *TemplateManager) ensureInitialized(ctx context.Context) error {
+	err := tm.checkInitialized()
+	if err == nil || !tm.autoInitialize || errors.Is(err, ErrManagerClosed) {
+		return err
+	}
+	// Initialize checks again under mu, so only one caller creates the template.
+	if err := tm.Initialize(ctx); err != nil {
+		return fmt.Errorf("failed to initialize template: %w", err)
+	}
+	return nil
+}
+
+// checkIdentifierLength returns an error if name would be truncated
+// by PostgreSQL, which could make distinct names collide.
+func checkIdentifierLength(kind, name string) error {
+	if len(name) > maxIdentifierLength {
+		return fmt.Errorf("%s %q is %d bytes long, but PostgreSQL only allows up to %d bytes",
+			kind, name, len(name), maxIdentifierLength)
+	}
+	return nil
+}
+
+// generateTestDBName generates a unique test database name,
+// using Config.TestDBNameFunc if it is set.
+func (tm *TemplateManager) generateTestDBName() (string, error) {
+	if tm.randomNames {
+		for attempt := 0; attempt < maxRandomNameAttempts; attempt++ {
+			var suffix [4]byte
+			if _, err := tm.randRead(suffix[:]); err != nil {
+				return "", fmt.Errorf("failed to generate random test database name: %w", err)
+			}
+			name := tm.testPrefix + hex.EncodeToString(suffix[:])
+			if _, tracked := tm.createdTestDBs.Load(name); !tracked {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("failed to generate random test database name after %d attempts: %w",
+			maxRandomNameAttempts, ErrDatabaseAlreadyExists)
+	}
+	if tm.testDBNameFunc == nil {
+		return fmt.Sprintf("%s%d_%d", tm.testPrefix, tm.clock.Now().UnixNano(), atomic.AddInt64(&globalTestDBCounter, 1)), nil
+	}
+
+	name := tm.testDBNameFunc()
+	if name == "" {
+		return "", fmt.Errorf("TestDBNameFunc returned an empty name")
+	}
+	if _, tracked := tm.createdTestDBs.Load(name); tracked {
+		return "", fmt.Errorf("TestDBNameFunc returned %q, which is already in use: %w", name, ErrDatabaseAlreadyExists)
+	}
+	return name, nil
+}
 
+// createTestDatabaseWith creates the test database dbName copied from
+// sourceTemplate using the given admin connection, connects to it
+// and tracks it for cleanup.
+//
+// The name in opts is ignored in favour of dbName.
+// If any step after creating the database fails, the database is dropped.
+func (tm *TemplateManager) createTestDatabaseWith(ctx context.Context, adminConn DatabaseConnection, sourceTemplate, dbName string, opts createOptions) (_ DatabaseConnection, err error) {
 	// Create test database from template.
 	query := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
-		formatters.QuoteIdentifier(dbName), formatters.QuoteIdentifier(tm.templateName))
+		formatters.QuoteIdentifier(dbName), formatters.QuoteIdentifier(sourceTemplate))
+	copiedTemplate := sourceTemplate
+	if tm.dialect == DialectCockroach {
+		// CockroachDB cannot copy databases, so the test database
+		// is created empty and migrated below.
+		if sourceTemplate != tm.templateName && sourceTemplate != "template0" && sourceTemplate != "template1" {
+			return nil, fmt.Errorf("failed to create test database %q: copying from template %q is not supported by %v",
+				dbName, sourceTemplate, tm.dialect)
+		}
+		query = "CREATE DATABASE " + formatters.QuoteIdentifier(dbName)
+		copiedTemplate = ""
+	}
+	if tm.createTestDBSQLFunc != nil {
+		query = tm.createTestDBSQLFunc(dbName, copiedTemplate)
+	}
+	query += opts.clauses()
+	if closer, ok := tm.provider.(DatabaseCloser); ok && tm.dialect != DialectCockroach {
+		if err := closer.CloseDatabase(sourceTemplate); err != nil {
+			return nil, fmt.Errorf("failed to close connections to template %q: %w", sourceTemplate, err)
+		}
+	}
+	defer func() {
+		if err != nil {
+			tm.metrics.IncCreateError()
+		}
+	}()
+	createStart := tm.clock.Now()
 	if _, err := adminConn.ExecContext(ctx, query); err != nil {
-		return nil, "", fmt.Errorf("failed to create test database %q: %w", dbName, err)
+		// A missing managed template means Initialize was not called.
+		if sourceTemplate == tm.templateName && SQLState(err) == sqlStateInvalidCatalogName {
+			err = withSentinel(err, ErrTemplateNotInitialized)
+		} else {
+			err = classifyError(err)
+		}
+		return nil, fmt.Errorf("failed to create test database %q: %w", dbName, err)
 	}
+	tm.metrics.RecordCreateDuration(tm.clock.Now().Sub(createStart))
 
 	// Drop the test database if any further steps fail.
 	defer func() {
 		if err == nil {
 			return
 		}
+		if tm.keepOnFailure {
+			err = fmt.Errorf("%w; test database %q was kept for inspection", err, dbName)
+			return
+		}
 		dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(dbName))
 		_, dropErr := adminConn.ExecContext(ctx, dropQuery)
 
@@ -204,109 +1086,541 @@ func (tm *TemplateManager) CreateTestDatabase(ctx context.Context, testDBName ..
 	// Connect to the new test database.
 	testConn, err := tm.provider.Connect(ctx, dbName)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to connect to test database: %w", err)
+		return nil, fmt.Errorf("failed to connect to test database: %w", err)
+	}
+
+	if tm.verifyTestDBConn {
+		if err := verifyCurrentDatabase(ctx, testConn, dbName); err != nil {
+			return nil, errors.Join(err, testConn.Close())
+		}
+	}
+
+	if tm.dialect == DialectCockroach && sourceTemplate == tm.templateName {
+		if err := tm.createExtensions(ctx, testConn); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to create extensions on test database %q: %w", dbName, err),
+				testConn.Close(),
+			)
+		}
+		if err := tm.migrator.RunMigrations(ctx, testConn); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to run migrations on test database %q: %w", dbName, err),
+				testConn.Close(),
+			)
+		}
+		if err := tm.runPostMigrationSQL(ctx, testConn); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to run post-migration SQL on test database %q: %w", dbName, err),
+				testConn.Close(),
+			)
+		}
+		if err := tm.runVerifyQuery(ctx, testConn); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to verify test database %q: %w", dbName, err),
+				testConn.Close(),
+			)
+		}
+	}
+
+	if opts.extraMigrations != nil {
+		if err := opts.extraMigrations.RunMigrations(ctx, testConn); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to run extra migrations on test database %q: %w", dbName, err),
+				testConn.Close(),
+			)
+		}
+	}
+
+	if tm.analyzeTestDBs {
+		if _, err := testConn.ExecContext(ctx, "ANALYZE"); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to analyze test database %q: %w", dbName, err),
+				testConn.Close(),
+			)
+		}
+	}
+
+	// Run the user-provided hook before handing out the connection.
+	if tm.onTestDatabaseCreated != nil {
+		if hookErr := tm.onTestDatabaseCreated(ctx, testConn, dbName); hookErr != nil {
+			return nil, errors.Join(
+				fmt.Errorf("OnTestDatabaseCreated failed for test database %q: %w", dbName, hookErr),
+				testConn.Close(),
+			)
+		}
 	}
 
 	// Track the created test database for cleanup.
-	tm.createdTestDBs.Store(dbName, true)
+	tm.createdTestDBs.Store(dbName, trackedTestDatabase{sourceTemplate: sourceTemplate, opts: opts})
+	tm.metrics.IncCreated()
 
-	return testConn, dbName, nil
+	return testConn, nil
+}
+
+// verifyCurrentDatabase checks that conn is connected to dbName.
+func verifyCurrentDatabase(ctx context.Context, conn DatabaseConnection, dbName string) error {
+	var currentDB string
+	if err := conn.QueryRowContext(ctx, "SELECT current_database()").Scan(&currentDB); err != nil {
+		return fmt.Errorf("failed to verify connection to test database %q: %w", dbName, err)
+	}
+	if currentDB != dbName {
+		return fmt.Errorf("connection to test database %q is connected to database %q: "+
+			"check that the connection provider connects to the requested database", dbName, currentDB)
+	}
+	return nil
 }
 
-// DropTestDatabase drops a test database.
+// DropTestDatabase drops a test database, configured by the options,
+// e.g. IfExists.
 //
-// The caller is expected to call Initialize() before using this method.
-func (tm *TemplateManager) DropTestDatabase(ctx context.Context, dbName string) error {
+// Initialize must be called before using this method,
+// otherwise ErrTemplateNotInitialized is returned. Databases created
+// by CreateTestDatabaseFromTemplate can be dropped regardless.
+func (tm *TemplateManager) DropTestDatabase(ctx context.Context, dbName string, opts ...DropOption) error {
+	_, err := tm.DropTestDatabaseWithCount(ctx, dbName, opts...)
+	return err
+}
+
+// DropTestDatabaseWithCount drops a test database like DropTestDatabase
+// and returns the number of connections to it which had to be terminated.
+//
+// Connections still open when a test database is dropped often mean
+// that the test leaks them, so a non-zero count is worth investigating.
+func (tm *TemplateManager) DropTestDatabaseWithCount(ctx context.Context, dbName string, opts ...DropOption) (terminated int, err error) {
+	ctx, span := tm.startSpan(ctx, "pgdbtemplate.DropTestDatabase",
+		Attribute{Key: AttributeDatabaseName, Value: dbName})
+	defer func() { span.end(err) }()
+
+	// Databases created by CreateTestDatabaseFromTemplate
+	// can be dropped without initializing the template.
+	if _, tracked := tm.createdTestDBs.Load(dbName); !tracked {
+		if err := tm.checkInitialized(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Run the user-provided hook before anything is dropped.
+	if err := tm.beforeDropTestDatabase(ctx, dbName); err != nil {
+		return 0, err
+	}
+
 	// Connect to admin database for DROP operations.
 	// Even though we could connect to the template database,
 	// we cannot do this as the user can call CreateTestDatabase
 	// at the same time and creating test databases from template
 	// requires no active connections to the template.
-	adminConn, err := tm.provider.Connect(ctx, tm.adminDBName)
+	options := newDropOptions(opts)
+	adminConn, releaseAdminConn, err := tm.adminConnectionTo(ctx, options.adminDBName)
 	if err != nil {
-		return fmt.Errorf("failed to connect to admin database: %w", err)
+		return 0, fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer releaseAdminConn()
+
+	return tm.dropTestDatabase(ctx, adminConn, dbName, options.ifExists)
+}
+
+// beforeDropTestDatabase runs the OnBeforeDropTestDatabase hook, if any.
+func (tm *TemplateManager) beforeDropTestDatabase(ctx context.Context, dbName string) error {
+	if tm.onBeforeDropTestDatabase == nil {
+		return nil
 	}
-	defer adminConn.Close()
+	if err := tm.onBeforeDropTestDatabase(ctx, dbName); err != nil {
+		return fmt.Errorf("OnBeforeDropTestDatabase failed for test database %q: %w", dbName, err)
+	}
+	return nil
+}
 
-	// Terminate active connections to the database.
-	if err := tm.batchTerminateConnections(ctx, adminConn, []string{dbName}); err != nil {
-		return fmt.Errorf("failed to terminate connections to database %q: %w", dbName, err)
+// dropTestDatabase drops the test database using the given admin
+// connection, terminating the connections to it first unless
+// SkipTerminateOnDrop is set, and removes it from tracking. It returns
+// the number of terminated connections.
+func (tm *TemplateManager) dropTestDatabase(ctx context.Context, adminConn DatabaseConnection, dbName string, ifExists bool) (terminated int, err error) {
+	dropQuery := "DROP DATABASE " + formatters.QuoteIdentifier(dbName)
+	if ifExists {
+		dropQuery = "DROP DATABASE IF EXISTS " + formatters.QuoteIdentifier(dbName)
 	}
 
-	// Drop the database.
-	dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(dbName))
-	if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
-		return fmt.Errorf("failed to drop database %q: %w", dbName, err)
+	// Try dropping the database right away, if no connections are expected.
+	dropped := false
+	if tm.skipTerminateOnDrop {
+		_, err := adminConn.ExecContext(ctx, dropQuery)
+		if err != nil && SQLState(err) != sqlStateObjectInUse {
+			return 0, fmt.Errorf("failed to drop database %q: %w", dbName, classifyError(err))
+		}
+		dropped = err == nil
+	}
+
+	if !dropped {
+		// Terminate active connections to the database.
+		terminated, err = tm.terminateConnections(ctx, adminConn, []string{dbName})
+		if err != nil {
+			return 0, fmt.Errorf("failed to terminate connections to database %q: %w", dbName, err)
+		}
+
+		// Drop the database.
+		if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
+			return terminated, fmt.Errorf("failed to drop database %q: %w", dbName, classifyError(err))
+		}
 	}
 
 	// Remove from tracking map if it was tracked.
 	tm.createdTestDBs.Delete(dbName)
+	tm.metrics.IncDropped()
 
-	return nil
+	return terminated, nil
 }
 
-// Cleanup removes all tracked test databases and the template database.
+// ResetTestDatabase restores the test database dbName to the state of
+// the template by dropping it and creating it again from the template
+// under the same name, and returns a new connection to it.
+//
+// Only test databases created by the manager and not dropped yet can be
+// reset. They are dropped like with DropTestDatabase, and created again
+// from the same template with the same options, e.g. WithExtraMigrations.
+//
+// Active connections to the database are terminated, but the caller
+// is expected to close their connections to it beforehand.
+// Initialize must be called before using this method,
+// otherwise ErrTemplateNotInitialized is returned.
+func (tm *TemplateManager) ResetTestDatabase(ctx context.Context, dbName string) (_ DatabaseConnection, err error) {
+	ctx, span := tm.startSpan(ctx, "pgdbtemplate.ResetTestDatabase",
+		Attribute{Key: AttributeDatabaseName, Value: dbName})
+	defer func() { span.end(err) }()
+
+	if err := tm.checkInitialized(); err != nil {
+		return nil, err
+	}
+	value, tracked := tm.createdTestDBs.Load(dbName)
+	if !tracked {
+		return nil, fmt.Errorf("test database %q cannot be reset, since it was not created by the manager or was dropped: %w",
+			dbName, ErrDatabaseDoesNotExist)
+	}
+	created := value.(trackedTestDatabase)
+
+	if err := tm.beforeDropTestDatabase(ctx, dbName); err != nil {
+		return nil, err
+	}
+
+	adminConn, releaseAdminConn, err := tm.adminConnectionTo(ctx, created.opts.adminDBName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer releaseAdminConn()
+
+	if _, err := tm.dropTestDatabase(ctx, adminConn, dbName, false); err != nil {
+		return nil, err
+	}
+
+	// Create the database again, which tracks it on success.
+	testConn, err := tm.createTestDatabaseWith(ctx, adminConn, created.sourceTemplate, dbName, created.opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recreate database %q: %w", dbName, err)
+	}
+	return testConn, nil
+}
+
+// DropTemplateDatabase drops the template database only, keeping the
+// tracked test databases, e.g. to inspect them while forcing a rebuild
+// of the template. The next call to Initialize creates it again, and
+// Cleanup still drops the test databases.
 //
-// The caller is expected to call Initialize() before using this method.
-func (tm *TemplateManager) Cleanup(ctx context.Context) (errs error) {
+// It does nothing if the template was not created by this manager,
+// and returns an error with Config.AssumeTemplateReady, since the
+// template is shared with other processes then.
+func (tm *TemplateManager) DropTemplateDatabase(ctx context.Context) (err error) {
+	ctx, span := tm.startSpan(ctx, "pgdbtemplate.DropTemplateDatabase")
+	defer func() { span.end(err) }()
+
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
+	if err := tm.closedError(); err != nil {
+		return err
+	}
+	if tm.assumeReady {
+		return fmt.Errorf("the template database cannot be dropped with AssumeTemplateReady")
+	}
 	if !tm.initialized {
 		return nil
 	}
+	if tm.dialect == DialectCockroach {
+		// There is no template database to drop.
+		tm.initialized = false
+		return nil
+	}
 
-	// Connect to leader database.
-	adminConn, err := tm.provider.Connect(ctx, tm.adminDBName)
+	adminConn, releaseAdminConn, err := tm.adminConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to admin database: %w", err)
 	}
-	defer adminConn.Close()
+	defer releaseAdminConn()
+
+	if err := tm.cleanupTemplateDatabase(ctx, adminConn); err != nil {
+		return fmt.Errorf("failed to drop template database: %w", err)
+	}
+	tm.initialized = false
+	return nil
+}
+
+// Cleanup removes all tracked test databases and the template database.
+//
+// Test databases created by CreateTestDatabaseFromTemplate are dropped
+// even if Initialize was never called.
+//
+// Databases which failed to be dropped stay tracked. If ctx is done
+// before everything is dropped, the template is kept as well,
+// so Cleanup can be called again with a fresh context to finish.
+//
+// Once Cleanup succeeds, the manager is closed: further calls to Cleanup
+// do nothing, and other methods return ErrManagerClosed until
+// Initialize is called again.
+func (tm *TemplateManager) Cleanup(ctx context.Context) error {
+	_, err := tm.CleanupWithReport(ctx)
+	return err
+}
+
+// CleanupReport summarizes what CleanupWithReport dropped.
+type CleanupReport struct {
+	// DroppedTestDatabases is the number of test databases dropped.
+	DroppedTestDatabases int
+	// Failures holds the test databases which failed to be dropped.
+	Failures []CleanupFailure
+	// TemplateDropped reports whether the template database was dropped.
+	TemplateDropped bool
+}
+
+// CleanupFailure is a test database which failed to be dropped.
+type CleanupFailure struct {
+	// Name is the name of the test database.
+	Name string
+	// Err is the error of dropping it.
+	Err error
+}
+
+// CleanupWithReport is like Cleanup, but also returns a summary
+// of what was dropped, e.g. to log it in CI.
+func (tm *TemplateManager) CleanupWithReport(ctx context.Context) (report CleanupReport, errs error) {
+	ctx, span := tm.startSpan(ctx, "pgdbtemplate.Cleanup")
+	defer func() { span.end(errs) }()
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	// Close the reused admin connection once everything is cleaned up.
+	defer func() {
+		if err := tm.closeAdminConnection(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to close admin connection: %w", err))
+		}
+	}()
+
+	// A failed initialization can be retried after Cleanup.
+	tm.initErr = nil
+
+	if !tm.initialized && !tm.hasTrackedTestDatabases() {
+		tm.closed = true
+		return report, nil
+	}
+
+	// Connect to leader database.
+	adminConn, releaseAdminConn, err := tm.adminConnection(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer releaseAdminConn()
 
 	// First, clean up all tracked test databases.
 	// Any errors are collected and returned after attempting to drop the template.
-	if err := tm.cleanupTrackedTestDatabases(ctx, adminConn); err != nil {
+	if err := tm.cleanupTrackedTestDatabases(ctx, adminConn, &report); err != nil {
 		errs = fmt.Errorf("failed to clean up tracked test databases: %w", err)
 	}
+	if ctx.Err() != nil && tm.hasTrackedTestDatabases() {
+		errs = errors.Join(errs, fmt.Errorf(
+			"cleanup interrupted, call Cleanup again to drop test databases %q: %w",
+			tm.trackedTestDatabases(), ctx.Err(),
+		))
+	}
 
-	// Drop template database.
+	// Drop template database if it was initialized,
+	// unless it was created by someone else.
 	// Any errors are appended to errs.
-	if err := tm.cleanupTemplateDatabase(ctx, adminConn); err != nil {
-		errs = errors.Join(errs, fmt.Errorf("failed to drop template database: %w", err))
+	if !tm.initialized {
+		tm.closed = errs == nil
+		return report, errs
+	}
+	if !tm.assumeReady && tm.dialect != DialectCockroach {
+		if err := tm.cleanupTemplateDatabase(ctx, adminConn); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to drop template database: %w", err))
+			if ctx.Err() != nil {
+				// Stay initialized, so that the next Cleanup drops the template.
+				return report, errs
+			}
+		} else {
+			report.TemplateDropped = true
+		}
 	}
 
 	tm.initialized = false
+	tm.closed = errs == nil
+	return report, errs
+}
+
+// Close calls Cleanup and then closes the ConnectionProvider if it has
+// a Close method, such as the one of the pgdbtemplate-pgx provider,
+// releasing its connection pools.
+//
+// The provider is closed even if Cleanup fails, and all errors are returned.
+// Afterwards, all methods return ErrManagerClosed, except for Cleanup
+// and Close, which do nothing. Concurrent calls to Close wait for
+// the first one to finish.
+func (tm *TemplateManager) Close() (errs error) {
+	tm.closeOnce.Do(func() {
+		errs = tm.Cleanup(context.Background())
+
+		switch provider := tm.provider.(type) {
+		case interface{ Close() error }:
+			if err := provider.Close(); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("failed to close connection provider: %w", err))
+			}
+		case interface{ Close() }:
+			provider.Close()
+		}
+
+		tm.mu.Lock()
+		tm.providerClosed = true
+		tm.mu.Unlock()
+	})
 	return errs
 }
 
+// adminConnection returns a connection to the admin database
+// together with a function releasing it.
+//
+// If the admin connection is reused, it is opened on first use, and
+// other callers wait for it until it is released. Otherwise, a new
+// connection is opened and releasing it closes the connection.
+func (tm *TemplateManager) adminConnection(ctx context.Context) (DatabaseConnection, func(), error) {
+	if !tm.reuseAdminConn {
+		conn, err := tm.provider.Connect(ctx, tm.adminDBName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { conn.Close() }, nil
+	}
+
+	// A connection cannot execute statements concurrently,
+	// so the reused one is locked until it is released.
+	tm.adminMu.Lock()
+	if tm.adminConn == nil {
+		conn, err := tm.provider.Connect(ctx, tm.adminDBName)
+		if err != nil {
+			tm.adminMu.Unlock()
+			return nil, nil, err
+		}
+		tm.adminConn = conn
+	}
+	return tm.adminConn, tm.adminMu.Unlock, nil
+}
+
+// adminConnectionTo returns a connection to the database overriding
+// the admin database for a single operation, together with a function
+// closing it. If dbName is empty or the admin database, it is the same
+// as adminConnection.
+func (tm *TemplateManager) adminConnectionTo(ctx context.Context, dbName string) (DatabaseConnection, func(), error) {
+	if dbName == "" || dbName == tm.adminDBName {
+		return tm.adminConnection(ctx)
+	}
+	conn, err := tm.provider.Connect(ctx, dbName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, func() { conn.Close() }, nil
+}
+
+// closeAdminConnection closes the reused admin connection, if it is open.
+func (tm *TemplateManager) closeAdminConnection() error {
+	tm.adminMu.Lock()
+	defer tm.adminMu.Unlock()
+
+	if tm.adminConn == nil {
+		return nil
+	}
+	err := tm.adminConn.Close()
+	tm.adminConn = nil
+	return err
+}
+
 // createTemplateDatabase creates and initializes the template database.
 func (tm *TemplateManager) createTemplateDatabase(ctx context.Context) (err error) {
 	// Connect to leader database.
-	adminConn, err := tm.provider.Connect(ctx, tm.adminDBName)
+	adminConn, releaseAdminConn, err := tm.adminConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to admin database: %w", err)
 	}
-	defer adminConn.Close()
+	defer releaseAdminConn()
 
-	// Check if template already exists.
-	checkQuery := fmt.Sprintf(
-		"SELECT TRUE FROM pg_database WHERE datname = %s LIMIT 1",
-		formatters.QuoteLiteral(tm.templateName),
-	)
-	var exists bool
-	err = adminConn.QueryRowContext(ctx, checkQuery).Scan(&exists)
-	if err == nil {
+	// Check if template already exists and is marked as a template.
+	exists, isTemplate, err := tm.templateExists(ctx, adminConn)
+	if err != nil {
+		return err
+	}
+	if exists && (isTemplate || !tm.marksTemplate()) {
 		// Template already exists, return early.
 		return nil
 	}
-	if !errors.Is(err, tm.provider.GetNoRowsSentinel()) {
-		// Unexpected error.
-		return fmt.Errorf("failed to check if template exists: %w", err)
+
+	if tm.marksTemplate() {
+		// Wait for another manager creating the template, if any,
+		// and check again once it is done.
+		unlock, err := tm.lockTemplateCreation(ctx, adminConn)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if unlockErr := unlock(); unlockErr != nil {
+				err = errors.Join(err, unlockErr)
+			}
+		}()
+
+		exists, isTemplate, err = tm.templateExists(ctx, adminConn)
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case !exists:
+	case isTemplate:
+		// Another manager created the template while we waited.
+		return nil
+	case !tm.forceRecreate:
+		// No other manager is creating the database, so it is either
+		// a template left over by an interrupted Initialize,
+		// or a regular database which happens to have the same name.
+		return fmt.Errorf(
+			"database %q already exists, but is not a template: "+
+				"use a different TemplateName, or set ForceRecreate to drop and recreate it",
+			tm.templateName,
+		)
+	default:
+		// Rebuild the template, as migrations may not have completed either.
+		if _, err := tm.terminateConnections(ctx, adminConn, []string{tm.templateName}); err != nil {
+			return fmt.Errorf("failed to terminate connections to the unmarked template database: %w", err)
+		}
+		dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(tm.templateName))
+		if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
+			return fmt.Errorf("failed to drop the unmarked template database: %w", err)
+		}
+	}
+
+	if err := tm.checkTemplate0(ctx, adminConn); err != nil {
+		return err
 	}
 
 	// Create template database as it does not exist.
-	createQuery := fmt.Sprintf("CREATE DATABASE %s", formatters.QuoteIdentifier(tm.templateName))
-	if _, err := adminConn.ExecContext(ctx, createQuery); err != nil {
+	if _, err := adminConn.ExecContext(ctx, tm.createTemplateQuery()); err != nil {
+		if SQLState(err) == sqlStateDuplicateDatabase {
+			// Another manager created the template in the meantime.
+			return tm.waitForTemplateDatabase(ctx, adminConn)
+		}
 		return fmt.Errorf("failed to create template database: %w", err)
 	}
 
@@ -315,6 +1629,10 @@ func (tm *TemplateManager) createTemplateDatabase(ctx context.Context) (err erro
 		if err == nil {
 			return
 		}
+		if tm.keepOnFailure {
+			err = fmt.Errorf("%w; template database %q was kept for inspection", err, tm.templateName)
+			return
+		}
 
 		dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(tm.templateName))
 		_, dropErr := adminConn.ExecContext(ctx, dropQuery)
@@ -333,50 +1651,285 @@ func (tm *TemplateManager) createTemplateDatabase(ctx context.Context) (err erro
 	}
 	defer templateConn.Close()
 
+	if err := tm.createExtensions(ctx, templateConn); err != nil {
+		return fmt.Errorf("failed to create extensions on template: %w", err)
+	}
+
 	// Run migrations.
 	if err := tm.migrator.RunMigrations(ctx, templateConn); err != nil {
 		return fmt.Errorf("failed to run migrations on template: %w", err)
 	}
+	if err := tm.runPostMigrationSQL(ctx, templateConn); err != nil {
+		return fmt.Errorf("failed to run post-migration SQL on template: %w", err)
+	}
+	if err := tm.runVerifyQuery(ctx, templateConn); err != nil {
+		return fmt.Errorf("failed to verify template: %w", err)
+	}
+
+	return tm.markTemplateDatabase(ctx, adminConn)
+}
+
+// isRetryableTemplateError reports whether creating the template failed
+// waiting for another manager, which a later Initialize can retry.
+func isRetryableTemplateError(err error) bool {
+	return errors.Is(err, errTemplateDropped) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// templateExists checks whether the template database exists,
+// and whether it is marked as a template.
+func (tm *TemplateManager) templateExists(ctx context.Context, adminConn DatabaseConnection) (exists, isTemplate bool, err error) {
+	checkQuery := tm.queryDialect.DatabaseExistsQuery(tm.templateName)
+	err = adminConn.QueryRowContext(ctx, checkQuery).Scan(&isTemplate)
+	switch {
+	case errors.Is(err, tm.provider.GetNoRowsSentinel()):
+		return false, false, nil
+	case err != nil:
+		return false, false, fmt.Errorf("failed to check if template exists: %w", err)
+	}
+	return true, isTemplate, nil
+}
+
+// lockTemplateCreation takes a session-level advisory lock on the
+// template name, which is held while creating the template, so that
+// other managers wait for it, and can tell a template being created
+// from an abandoned one. It waits at most TemplateWaitTimeout for
+// another manager holding the lock.
+//
+// The returned function releases the lock.
+func (tm *TemplateManager) lockTemplateCreation(ctx context.Context, adminConn DatabaseConnection) (func() error, error) {
+	lockCtx := ctx
+	if tm.templateWaitTimeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, tm.templateWaitTimeout)
+		defer cancel()
+	}
+
+	lockKey := "hashtext(" + formatters.QuoteLiteral("pgdbtemplate:"+tm.templateName) + ")"
+	if _, err := adminConn.ExecContext(lockCtx, "SELECT pg_advisory_lock("+lockKey+")"); err != nil {
+		if ctx.Err() == nil && lockCtx.Err() != nil {
+			return nil, fmt.Errorf(
+				"timed out after %v waiting for another manager to create the template database %q: %w",
+				tm.templateWaitTimeout, tm.templateName, lockCtx.Err(),
+			)
+		}
+		return nil, fmt.Errorf("failed to lock the template database creation: %w", err)
+	}
+	return func() error {
+		// Release the lock even if ctx is done, as a reused admin
+		// connection would hold it otherwise.
+		if _, err := adminConn.ExecContext(context.Background(), "SELECT pg_advisory_unlock("+lockKey+")"); err != nil {
+			return fmt.Errorf("failed to unlock the template database creation: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// verifyTemplateDatabase checks that the template database,
+// created by someone else, exists and is marked as a template.
+func (tm *TemplateManager) verifyTemplateDatabase(ctx context.Context) error {
+	adminConn, releaseAdminConn, err := tm.adminConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer releaseAdminConn()
+
+	var isTemplate bool
+	err = adminConn.QueryRowContext(ctx, tm.queryDialect.DatabaseExistsQuery(tm.templateName)).Scan(&isTemplate)
+	switch {
+	case errors.Is(err, tm.provider.GetNoRowsSentinel()):
+		return fmt.Errorf("database %q does not exist: %w", tm.templateName, ErrTemplateNotInitialized)
+	case err != nil:
+		return fmt.Errorf("failed to check if template exists: %w", err)
+	case !isTemplate && tm.marksTemplate():
+		return fmt.Errorf("database %q exists, but is not marked as a template yet: %w",
+			tm.templateName, ErrTemplateNotInitialized)
+	}
+	return nil
+}
 
-	// Mark database as template.
-	markTemplateQuery := fmt.Sprintf("ALTER DATABASE %s WITH is_template TRUE", formatters.QuoteIdentifier(tm.templateName))
+// runVerifyQuery runs Config.TemplateVerifyQuery, if any.
+func (tm *TemplateManager) runVerifyQuery(ctx context.Context, conn DatabaseConnection) error {
+	if tm.verifyQuery == "" {
+		return nil
+	}
+	var result any
+	if err := conn.QueryRowContext(ctx, tm.verifyQuery).Scan(&result); err != nil {
+		return fmt.Errorf("query %q failed: %w", tm.verifyQuery, err)
+	}
+	return nil
+}
+
+// createExtensions installs the extensions in order.
+func (tm *TemplateManager) createExtensions(ctx context.Context, conn DatabaseConnection) error {
+	for _, extension := range tm.extensions {
+		query := "CREATE EXTENSION IF NOT EXISTS " + formatters.QuoteIdentifier(extension)
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("extension %q: %w", extension, err)
+		}
+	}
+	return nil
+}
+
+// runPostMigrationSQL executes the post-migration statements in order.
+func (tm *TemplateManager) runPostMigrationSQL(ctx context.Context, conn DatabaseConnection) error {
+	for i, statement := range tm.postMigrationSQL {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("statement %d (%q) failed: %w", i+1, statement, err)
+		}
+	}
+	return nil
+}
+
+// waitForTemplateDatabase waits until the template database, which is
+// being created concurrently by another manager, is marked as a template.
+func (tm *TemplateManager) waitForTemplateDatabase(ctx context.Context, adminConn DatabaseConnection) error {
+	// Unmarked templates cannot be told apart from half-created ones.
+	if !tm.marksTemplate() {
+		return nil
+	}
+
+	waitCtx := ctx
+	if tm.templateWaitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, tm.templateWaitTimeout)
+		defer cancel()
+	}
+	waitErr := func() error {
+		if ctx.Err() == nil {
+			return fmt.Errorf(
+				"timed out after %v waiting for the concurrently created template database %q to be marked as a template, "+
+					"drop it if the manager creating it crashed: %w",
+				tm.templateWaitTimeout, tm.templateName, waitCtx.Err(),
+			)
+		}
+		return fmt.Errorf("failed to wait for the concurrently created template database: %w", ctx.Err())
+	}
+
+	checkQuery := tm.queryDialect.DatabaseExistsQuery(tm.templateName)
+	ticker := time.NewTicker(templatePollInterval)
+	defer ticker.Stop()
+	for {
+		var isTemplate bool
+		err := adminConn.QueryRowContext(waitCtx, checkQuery).Scan(&isTemplate)
+		switch {
+		case err == nil && isTemplate:
+			return nil
+		case errors.Is(err, tm.provider.GetNoRowsSentinel()):
+			return errTemplateDropped
+		case err != nil && waitCtx.Err() != nil:
+			return waitErr()
+		case err != nil:
+			return fmt.Errorf("failed to check if template exists: %w", err)
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return waitErr()
+		case <-ticker.C:
+		}
+	}
+}
+
+// markTemplateDatabase marks the template database as a template.
+func (tm *TemplateManager) markTemplateDatabase(ctx context.Context, adminConn DatabaseConnection) error {
+	if !tm.marksTemplate() {
+		return nil
+	}
+
+	markTemplateQuery := tm.queryDialect.MarkTemplateQuery(tm.templateName, true)
 	if _, err := adminConn.ExecContext(ctx, markTemplateQuery); err != nil {
 		return fmt.Errorf("failed to mark database as template: %w", err)
 	}
 	return nil
 }
 
+// marksTemplate reports whether the template database is marked
+// as a template, which is not the case with SkipTemplateMarking
+// and with CockroachDB, which has no template databases.
+func (tm *TemplateManager) marksTemplate() bool {
+	return !tm.skipMarking && tm.dialect != DialectCockroach
+}
+
+// createTemplateQuery builds the CREATE DATABASE statement
+// for the template database.
+func (tm *TemplateManager) createTemplateQuery() string {
+	if tm.createTemplateSQLFunc != nil {
+		return tm.createTemplateSQLFunc(tm.templateName)
+	}
+
+	query := "CREATE DATABASE " + formatters.QuoteIdentifier(tm.templateName)
+	if tm.templateOwner != "" {
+		query += " OWNER " + formatters.QuoteIdentifier(tm.templateOwner)
+	}
+	if tm.templateEncoding == "" && tm.templateLCCollate == "" {
+		return query
+	}
+
+	if tm.templateEncoding != "" {
+		query += " ENCODING " + formatters.QuoteLiteral(tm.templateEncoding)
+	}
+	if tm.templateLCCollate != "" {
+		query += " LC_COLLATE " + formatters.QuoteLiteral(tm.templateLCCollate) +
+			" LC_CTYPE " + formatters.QuoteLiteral(tm.templateLCCtype)
+	}
+	// PostgreSQL only allows a non-default encoding and locale
+	// when copying from template0.
+	return query + " TEMPLATE template0"
+}
+
+// checkTemplate0 checks that template0, which the template database is
+// copied from with a custom encoding or locale, can be copied.
+//
+// Only datistemplate is checked: template0 does not allow connections
+// by default, but that doesn't prevent copying it.
+func (tm *TemplateManager) checkTemplate0(ctx context.Context, adminConn DatabaseConnection) error {
+	if tm.createTemplateSQLFunc != nil ||
+		(tm.templateEncoding == "" && tm.templateLCCollate == "") {
+		return nil
+	}
+
+	var isTemplate bool
+	err := adminConn.QueryRowContext(ctx, tm.queryDialect.DatabaseExistsQuery("template0")).Scan(&isTemplate)
+	switch {
+	case errors.Is(err, tm.provider.GetNoRowsSentinel()):
+		return fmt.Errorf("template0 does not exist, but is required for a custom encoding or locale of the template: " +
+			"recreate it from template1, or leave TemplateEncoding, TemplateLCCollate and TemplateLCCtype empty")
+	case err != nil:
+		return fmt.Errorf("failed to check if template0 can be copied: %w", err)
+	case !isTemplate:
+		return fmt.Errorf("template0 is not marked as a template, so it cannot be copied for a custom encoding " +
+			"or locale of the template: run ALTER DATABASE template0 WITH is_template TRUE as a superuser")
+	}
+	return nil
+}
+
 // cleanupTemplateDatabase removes the template database.
 func (tm *TemplateManager) cleanupTemplateDatabase(ctx context.Context, adminConn DatabaseConnection) error {
 	// Terminate active connections to the template database.
-	if err := tm.batchTerminateConnections(ctx, adminConn, []string{tm.templateName}); err != nil {
+	if _, err := tm.terminateConnections(ctx, adminConn, []string{tm.templateName}); err != nil {
 		return fmt.Errorf("failed to terminate connections to the template database: %w", err)
 	}
 
 	// Unmark as template first.
-	unmarkQuery := fmt.Sprintf("ALTER DATABASE %s WITH is_template FALSE", formatters.QuoteIdentifier(tm.templateName))
-	_, err := adminConn.ExecContext(ctx, unmarkQuery)
-	if err != nil {
-		return fmt.Errorf("failed to unmark template database: %w", err)
+	if tm.marksTemplate() {
+		unmarkQuery := tm.queryDialect.MarkTemplateQuery(tm.templateName, false)
+		if _, err := adminConn.ExecContext(ctx, unmarkQuery); err != nil {
+			return fmt.Errorf("failed to unmark template database: %w", err)
+		}
 	}
 
 	// Drop template database.
 	dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(tm.templateName))
-	_, err = adminConn.ExecContext(ctx, dropQuery)
+	_, err := adminConn.ExecContext(ctx, dropQuery)
 	return err
 }
 
-// cleanupTrackedTestDatabases removes all test databases tracked by this manager.
-func (tm *TemplateManager) cleanupTrackedTestDatabases(ctx context.Context, adminConn DatabaseConnection) (errs error) {
+// cleanupTrackedTestDatabases removes all test databases tracked by this manager,
+// adding the outcome of every drop to report.
+func (tm *TemplateManager) cleanupTrackedTestDatabases(ctx context.Context, adminConn DatabaseConnection, report *CleanupReport) (errs error) {
 	// Collect all tracked database names to avoid modifying map
 	// during iteration.
-	var dbNames []string
-	tm.createdTestDBs.Range(func(key, value any) bool {
-		if dbName, ok := key.(string); ok {
-			dbNames = append(dbNames, dbName)
-		}
-		return true
-	})
+	dbNames := tm.trackedTestDatabases()
 	if len(dbNames) == 0 {
 		return nil // No databases to clean up.
 	}
@@ -384,43 +1937,149 @@ func (tm *TemplateManager) cleanupTrackedTestDatabases(ctx context.Context, admi
 	// Batch terminate active connections for all databases at once.
 	// Connections might already be closed, so we append the error,
 	// but continue with cleanup.
-	if err := tm.batchTerminateConnections(ctx, adminConn, dbNames); err != nil {
+	if _, err := tm.terminateConnections(ctx, adminConn, dbNames); err != nil {
 		errs = fmt.Errorf("failed to terminate connections for some databases: %w", err)
 	}
 
 	// Drop all databases individually.
-	// PostgreSQL doesn't allow DROP DATABASE in transactions/batches.
-	for _, dbName := range dbNames {
-		dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(dbName))
-		_, err := adminConn.ExecContext(ctx, dropQuery)
+	// PostgreSQL doesn't allow DROP DATABASE in transactions/batches,
+	// so the drops are spread across up to cleanupConcurrency workers,
+	// each using its own admin connection.
+	dropErrs := make([]error, len(dbNames))
+	indexes := make(chan int)
+	workers := tm.cleanupConcurrency
+	if workers > len(dbNames) {
+		workers = len(dbNames)
+	}
+
+	var wg sync.WaitGroup
+	var connectErrsMu sync.Mutex
+	var connectErrs []error
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			// The first worker uses the given admin connection, so that
+			// the databases are dropped even if no more connections can
+			// be opened.
+			conn := adminConn
+			if w > 0 {
+				workerConn, err := tm.provider.Connect(ctx, tm.adminDBName)
+				if err != nil {
+					connectErrsMu.Lock()
+					connectErrs = append(connectErrs, fmt.Errorf("failed to connect to admin database: %w", err))
+					connectErrsMu.Unlock()
+					return
+				}
+				defer workerConn.Close()
+				conn = workerConn
+			}
+
+			for i := range indexes {
+				dropErrs[i] = tm.dropTrackedTestDatabase(ctx, conn, dbNames[i])
+			}
+		}(w)
+	}
+	for i := range dbNames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	errs = errors.Join(errs, errors.Join(connectErrs...))
+	for i, err := range dropErrs {
 		if err != nil {
-			errs = errors.Join(errs, fmt.Errorf("failed to drop database %q: %w", dbName, err))
-			continue // Continue cleaning up other databases.
+			report.Failures = append(report.Failures, CleanupFailure{Name: dbNames[i], Err: err})
+		} else {
+			report.DroppedTestDatabases++
 		}
-
-		// Remove from tracking map only if drop was successful.
-		tm.createdTestDBs.Delete(dbName)
+		errs = errors.Join(errs, err)
 	}
 	return errs
 }
 
-// batchTerminateConnections terminates active connections for multiple databases
-// in a single query.
-func (tm *TemplateManager) batchTerminateConnections(ctx context.Context, adminConn DatabaseConnection, dbNames []string) error {
-	// Build quoted literals for each database name.
-	// Using QuoteLiteral is safe here since database names are controlled by this library
-	// and provide better performance than parameterized queries (~30% faster).
-	quotedNames := make([]string, len(dbNames))
-	for i, dbName := range dbNames {
-		quotedNames[i] = formatters.QuoteLiteral(dbName)
+// trackedTestDatabase describes how a tracked test database was created,
+// so that ResetTestDatabase can create it again.
+type trackedTestDatabase struct {
+	sourceTemplate string
+	opts           createOptions
+}
+
+// dropTrackedTestDatabase drops a tracked test database and removes it
+// from tracking if the drop was successful.
+func (tm *TemplateManager) dropTrackedTestDatabase(ctx context.Context, adminConn DatabaseConnection, dbName string) error {
+	dropQuery := fmt.Sprintf("DROP DATABASE %s", formatters.QuoteIdentifier(dbName))
+	_, err := adminConn.ExecContext(ctx, dropQuery)
+	if err != nil && tm.dialect != DialectCockroach && SQLState(err) == sqlStateWrongObjectType {
+		// The test database was marked as a template out-of-band,
+		// so unmark it and try again.
+		unmarkQuery := tm.queryDialect.MarkTemplateQuery(dbName, false)
+		if _, unmarkErr := adminConn.ExecContext(ctx, unmarkQuery); unmarkErr != nil {
+			return errors.Join(
+				fmt.Errorf("failed to drop database %q: %w", dbName, err),
+				fmt.Errorf("failed to unmark database %q as template: %w", dbName, unmarkErr),
+			)
+		}
+		_, err = adminConn.ExecContext(ctx, dropQuery)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to drop database %q: %w", dbName, classifyError(err))
 	}
 
-	terminateQuery := fmt.Sprintf(`
-		SELECT pg_terminate_backend(pid) 
-		FROM pg_stat_activity 
-		WHERE datname IN (%s) AND pid <> pg_backend_pid()
-	`, strings.Join(quotedNames, ", "))
+	tm.createdTestDBs.Delete(dbName)
+	tm.metrics.IncDropped()
+	return nil
+}
 
-	_, err := adminConn.ExecContext(ctx, terminateQuery)
-	return err
+// hasTrackedTestDatabases reports whether any test databases are tracked.
+func (tm *TemplateManager) hasTrackedTestDatabases() bool {
+	tracked := false
+	tm.createdTestDBs.Range(func(key, value any) bool {
+		tracked = true
+		return false
+	})
+	return tracked
+}
+
+// trackedTestDatabases returns the sorted names of the tracked test databases.
+func (tm *TemplateManager) trackedTestDatabases() []string {
+	var dbNames []string
+	tm.createdTestDBs.Range(func(key, value any) bool {
+		if dbName, ok := key.(string); ok {
+			dbNames = append(dbNames, dbName)
+		}
+		return true
+	})
+	sort.Strings(dbNames)
+	return dbNames
+}
+
+// terminateConnections terminates active connections to the databases
+// with a query per batch of TerminateBatchSize databases, and returns
+// how many were terminated. Failing batches don't stop the others.
+func (tm *TemplateManager) terminateConnections(ctx context.Context, adminConn DatabaseConnection, dbNames []string) (int, error) {
+	// CockroachDB drops databases regardless of open connections.
+	if tm.dialect == DialectCockroach {
+		return 0, nil
+	}
+
+	// Terminate in batches, so that the queries stay small.
+	var total int
+	var errs error
+	for start := 0; start < len(dbNames); start += tm.terminateBatchSize {
+		end := start + tm.terminateBatchSize
+		if end > len(dbNames) {
+			end = len(dbNames)
+		}
+
+		var terminated int
+		terminateQuery := tm.queryDialect.TerminateConnectionsQuery(dbNames[start:end])
+		if err := adminConn.QueryRowContext(ctx, terminateQuery).Scan(&terminated); err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		total += terminated
+	}
+	return total, errs
 }
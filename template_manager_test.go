@@ -3,6 +3,7 @@ package pgdbtemplate_test
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	qt "github.com/frankban/quicktest"
 
 	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/andrei-polukhin/pgdbtemplate/pgdbtemplatetest"
 )
 
 const (
@@ -238,6 +240,111 @@ func TestTemplateManagerErrors(t *testing.T) {
 		_, err := pgdbtemplate.NewTemplateManager(config)
 		c.Assert(err, qt.ErrorMatches, ".*MigrationRunner.*required.*")
 	})
+
+	c.Run("Too long TemplateName", func(c *qt.C) {
+		config := pgdbtemplate.Config{
+			ConnectionProvider: &mockConnectionProvider{},
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       strings.Repeat("t", 64),
+		}
+		_, err := pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.ErrorMatches, `TemplateName "t{64}" is 64 bytes long, but PostgreSQL only allows up to 63 bytes`)
+
+		// Multi-byte characters count in bytes.
+		config.TemplateName = strings.Repeat("т", 32)
+		_, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.ErrorMatches, `TemplateName ".*" is 64 bytes long, .*`)
+
+		config.TemplateName = strings.Repeat("t", 63)
+		_, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("Too long TestDBPrefix", func(c *qt.C) {
+		config := pgdbtemplate.Config{
+			ConnectionProvider: &mockConnectionProvider{},
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TestDBPrefix:       strings.Repeat("p", 37),
+		}
+		_, err := pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.ErrorMatches, `TestDBPrefix "p{37}" is 37 bytes long, but at most 36 bytes are allowed, since generated names add up to 27 bytes to it`)
+
+		config.TestDBPrefix = strings.Repeat("p", 36)
+		_, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("TemplateName starting with TestDBPrefix", func(c *qt.C) {
+		config := pgdbtemplate.Config{
+			ConnectionProvider: &mockConnectionProvider{},
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "template_db_app",
+			TestDBPrefix:       "template_",
+		}
+		_, err := pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.IsNil)
+
+		config.StrictTestDBPrefix = true
+		_, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.ErrorMatches, `TemplateName "template_db_app" starts with TestDBPrefix "template_", `+
+			`so the template could be dropped as a test database`)
+
+		// The same applies to generated template names.
+		config.TemplateName = ""
+		_, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.ErrorMatches, `TemplateName "template_db_.*" starts with TestDBPrefix "template_", .*`)
+
+		config.TestDBPrefix = "template_test_"
+		_, err = pgdbtemplate.NewTemplateManager(config)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("Template as AdminDBName", func(c *qt.C) {
+		for _, adminDBName := range []string{"template0", "template1", "admin_template"} {
+			_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: &mockConnectionProvider{},
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:       "admin_template",
+				AdminDBName:        adminDBName,
+			})
+			c.Assert(err, qt.ErrorMatches, fmt.Sprintf(`AdminDBName must not be "%s", since a template cannot be copied while connected to`, adminDBName))
+		}
+
+		_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: &mockConnectionProvider{},
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "admin_template",
+			AdminDBName:        "maintenance",
+		})
+		c.Assert(err, qt.IsNil)
+	})
+}
+
+// TestLongTestDatabaseNames tests that test database names
+// fit PostgreSQL's identifier length limit.
+func TestLongTestDatabaseNames(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	prefix := strings.Repeat("p", 36)
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "long_names_template",
+		TestDBPrefix:       prefix,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.HasPrefix(testDBName, prefix), qt.IsTrue)
+	c.Assert(len(testDBName) <= 63, qt.IsTrue, qt.Commentf("%q is %d bytes long", testDBName, len(testDBName)))
+
+	_, _, err = tm.CreateTestDatabase(ctx, strings.Repeat("n", 64))
+	c.Assert(err, qt.ErrorMatches, `test database name "n{64}" is 64 bytes long, but PostgreSQL only allows up to 63 bytes`)
 }
 
 // TestDropTestDatabaseError tests error handling in DropTestDatabase.
@@ -254,234 +361,2156 @@ func TestDropTestDatabaseError(t *testing.T) {
 		TemplateName:       fmt.Sprintf("drop_error_template_%d_%d", time.Now().UnixNano(), os.Getpid()),
 	}
 
-	tm, err := pgdbtemplate.NewTemplateManager(config)
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+
+	// Initialize the template so DropTestDatabase can work.
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+	defer func() {
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	}()
+
+	// Try to drop a non-existent database - this should error since
+	// DROP DATABASE is used (not IF EXISTS).
+	err = tm.DropTestDatabase(ctx, "non_existent_db_12345")
+	c.Assert(err, qt.ErrorMatches, ".*does not exist.*")
+}
+
+// TestCreateTestDatabaseWithExistingName tests creating a database with an existing name.
+func TestCreateTestDatabaseWithExistingName(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	connProvider := setupTestConnectionProvider()
+	migrationRunner := setupTestMigrationRunner(c)
+
+	config := pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    migrationRunner,
+		TemplateName:       fmt.Sprintf("existing_name_template_%d_%d", time.Now().UnixNano(), os.Getpid()),
+	}
+
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+	defer func() {
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	}()
+
+	// Create first database with custom name.
+	testDBName := fmt.Sprintf("existing_test_%d_%d", time.Now().UnixNano(), os.Getpid())
+	testDB1, _, err := tm.CreateTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDB1.Close(), qt.IsNil)
+
+	// Try to create second database with same name -
+	// should fail with "already exists" error.
+	_, _, err = tm.CreateTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.ErrorMatches, ".*already exists.*")
+}
+
+// TestTemplateManagerCleanupErrorPaths tests error handling in Cleanup
+// when DROP DATABASE fails.
+func TestTemplateManagerCleanupErrorPaths(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	config := pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failDrop: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_cleanup_error",
+		TestDBPrefix:       "test_template_cleanup_error_",
+	}
+
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+
+	// Simulate initialization and creation of test DBs.
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx) // Should be no-op on re-initialization.
+	c.Assert(err, qt.IsNil)
+
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// Cleanup should fail due to DROP DATABASE error.
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.ErrorMatches, "(?s).*drop error.*drop error.*")
+
+	// Note: Using mock provider, no real databases created - cleanup not needed.
+}
+
+// TestTemplateManagerDropTestDatabaseErrorPaths tests error handling in DropTestDatabase
+// when pg_terminate_backend and DROP DATABASE fail.
+func TestTemplateManagerDropTestDatabaseErrorPaths(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	config := pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failTerminate: true, failDrop: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_drop_error",
+		TestDBPrefix:       "test_drop_error_",
+	}
+
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// DropTestDatabase should fail due to terminate error (first step).
+	err = tm.DropTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.ErrorMatches, ".*terminate error.*")
+
+	// Note: Using mock provider, no real databases created - cleanup not needed.
+}
+
+// TestDropTemplateDatabase tests that dropping the template
+// keeps the tracked test databases.
+func TestDropTemplateDatabase(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "drop_only_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// Nothing to drop before Initialize.
+	c.Assert(tm.DropTemplateDatabase(ctx), qt.IsNil)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	_, testDBNames, err := tm.CreateTestDatabases(ctx, 2)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(tm.DropTemplateDatabase(ctx), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, "drop_only_template"), qt.IsFalse)
+	for _, testDBName := range testDBNames {
+		c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsTrue)
+	}
+
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsTrue, qt.Commentf("got %v", err))
+
+	// The template is rebuilt by Initialize.
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, "drop_only_template"), qt.IsTrue)
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, "drop_only_template"), qt.IsFalse)
+	for _, testDBName := range testDBNames {
+		c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsFalse)
+	}
+	err = tm.DropTemplateDatabase(ctx)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrManagerClosed), qt.IsTrue, qt.Commentf("got %v", err))
+
+	c.Run("AssumeTemplateReady", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:  connProvider,
+			MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:        "drop_only_shared_template",
+			AssumeTemplateReady: true,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.DropTemplateDatabase(ctx), qt.ErrorMatches,
+			"the template database cannot be dropped with AssumeTemplateReady")
+	})
+}
+
+func TestDropTemplateDatabaseConnectionError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failConnect: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_db_conn_error",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, ".*connect error.*")
+
+	// The template was not initialized, so no connection is attempted.
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsTrue)
+
+	err = tm.DropTestDatabase(ctx, "any_db")
+	c.Assert(errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized), qt.IsTrue)
+}
+
+func TestDropTemplateDatabaseUnmarkError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failUnmark: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_db_unmark_error",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.ErrorMatches, ".*unmark error.*")
+
+	// Note: Using mock provider, no real databases created - cleanup not needed.
+}
+
+func TestDropTemplateDatabaseQueryRowError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failQueryRow: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_db_queryrow_error",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, ".*queryrow error.*")
+}
+
+func TestDropTemplateDatabaseBatchTerminateFailure(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failTerminate: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_batch_terminate_fail",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// Cleanup should report terminate error.
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.ErrorMatches, `(?s).*terminate error.*`)
+
+	// Note: Using mock provider, no real databases created - cleanup not needed.
+}
+
+func TestInitializeCreateError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failCreate: true, nonExistentQueryRow: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "fail_create_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, ".*failed to create template database.*create error.*")
+}
+
+// TestInitializeErrorIsReturnedToAllCallers tests that a failed template
+// creation is not retried by concurrent and later calls to Initialize.
+func TestInitializeErrorIsReturnedToAllCallers(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	connProvider := setupTestConnectionProvider()
+	newManager := func(c *qt.C, templateName string, forceRecreate bool) (*pgdbtemplate.TemplateManager, *int) {
+		// Migrations are run under the lock of the manager.
+		runs := 0
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: connProvider,
+			MigrationRunner: migrationRunnerFunc(func(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+				runs++
+				if runs == 1 {
+					return errors.New("migration error")
+				}
+				return nil
+			}),
+			TemplateName:  templateName,
+			ForceRecreate: forceRecreate,
+		})
+		c.Assert(err, qt.IsNil)
+		return tm, &runs
+	}
+
+	c.Run("Concurrent callers", func(c *qt.C) {
+		tm, runs := newManager(c, "cached_error_template", false)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = tm.Initialize(ctx)
+			}(i)
+		}
+		wg.Wait()
+
+		c.Assert(errs[0], qt.ErrorMatches, "failed to create template database: .*migration error")
+		for _, err := range errs[1:] {
+			c.Assert(err, qt.Equals, errs[0])
+		}
+		c.Assert(*runs, qt.Equals, 1)
+
+		// Cleanup resets the error, so the template is created again.
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Assert(*runs, qt.Equals, 2)
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+
+	c.Run("ForceRecreate", func(c *qt.C) {
+		tm, runs := newManager(c, "cached_error_force_template", true)
+
+		c.Assert(tm.Initialize(ctx), qt.ErrorMatches, "failed to create template database: .*migration error")
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Assert(*runs, qt.Equals, 2)
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+}
+
+func TestCleanupAdminConnectError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &oneTimeFailProvider{},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "cleanup_admin_connect_error",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.ErrorMatches, "failed to connect to admin database:.*connect error for admin db")
+
+	// Note: Using mock provider, no real databases created - cleanup not needed.
+}
+
+// TestTemplateEncodingAndLocale tests the CREATE DATABASE statement
+// generated for the template database with a custom encoding and locale.
+func TestTemplateEncodingAndLocale(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+
+		encoding  string
+		lcCollate string
+		lcCtype   string
+
+		expected string
+	}{{
+		name:     "server defaults",
+		expected: `CREATE DATABASE "locale_template"`,
+	}, {
+		name:     "encoding only",
+		encoding: "UTF8",
+		expected: `CREATE DATABASE "locale_template" ENCODING 'UTF8' TEMPLATE template0`,
+	}, {
+		name:      "locale only",
+		lcCollate: "C",
+		lcCtype:   "C",
+		expected:  `CREATE DATABASE "locale_template" LC_COLLATE 'C' LC_CTYPE 'C' TEMPLATE template0`,
+	}, {
+		name:      "encoding and locale",
+		encoding:  "UTF8",
+		lcCollate: "en_US.UTF-8",
+		lcCtype:   "en_US.UTF-8",
+		expected:  `CREATE DATABASE "locale_template" ENCODING 'UTF8' LC_COLLATE 'en_US.UTF-8' LC_CTYPE 'en_US.UTF-8' TEMPLATE template0`,
+	}, {
+		name:      "quoted values",
+		lcCollate: "it's",
+		lcCtype:   "C",
+		expected:  `CREATE DATABASE "locale_template" LC_COLLATE 'it''s' LC_CTYPE 'C' TEMPLATE template0`,
+	}}
+
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: provider,
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:       "locale_template",
+				TemplateEncoding:   test.encoding,
+				TemplateLCCollate:  test.lcCollate,
+				TemplateLCCtype:    test.lcCtype,
+			})
+			c.Assert(err, qt.IsNil)
+
+			err = tm.Initialize(ctx)
+			c.Assert(err, qt.IsNil)
+			c.Assert(provider.recordedQueries()[0], qt.Equals, test.expected)
+		})
+	}
+
+	c.Run("LC_COLLATE without LC_CTYPE", func(c *qt.C) {
+		_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: setupTestConnectionProvider(),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateLCCollate:  "C",
+		})
+		c.Assert(err, qt.ErrorMatches, "TemplateLCCollate and TemplateLCCtype must be set together")
+	})
+
+	c.Run("template0 cannot be copied", func(c *qt.C) {
+		mockProvider := NewMockConnectionProvider()
+		provider := &recordingConnectionProvider{ConnectionProvider: mockProvider}
+		newManager := func(c *qt.C, lcCollate string) *pgdbtemplate.TemplateManager {
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: provider,
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:       "locale_template",
+				TemplateLCCollate:  lcCollate,
+				TemplateLCCtype:    lcCollate,
+			})
+			c.Assert(err, qt.IsNil)
+			return tm
+		}
+
+		mockProvider.templates["template0"] = false
+		err := newManager(c, "C").Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, "failed to create template database: template0 is not marked as a template, "+
+			"so it cannot be copied for a custom encoding or locale of the template: "+
+			"run ALTER DATABASE template0 WITH is_template TRUE as a superuser")
+		c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+
+		delete(mockProvider.databases, "template0")
+		err = newManager(c, "C").Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, "failed to create template database: template0 does not exist, .*")
+		c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+
+		// template0 is not needed with the server defaults.
+		c.Assert(newManager(c, "").Initialize(ctx), qt.IsNil)
+	})
+}
+
+// TestTemplateOwner tests that the template database is created
+// with the configured owner.
+func TestTemplateOwner(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+
+		owner    string
+		encoding string
+
+		expected string
+	}{{
+		name:     "owner",
+		owner:    "app_role",
+		expected: `CREATE DATABASE "owner_template" OWNER "app_role"`,
+	}, {
+		name:     "owner needing quotes",
+		owner:    `App "Role"`,
+		expected: `CREATE DATABASE "owner_template" OWNER "App ""Role"""`,
+	}, {
+		name:     "owner with encoding",
+		owner:    "app_role",
+		encoding: "UTF8",
+		expected: `CREATE DATABASE "owner_template" OWNER "app_role" ENCODING 'UTF8' TEMPLATE template0`,
+	}}
+
+	for _, test := range tests {
+		c.Run(test.name, func(c *qt.C) {
+			provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: provider,
+				MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+				TemplateName:       "owner_template",
+				TemplateOwner:      test.owner,
+				TemplateEncoding:   test.encoding,
+			})
+			c.Assert(err, qt.IsNil)
+
+			err = tm.Initialize(ctx)
+			c.Assert(err, qt.IsNil)
+			c.Assert(provider.recordedQueries()[0], qt.Equals, test.expected)
+		})
+	}
+}
+
+// TestCreateSQLFuncs tests that custom statements are used
+// to create the template and test databases.
+func TestCreateSQLFuncs(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "icu_template",
+		CreateTemplateSQLFunc: func(templateName string) string {
+			return "CREATE DATABASE " + pgdbtemplate.QuoteIdentifier(templateName) +
+				" LOCALE_PROVIDER icu ICU_LOCALE " + pgdbtemplate.QuoteLiteral("en-US") + " TEMPLATE template0"
+		},
+		CreateTestDBSQLFunc: func(dbName, templateName string) string {
+			return "CREATE DATABASE " + pgdbtemplate.QuoteIdentifier(dbName) +
+				" WITH TEMPLATE " + pgdbtemplate.QuoteIdentifier(templateName) + " STRATEGY FILE_COPY"
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+	c.Assert(provider.recordedQueries()[0], qt.Equals,
+		`CREATE DATABASE "icu_template" LOCALE_PROVIDER icu ICU_LOCALE 'en-US' TEMPLATE template0`)
+
+	conn, _, err := tm.CreateTestDatabase(ctx, "icu_test_db")
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+	queries := provider.recordedQueries()
+	c.Assert(queries[len(queries)-1], qt.Equals,
+		`CREATE DATABASE "icu_test_db" WITH TEMPLATE "icu_template" STRATEGY FILE_COPY`)
+
+	// Clauses of the options are appended.
+	conn, _, err = tm.CreateTestDatabaseWithOptions(ctx, pgdbtemplate.WithName("icu_limited_db"), pgdbtemplate.WithConnectionLimit(2))
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+	queries = provider.recordedQueries()
+	c.Assert(queries[len(queries)-1], qt.Equals,
+		`CREATE DATABASE "icu_limited_db" WITH TEMPLATE "icu_template" STRATEGY FILE_COPY CONNECTION LIMIT 2`)
+
+	c.Run("Template options with CreateTemplateSQLFunc", func(c *qt.C) {
+		_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:    setupTestConnectionProvider(),
+			MigrationRunner:       &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateEncoding:      "UTF8",
+			CreateTemplateSQLFunc: func(templateName string) string { return "" },
+		})
+		c.Assert(err, qt.ErrorMatches, "TemplateOwner, TemplateEncoding, TemplateLCCollate and TemplateLCCtype "+
+			"cannot be used together with CreateTemplateSQLFunc")
+	})
+
+	c.Run("Cockroach", func(c *qt.C) {
+		var templateNames []string
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: cockroachProvider(setupTestConnectionProvider()),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "cockroach_sql_func_template",
+			Dialect:            pgdbtemplate.DialectCockroach,
+			CreateTestDBSQLFunc: func(dbName, templateName string) string {
+				templateNames = append(templateNames, templateName)
+				return "CREATE DATABASE " + pgdbtemplate.QuoteIdentifier(dbName)
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+		// Test databases are not copied from any template.
+		_, _, err = tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		_, _, err = tm.CreateTestDatabaseFromTemplate(ctx, "template0", "")
+		c.Assert(err, qt.IsNil)
+		c.Assert(templateNames, qt.DeepEquals, []string{"", ""})
+	})
+}
+
+// TestCreateTestDatabaseFromTemplate tests creating test databases
+// from a source template other than the managed one.
+func TestCreateTestDatabaseFromTemplate(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "from_template_managed",
+		TestDBPrefix:       "from_template_test_",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// Initialize is deliberately not called.
+	conn, testDBName, err := tm.CreateTestDatabaseFromTemplate(ctx, "template0", "")
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn, qt.IsNotNil)
+	c.Assert(strings.HasPrefix(testDBName, "from_template_test_"), qt.IsTrue)
+	c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsTrue)
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		fmt.Sprintf(`CREATE DATABASE "%s" TEMPLATE "template0"`, testDBName),
+	})
+
+	_, _, err = tm.CreateTestDatabaseFromTemplate(ctx, "template0", "from_template_custom")
+	c.Assert(err, qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, "from_template_custom"), qt.IsTrue)
+
+	// Cleanup drops the tracked databases, but not the never created template.
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(databaseExists(ctx, connProvider, testDBName), qt.IsFalse)
+	c.Assert(databaseExists(ctx, connProvider, "from_template_custom"), qt.IsFalse)
+	c.Assert(databaseExists(ctx, connProvider, "template0"), qt.IsTrue)
+	for _, query := range provider.recordedQueries() {
+		c.Assert(strings.Contains(query, "from_template_managed"), qt.IsFalse)
+	}
+}
+
+// TestUninitializedUse tests that operations requiring the template
+// fail with ErrTemplateNotInitialized before Initialize,
+// and with ErrManagerClosed after Cleanup.
+func TestUninitializedUse(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "uninitialized_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	assertUninitialized := func(sentinel error) {
+		_, _, err := tm.CreateTestDatabase(ctx, "uninitialized_db")
+		c.Assert(errors.Is(err, sentinel), qt.IsTrue, qt.Commentf("got %v", err))
+		_, _, err = tm.CreateTestDatabases(ctx, 2)
+		c.Assert(errors.Is(err, sentinel), qt.IsTrue, qt.Commentf("got %v", err))
+		_, err = tm.ResetTestDatabase(ctx, "uninitialized_db")
+		c.Assert(errors.Is(err, sentinel), qt.IsTrue, qt.Commentf("got %v", err))
+		err = tm.DropTestDatabase(ctx, "uninitialized_db")
+		c.Assert(errors.Is(err, sentinel), qt.IsTrue, qt.Commentf("got %v", err))
+	}
+
+	assertUninitialized(pgdbtemplate.ErrTemplateNotInitialized)
+	c.Assert(provider.recordedConnects(), qt.HasLen, 0)
+
+	// Databases from other templates can still be created and dropped.
+	_, fromTemplateDBName, err := tm.CreateTestDatabaseFromTemplate(ctx, "template0", "")
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.DropTestDatabase(ctx, fromTemplateDBName), qt.IsNil)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.DropTestDatabase(ctx, testDBName), qt.IsNil)
+
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	assertUninitialized(pgdbtemplate.ErrManagerClosed)
+}
+
+// TestUninitializedUseConcurrentWithInitialize tests that the initialization
+// check doesn't deadlock with concurrent Initialize calls.
+func TestUninitializedUseConcurrentWithInitialize(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "uninitialized_concurrent_template",
+	})
+	c.Assert(err, qt.IsNil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := tm.Initialize(ctx); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, testDBName, err := tm.CreateTestDatabase(ctx)
+			if errors.Is(err, pgdbtemplate.ErrTemplateNotInitialized) {
+				return
+			}
+			if err == nil {
+				err = tm.DropTestDatabase(ctx, testDBName)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+// TestAutoInitialize tests that concurrent first calls to CreateTestDatabase
+// initialize the template exactly once when AutoInitialize is set.
+func TestAutoInitialize(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "auto_init_template",
+		AutoInitialize:     true,
+	})
+	c.Assert(err, qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	const numGoroutines = 20
+	var wg sync.WaitGroup
+	errs := make([]error, numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, _, err := tm.CreateTestDatabase(ctx)
+			if err == nil {
+				err = conn.Close()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		c.Assert(err, qt.IsNil)
+	}
+
+	var templateCreates int
+	for _, query := range provider.recordedQueries() {
+		if strings.HasPrefix(query, `CREATE DATABASE "auto_init_template"`) {
+			templateCreates++
+		}
+	}
+	c.Assert(templateCreates, qt.Equals, 1)
+}
+
+// TestAutoInitializeError tests that initialization errors
+// are returned from CreateTestDatabase.
+func TestAutoInitializeError(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &mockDropTemplateDBProvider{failConnect: true},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "auto_init_error_template",
+		AutoInitialize:     true,
+	})
+	c.Assert(err, qt.IsNil)
+
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.ErrorMatches, "failed to initialize template: .*connect error.*")
+
+	_, _, err = tm.CreateTestDatabases(ctx, 2)
+	c.Assert(err, qt.ErrorMatches, "failed to initialize template: .*connect error.*")
+}
+
+// TestUnwrapper tests that the connection returned by CreateTestDatabase
+// is the provider's one, so the underlying driver object can be unwrapped.
+func TestUnwrapper(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &unwrappingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()},
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "unwrapper_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	conn, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	unwrapper, ok := conn.(pgdbtemplate.Unwrapper)
+	c.Assert(ok, qt.IsTrue)
+	driverConn, ok := unwrapper.Unwrap().(*sharedMockDatabaseConnection)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(driverConn.dbName, qt.Equals, testDBName)
+}
+
+// unwrappingConnectionProvider wraps a ConnectionProvider
+// whose connections implement pgdbtemplate.Unwrapper.
+type unwrappingConnectionProvider struct {
+	pgdbtemplate.ConnectionProvider
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *unwrappingConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	return &unwrappingConnection{DatabaseConnection: conn}, nil
+}
+
+// unwrappingConnection is the connection of unwrappingConnectionProvider.
+type unwrappingConnection struct {
+	pgdbtemplate.DatabaseConnection
+}
+
+// Unwrap implements pgdbtemplate.Unwrapper.Unwrap.
+func (c *unwrappingConnection) Unwrap() any {
+	return c.DatabaseConnection
+}
+
+// TestInitializeNonTemplateDatabase tests Initialize when a database
+// which is not a template already exists with the template name, be it
+// a regular database or a template left over by an interrupted Initialize.
+func TestInitializeNonTemplateDatabase(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		forceRecreate bool
+
+		expectedErr        string
+		expectedMigrations int64
+		expectedQueries    []string
+	}{{
+		name: "fail",
+		expectedErr: `failed to create template database: database "non_template_db" already exists, but is not a template: ` +
+			`use a different TemplateName, or set ForceRecreate to drop and recreate it`,
+	}, {
+		name:               "force recreate",
+		forceRecreate:      true,
+		expectedMigrations: 1,
+		expectedQueries: []string{
+			`DROP DATABASE "non_template_db"`,
+			`CREATE DATABASE "non_template_db"`,
+			`ALTER DATABASE "non_template_db" WITH is_template TRUE`,
+		},
+	}}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			c := qt.New(t)
+			ctx := context.Background()
+
+			connProvider := setupTestConnectionProvider()
+			adminConn, err := connProvider.Connect(ctx, "postgres")
+			c.Assert(err, qt.IsNil)
+			_, err = adminConn.ExecContext(ctx, `CREATE DATABASE "non_template_db"`)
+			c.Assert(err, qt.IsNil)
+
+			provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+			migrator := &countingMigrationRunner{}
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: provider,
+				MigrationRunner:    migrator,
+				TemplateName:       "non_template_db",
+				ForceRecreate:      test.forceRecreate,
+			})
+			c.Assert(err, qt.IsNil)
+
+			err = tm.Initialize(ctx)
+			c.Assert(migrator.count(), qt.Equals, test.expectedMigrations)
+			if test.expectedErr != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectedErr)
+				// The existing database is left untouched.
+				c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+				c.Assert(databaseExists(ctx, connProvider, "non_template_db"), qt.IsTrue)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+
+			// Filter out terminating connections before dropping.
+			var queries []string
+			for _, query := range provider.recordedQueries() {
+				if !strings.Contains(query, "pg_terminate_backend") {
+					queries = append(queries, query)
+				}
+			}
+			c.Assert(queries, qt.DeepEquals, test.expectedQueries)
+
+			// Test databases can be created from the recreated template.
+			_, _, err = tm.CreateTestDatabase(ctx)
+			c.Assert(err, qt.IsNil)
+			c.Assert(tm.Cleanup(ctx), qt.IsNil)
+		})
+	}
+}
+
+// TestInitializeExistingTemplate tests that Initialize reuses an existing
+// template database without running migrations or marking it again.
+func TestInitializeExistingTemplate(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	connProvider := setupTestConnectionProvider()
+	tm1, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "existing_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm1.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm1.Cleanup(ctx), qt.IsNil) }()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: connProvider}
+	migrator := &countingMigrationRunner{}
+	tm2, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    migrator,
+		TemplateName:       "existing_template",
+		ForceRecreate:      true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm2.Initialize(ctx), qt.IsNil)
+	c.Assert(migrator.count(), qt.Equals, int64(0))
+	c.Assert(provider.recordedQueries(), qt.HasLen, 0)
+}
+
+// TestCreateTestDatabaseTrackedName tests that names of tracked
+// test databases are rejected without a round trip to the server.
+func TestCreateTestDatabaseTrackedName(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "tracked_name_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, _, err = tm.CreateTestDatabase(ctx, "tracked_name_db")
+	c.Assert(err, qt.IsNil)
+	queryCount := len(provider.recordedQueries())
+
+	_, _, err = tm.CreateTestDatabase(ctx, "tracked_name_db")
+	c.Assert(err, qt.ErrorMatches, `test database "tracked_name_db" was already created and not dropped: database already exists`)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseAlreadyExists), qt.IsTrue)
+	c.Assert(provider.recordedQueries(), qt.HasLen, queryCount)
+
+	// Once dropped, the name can be used again.
+	c.Assert(tm.DropTestDatabase(ctx, "tracked_name_db"), qt.IsNil)
+	_, _, err = tm.CreateTestDatabase(ctx, "tracked_name_db")
+	c.Assert(err, qt.IsNil)
+}
+
+// TestTestDBNameFunc tests naming test databases with a custom function.
+func TestTestDBNameFunc(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var counter int
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "name_func_template",
+		TestDBNameFunc: func() string {
+			counter++
+			// Repeat the first name after three names.
+			return fmt.Sprintf("name_func_%s_%d", strings.ToLower(c.TB.Name()), (counter-1)%3)
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBName, qt.Equals, "name_func_testtestdbnamefunc_0")
+
+	// Explicit names take precedence.
+	_, testDBName, err = tm.CreateTestDatabase(ctx, "name_func_explicit")
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBName, qt.Equals, "name_func_explicit")
+
+	_, testDBNames, err := tm.CreateTestDatabases(ctx, 2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBNames, qt.DeepEquals, []string{
+		"name_func_testtestdbnamefunc_1",
+		"name_func_testtestdbnamefunc_2",
+	})
+
+	// Names of tracked test databases are rejected.
+	_, _, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.ErrorMatches, `TestDBNameFunc returned "name_func_testtestdbnamefunc_0", which is already in use: database already exists`)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseAlreadyExists), qt.IsTrue)
+
+	// Once dropped, the name can be used again.
+	c.Assert(tm.DropTestDatabase(ctx, "name_func_testtestdbnamefunc_1"), qt.IsNil)
+	_, testDBName, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBName, qt.Equals, "name_func_testtestdbnamefunc_1")
+}
+
+// TestUseRandomNames tests generating random test database names.
+func TestUseRandomNames(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "template_random_names",
+		TestDBPrefix:       "random_",
+		UseRandomNames:     true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		conn, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(dbName, qt.Matches, `random_[0-9a-f]{8}`)
+		c.Assert(seen[dbName], qt.IsFalse, qt.Commentf("duplicate name %q", dbName))
+		seen[dbName] = true
+	}
+
+	c.Run("With TestDBNameFunc", func(c *qt.C) {
+		_, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: setupTestConnectionProvider(),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			UseRandomNames:     true,
+			TestDBNameFunc:     func() string { return "name" },
+		})
+		c.Assert(err, qt.ErrorMatches, "UseRandomNames cannot be used together with TestDBNameFunc")
+	})
+
+	c.Run("Tracked names are generated again", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: setupTestConnectionProvider(),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "template_random_duplicates",
+			TestDBPrefix:       "random_duplicate_",
+			UseRandomNames:     true,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+		suffixes := [][]byte{{0, 0, 0, 1}, {0, 0, 0, 1}, {0, 0, 0, 2}}
+		pgdbtemplate.SetRandReadForTesting(tm, func(b []byte) (int, error) {
+			suffix := []byte{0, 0, 0, 1}
+			if len(suffixes) > 0 {
+				suffix, suffixes = suffixes[0], suffixes[1:]
+			}
+			return copy(b, suffix), nil
+		})
+
+		_, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(dbName, qt.Equals, "random_duplicate_00000001")
+		_, dbName, err = tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(dbName, qt.Equals, "random_duplicate_00000002")
+
+		// Only tracked names are generated from now on.
+		_, _, err = tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.ErrorMatches, "failed to generate random test database name after 10 attempts: .*")
+		c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseAlreadyExists), qt.IsTrue)
+	})
+}
+
+// TestResetCountersForTesting tests that generated names use the counter 1
+// after resetting the counters. It is not parallel, as other tests
+// generate names concurrently.
+func TestResetCountersForTesting(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	pgdbtemplate.ResetCountersForTesting()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.TemplateName(), qt.Matches, `template_db_\d+_1`)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBName, qt.Matches, tm.TestDBPrefix()+`\d+_1`)
+	_, testDBName, err = tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBName, qt.Matches, tm.TestDBPrefix()+`\d+_2`)
+}
+
+// TestClock tests that generated names are predictable with a fake clock.
+// It is not parallel, as it resets the counters of generated names.
+func TestClock(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	pgdbtemplate.ResetCountersForTesting()
+	clock := fixedClock{now: time.Unix(1700000000, 42)}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		Clock:              clock,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.TemplateName(), qt.Equals, "template_db_1700000000000000042_1")
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(testDBName, qt.Equals, tm.TestDBPrefix()+"1700000000000000042_1")
+}
+
+// fixedClock is a pgdbtemplate.Clock always telling the same time.
+type fixedClock struct {
+	now time.Time
+}
+
+// Now implements pgdbtemplate.Clock.Now.
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// TestInitializeConcurrentManagers tests that a manager losing the race
+// to create a shared template database waits for it and reuses it.
+func TestInitializeConcurrentManagers(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	const templateName = "concurrent_managers_template"
+	createQuery := fmt.Sprintf("CREATE DATABASE %q", templateName)
+	connProvider := setupTestConnectionProvider()
+
+	// The steps are ordered as follows:
+	//  1. the loser finds no template;
+	//  2. the winner creates the template and starts migrating it;
+	//  3. the loser starts waiting for the winner's lock on the template;
+	//  4. the winner finishes migrating and marks the template.
+	loserChecked := make(chan struct{})
+	winnerMigrating := make(chan struct{})
+	loserLocking := make(chan struct{})
+	var loserCheckedOnce sync.Once
+
+	loserMigrator := &countingMigrationRunner{}
+	loser, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeQueryRow: func(query string) pgdbtemplate.Row {
+				loserCheckedOnce.Do(func() { close(loserChecked) })
+				return nil
+			},
+			beforeExec: func(_ context.Context, query string) error {
+				switch {
+				case strings.Contains(query, "pg_advisory_lock"):
+					<-winnerMigrating
+					close(loserLocking)
+				case query == createQuery:
+					c.Errorf("unexpected query %q after the winner created the template", query)
+				}
+				return nil
+			},
+		},
+		MigrationRunner: loserMigrator,
+		TemplateName:    templateName,
+	})
+	c.Assert(err, qt.IsNil)
+
+	winner, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: connProvider,
+		MigrationRunner: migrationRunnerFunc(func(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+			close(winnerMigrating)
+			<-loserLocking
+			return nil
+		}),
+		TemplateName: templateName,
+	})
+	c.Assert(err, qt.IsNil)
+
+	loserErr := make(chan error, 1)
+	go func() { loserErr <- loser.Initialize(ctx) }()
+	<-loserChecked
+
+	c.Assert(winner.Initialize(ctx), qt.IsNil)
+	c.Assert(<-loserErr, qt.IsNil)
+	c.Assert(loserMigrator.count(), qt.Equals, int64(0))
+
+	// Both managers can use the template, which the winner drops.
+	_, testDBName, err := loser.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(loser.DropTestDatabase(ctx, testDBName), qt.IsNil)
+	_, _, err = winner.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(winner.Cleanup(ctx), qt.IsNil)
+}
+
+// TestInitializeConcurrentManagersDuringMigrations tests that a manager
+// finding the shared template database while another manager is still
+// migrating it waits for it and reuses it.
+func TestInitializeConcurrentManagersDuringMigrations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		forceRecreate bool
+	}{{
+		name: "wait",
+	}, {
+		name:          "force recreate waits",
+		forceRecreate: true,
+	}}
+
+	for i, test := range tests {
+		i, test := i, test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			c := qt.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			templateName := fmt.Sprintf("migrating_managers_template_%d", i)
+			connProvider := setupTestConnectionProvider()
+
+			// The steps are ordered as follows:
+			//  1. the winner creates the template and starts migrating it;
+			//  2. the loser finds the unmarked template and starts waiting
+			//     for the winner's lock on the template;
+			//  3. the winner finishes migrating and marks the template.
+			winnerMigrating := make(chan struct{})
+			loserWaiting := make(chan struct{})
+
+			loserMigrator := &countingMigrationRunner{}
+			loser, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: &hookedConnectionProvider{
+					ConnectionProvider: connProvider,
+					beforeExec: func(_ context.Context, query string) error {
+						if strings.Contains(query, "pg_advisory_lock") {
+							close(loserWaiting)
+						}
+						if strings.HasSuffix(query, fmt.Sprintf("DATABASE %q", templateName)) {
+							c.Errorf("unexpected query %q while the template is being migrated", query)
+						}
+						return nil
+					},
+				},
+				MigrationRunner: loserMigrator,
+				TemplateName:    templateName,
+				ForceRecreate:   test.forceRecreate,
+			})
+			c.Assert(err, qt.IsNil)
+
+			winner, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: connProvider,
+				MigrationRunner: migrationRunnerFunc(func(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+					close(winnerMigrating)
+					<-loserWaiting
+					_, err := conn.ExecContext(ctx, "CREATE TABLE users (id INT)")
+					return err
+				}),
+				TemplateName: templateName,
+			})
+			c.Assert(err, qt.IsNil)
+
+			winnerErr := make(chan error, 1)
+			go func() { winnerErr <- winner.Initialize(ctx) }()
+			<-winnerMigrating
+
+			c.Assert(loser.Initialize(ctx), qt.IsNil)
+			c.Assert(<-winnerErr, qt.IsNil)
+			c.Assert(loserMigrator.count(), qt.Equals, int64(0))
+
+			// The test databases contain the winner's migrations.
+			conn, testDBName, err := loser.CreateTestDatabase(ctx)
+			c.Assert(err, qt.IsNil)
+			_, err = conn.ExecContext(ctx, "INSERT INTO users VALUES (1)")
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+			c.Assert(loser.DropTestDatabase(ctx, testDBName), qt.IsNil)
+			c.Assert(winner.Cleanup(ctx), qt.IsNil)
+		})
+	}
+}
+
+// TestInitializeTemplateWaitTimeout tests that Initialize stops waiting
+// for a template database created by a manager which crashed
+// before marking it as a template.
+func TestInitializeTemplateWaitTimeout(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	const templateName = "wait_timeout_template"
+	createQuery := fmt.Sprintf("CREATE DATABASE %q", templateName)
+	connProvider := setupTestConnectionProvider()
+
+	crashed := false
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeExec: func(_ context.Context, query string) error {
+				if query != createQuery || crashed {
+					return nil
+				}
+				crashed = true
+				// The crashed manager created the template right after
+				// the check, but never marked it.
+				conn, err := connProvider.Connect(ctx, "postgres")
+				c.Check(err, qt.IsNil)
+				_, err = conn.ExecContext(ctx, createQuery)
+				c.Check(err, qt.IsNil)
+				return nil
+			},
+		},
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        templateName,
+		TemplateWaitTimeout: 100 * time.Millisecond,
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, `failed to create template database: timed out after 100ms waiting for `+
+		`the concurrently created template database "wait_timeout_template" to be marked as a template, `+
+		`drop it if the manager creating it crashed: context deadline exceeded`)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), qt.IsTrue)
+
+	// The error is not cached, so Initialize succeeds
+	// once the abandoned database is dropped.
+	adminConn, err := connProvider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	_, err = adminConn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE %q", templateName))
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+// TestInitializeTemplateLockTimeout tests that Initialize stops waiting
+// for another manager holding the lock on creating the template.
+func TestInitializeTemplateLockTimeout(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	connProvider := setupTestConnectionProvider()
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:  connProvider,
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "lock_timeout_template",
+		TemplateWaitTimeout: 100 * time.Millisecond,
+	})
+	c.Assert(err, qt.IsNil)
+
+	// Another manager is creating the template.
+	otherConn, err := connProvider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	_, err = otherConn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext('pgdbtemplate:lock_timeout_template'))")
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.ErrorMatches, `failed to create template database: timed out after 100ms waiting for `+
+		`another manager to create the template database "lock_timeout_template": context deadline exceeded`)
+	c.Assert(databaseExists(ctx, connProvider, "lock_timeout_template"), qt.IsFalse)
+
+	// The error is not cached, so Initialize succeeds
+	// once the other manager is done.
+	_, err = otherConn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext('pgdbtemplate:lock_timeout_template'))")
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+}
+
+// migrationRunnerFunc adapts a function to pgdbtemplate.MigrationRunner.
+type migrationRunnerFunc func(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error
+
+// RunMigrations implements pgdbtemplate.MigrationRunner.RunMigrations.
+func (f migrationRunnerFunc) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	return f(ctx, conn)
+}
+
+// TestExtensions tests that extensions are created in order
+// on the template database before migrations, or on every
+// test database with DialectCockroach.
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id uuid DEFAULT uuid_generate_v4())"),
+		TemplateName:       "extensions_template",
+		Extensions:         []string{"uuid-ossp", "pgcrypto"},
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`CREATE DATABASE "extensions_template"`,
+		`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`,
+		`CREATE EXTENSION IF NOT EXISTS "pgcrypto"`,
+		"CREATE TABLE users (id uuid DEFAULT uuid_generate_v4())",
+		`ALTER DATABASE "extensions_template" WITH is_template TRUE`,
+	})
+	c.Assert(provider.recordedQueriesOn("extensions_template"), qt.DeepEquals, []string{
+		`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`,
+		`CREATE EXTENSION IF NOT EXISTS "pgcrypto"`,
+		"CREATE TABLE users (id uuid DEFAULT uuid_generate_v4())",
+	})
+
+	// Test databases are copied with the extensions.
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(provider.recordedQueriesOn(testDBName), qt.HasLen, 0)
+
+	c.Run("Cockroach", func(c *qt.C) {
+		provider := &recordingConnectionProvider{
+			ConnectionProvider: cockroachProvider(setupTestConnectionProvider()),
+		}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    pgdbtemplate.NewSQLMigrationRunner("CREATE TABLE users (id uuid DEFAULT uuid_generate_v4())"),
+			TemplateName:       "extensions_cockroach_template",
+			Extensions:         []string{"uuid-ossp"},
+			Dialect:            pgdbtemplate.DialectCockroach,
+		})
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+		c.Assert(provider.recordedConnects(), qt.Not(qt.Contains), "extensions_cockroach_template")
+
+		_, testDBName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(provider.recordedQueriesOn(testDBName), qt.DeepEquals, []string{
+			`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`,
+			"CREATE TABLE users (id uuid DEFAULT uuid_generate_v4())",
+		})
+	})
+
+	c.Run("Error", func(c *qt.C) {
+		connProvider := setupTestConnectionProvider()
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: &hookedConnectionProvider{
+				ConnectionProvider: connProvider,
+				beforeExec:         failingExec(`CREATE EXTENSION IF NOT EXISTS "postgis"`),
+			},
+			MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:    "extensions_error_template",
+			Extensions:      []string{"postgis"},
+		})
+		c.Assert(err, qt.IsNil)
+
+		err = tm.Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, `failed to create template database: failed to create extensions on template: `+
+			`extension "postgis": exec error`)
+		c.Assert(databaseExists(ctx, connProvider, "extensions_error_template"), qt.IsFalse)
+	})
+}
+
+// TestTemplateVerifyQuery tests that a failing verification query
+// drops the template database.
+func TestTemplateVerifyQuery(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	newManager := func(c *qt.C, provider pgdbtemplate.ConnectionProvider, verifyQuery string) *pgdbtemplate.TemplateManager {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:  provider,
+			MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:        "verified_template",
+			TemplateVerifyQuery: verifyQuery,
+		})
+		c.Assert(err, qt.IsNil)
+		return tm
+	}
+
+	c.Run("Success", func(c *qt.C) {
+		provider := pgdbtemplatetest.NewFakeConnectionProvider()
+		tm := newManager(c, provider, "SELECT current_database()")
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Assert(provider.IsTemplate("verified_template"), qt.IsTrue)
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+
+	c.Run("Failure", func(c *qt.C) {
+		provider := pgdbtemplatetest.NewFakeConnectionProvider()
+		tm := newManager(c, provider, "SELECT count(*) FROM schema_migrations")
+		err := tm.Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, `failed to create template database: failed to verify template: `+
+			`query "SELECT count\(\*\) FROM schema_migrations" failed: pgdbtemplatetest: unsupported query .*`)
+		c.Assert(provider.DatabaseExists("verified_template"), qt.IsFalse)
+	})
+
+	c.Run("Multiple columns", func(c *qt.C) {
+		connProvider := setupTestConnectionProvider()
+		const verifyQuery = "SELECT count(*), max(version) FROM schema_migrations"
+		tm := newManager(c, &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeQueryRow: func(query string) pgdbtemplate.Row {
+				if query == verifyQuery {
+					return &sharedMockRow{data: []any{3, "003_orders"}}
+				}
+				return nil
+			},
+		}, verifyQuery)
+		err := tm.Initialize(ctx)
+		c.Assert(err, qt.ErrorMatches, `failed to create template database: failed to verify template: `+
+			`query "SELECT count\(\*\), max\(version\) FROM schema_migrations" failed: `+
+			`sql: expected 2 destination arguments in Scan, not 1`)
+		c.Assert(databaseExists(ctx, connProvider, "verified_template"), qt.IsFalse)
+	})
+
+	c.Run("Cockroach", func(c *qt.C) {
+		provider := pgdbtemplatetest.NewFakeConnectionProvider()
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:  provider,
+			MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:        "verified_cockroach_template",
+			TemplateVerifyQuery: "SELECT count(*) FROM schema_migrations",
+			Dialect:             pgdbtemplate.DialectCockroach,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		// The query runs on every test database instead of the template.
+		_, _, err = tm.CreateTestDatabase(ctx, "verified_cockroach_db")
+		c.Assert(err, qt.ErrorMatches, `failed to verify test database "verified_cockroach_db": `+
+			`query "SELECT count\(\*\) FROM schema_migrations" failed: pgdbtemplatetest: unsupported query .*`)
+		c.Assert(provider.DatabaseExists("verified_cockroach_db"), qt.IsFalse)
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+}
+
+// TestPostMigrationSQL tests that PostMigrationSQL is executed
+// on the template after migrations and before marking it.
+func TestPostMigrationSQL(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	migrator := migrationRunnerFunc(func(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+		_, err := conn.ExecContext(ctx, "CREATE TABLE users (id INT)")
+		return err
+	})
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    migrator,
+		TemplateName:       "post_migration_template",
+		PostMigrationSQL:   []string{"VACUUM", "ANALYZE"},
+	})
 	c.Assert(err, qt.IsNil)
 
-	// Initialize the template so DropTestDatabase can work.
-	err = tm.Initialize(ctx)
-	c.Assert(err, qt.IsNil)
-	defer func() {
-		c.Assert(tm.Cleanup(ctx), qt.IsNil)
-	}()
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	// Try to drop a non-existent database - this should error since
-	// DROP DATABASE is used (not IF EXISTS).
-	err = tm.DropTestDatabase(ctx, "non_existent_db_12345")
-	c.Assert(err, qt.ErrorMatches, ".*does not exist.*")
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`CREATE DATABASE "post_migration_template"`,
+		"CREATE TABLE users (id INT)",
+		"VACUUM",
+		"ANALYZE",
+		`ALTER DATABASE "post_migration_template" WITH is_template TRUE`,
+	})
 }
 
-// TestCreateTestDatabaseWithExistingName tests creating a database with an existing name.
-func TestCreateTestDatabaseWithExistingName(t *testing.T) {
+// TestPostMigrationSQLError tests that the template is dropped
+// if a post-migration statement fails.
+func TestPostMigrationSQLError(t *testing.T) {
 	t.Parallel()
 	c := qt.New(t)
-	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
-	defer cancel()
+	ctx := context.Background()
 
 	connProvider := setupTestConnectionProvider()
-	migrationRunner := setupTestMigrationRunner(c)
-
-	config := pgdbtemplate.Config{
-		ConnectionProvider: connProvider,
-		MigrationRunner:    migrationRunner,
-		TemplateName:       fmt.Sprintf("existing_name_template_%d_%d", time.Now().UnixNano(), os.Getpid()),
+	provider := &recordingConnectionProvider{
+		ConnectionProvider: &hookedConnectionProvider{
+			ConnectionProvider: connProvider,
+			beforeExec:         failingExec("ANALYZE"),
+		},
 	}
-
-	tm, err := pgdbtemplate.NewTemplateManager(config)
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "post_migration_error_template",
+		PostMigrationSQL:   []string{"VACUUM", "ANALYZE", "CHECKPOINT"},
+	})
 	c.Assert(err, qt.IsNil)
 
 	err = tm.Initialize(ctx)
-	c.Assert(err, qt.IsNil)
-	defer func() {
-		c.Assert(tm.Cleanup(ctx), qt.IsNil)
-	}()
-
-	// Create first database with custom name.
-	testDBName := fmt.Sprintf("existing_test_%d_%d", time.Now().UnixNano(), os.Getpid())
-	testDB1, _, err := tm.CreateTestDatabase(ctx, testDBName)
-	c.Assert(err, qt.IsNil)
-	c.Assert(testDB1.Close(), qt.IsNil)
-
-	// Try to create second database with same name -
-	// should fail with "already exists" error.
-	_, _, err = tm.CreateTestDatabase(ctx, testDBName)
-	c.Assert(err, qt.ErrorMatches, ".*already exists.*")
+	c.Assert(err, qt.ErrorMatches, `failed to create template database: failed to run post-migration SQL on template: statement 2 \("ANALYZE"\) failed: exec error`)
+	c.Assert(databaseExists(ctx, connProvider, "post_migration_error_template"), qt.IsFalse)
+
+	// Statements after the failing one are not executed.
+	c.Assert(provider.recordedQueries(), qt.DeepEquals, []string{
+		`CREATE DATABASE "post_migration_error_template"`,
+		"VACUUM",
+		"ANALYZE",
+		`DROP DATABASE "post_migration_error_template"`,
+	})
 }
 
-// TestTemplateManagerCleanupErrorPaths tests error handling in Cleanup
-// when DROP DATABASE fails.
-func TestTemplateManagerCleanupErrorPaths(t *testing.T) {
+// TestAnalyzeTestDatabases tests running ANALYZE on new test databases.
+func TestAnalyzeTestDatabases(t *testing.T) {
 	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
-	config := pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failDrop: true},
-		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "template_cleanup_error",
-		TestDBPrefix:       "test_template_cleanup_error_",
-	}
-
-	tm, err := pgdbtemplate.NewTemplateManager(config)
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:   provider,
+		MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:         "analyze_template",
+		AnalyzeTestDatabases: true,
+	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	// Simulate initialization and creation of test DBs.
-	err = tm.Initialize(ctx)
+	_, _, err = tm.CreateTestDatabase(ctx, "analyze_db")
 	c.Assert(err, qt.IsNil)
+	queries := provider.recordedQueries()
+	c.Assert(queries[len(queries)-2:], qt.DeepEquals, []string{
+		`CREATE DATABASE "analyze_db" TEMPLATE "analyze_template"`,
+		"ANALYZE",
+	})
 
-	err = tm.Initialize(ctx) // Should be no-op on re-initialization.
-	c.Assert(err, qt.IsNil)
+	c.Run("Disabled by default", func(c *qt.C) {
+		provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "analyze_disabled_template",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	_, _, err = tm.CreateTestDatabase(ctx)
-	c.Assert(err, qt.IsNil)
+		_, _, err = tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(provider.recordedQueries(), qt.Not(qt.Contains), "ANALYZE")
+	})
 
-	// Cleanup should fail due to DROP DATABASE error.
-	err = tm.Cleanup(ctx)
-	c.Assert(err, qt.ErrorMatches, "(?s).*drop error.*drop error.*")
+	c.Run("ANALYZE fails", func(c *qt.C) {
+		connProvider := setupTestConnectionProvider()
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: &hookedConnectionProvider{
+				ConnectionProvider: connProvider,
+				beforeExec:         failingExec("ANALYZE"),
+			},
+			MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:         "analyze_failing_template",
+			AnalyzeTestDatabases: true,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	// Note: Using mock provider, no real databases created - cleanup not needed.
+		_, _, err = tm.CreateTestDatabase(ctx, "analyze_failing_db")
+		c.Assert(err, qt.ErrorMatches, `failed to analyze test database "analyze_failing_db": exec error`)
+		c.Assert(databaseExists(ctx, connProvider, "analyze_failing_db"), qt.IsFalse)
+	})
 }
 
-// TestTemplateManagerDropTestDatabaseErrorPaths tests error handling in DropTestDatabase
-// when pg_terminate_backend and DROP DATABASE fail.
-func TestTemplateManagerDropTestDatabaseErrorPaths(t *testing.T) {
+// TestSkipTemplateMarking tests using the template
+// as an ordinary database to copy from.
+func TestSkipTemplateMarking(t *testing.T) {
+	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
-	config := pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failTerminate: true, failDrop: true},
-		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "template_drop_error",
-		TestDBPrefix:       "test_drop_error_",
-	}
-
-	tm, err := pgdbtemplate.NewTemplateManager(config)
+	fake := pgdbtemplatetest.NewFakeConnectionProvider()
+	provider := &recordingConnectionProvider{ConnectionProvider: fake}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:  provider,
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "unmarked_template",
+		SkipTemplateMarking: true,
+	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	c.Assert(fake.DatabaseExists("unmarked_template"), qt.IsTrue)
+	c.Assert(fake.IsTemplate("unmarked_template"), qt.IsFalse)
 
-	err = tm.Initialize(ctx)
+	_, dbName, err := tm.CreateTestDatabase(ctx, "unmarked_test_db")
 	c.Assert(err, qt.IsNil)
-
-	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(fake.DatabaseExists(dbName), qt.IsTrue)
+
+	// Another manager uses the existing database as the template.
+	other, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider:  fake,
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "unmarked_template",
+		SkipTemplateMarking: true,
+		AssumeTemplateReady: true,
+	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(other.Initialize(ctx), qt.IsNil)
 
-	// DropTestDatabase should fail due to terminate error (first step).
-	err = tm.DropTestDatabase(ctx, testDBName)
-	c.Assert(err, qt.ErrorMatches, ".*terminate error.*")
-
-	// Note: Using mock provider, no real databases created - cleanup not needed.
+	c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	c.Assert(fake.DatabaseExists("unmarked_template"), qt.IsFalse)
+	for _, query := range provider.recordedQueries() {
+		c.Assert(query, qt.Not(qt.Contains), "is_template")
+	}
 }
 
-func TestDropTemplateDatabaseConnectionError(t *testing.T) {
+// TestDropTestDatabaseWithCount tests that the number of terminated
+// connections is returned.
+func TestDropTestDatabaseWithCount(t *testing.T) {
+	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
+	connProvider := setupTestConnectionProvider()
+	var terminateQuery string
+	provider := &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		beforeQueryRow: func(query string) pgdbtemplate.Row {
+			if !strings.Contains(query, "pg_terminate_backend") {
+				return nil
+			}
+			terminateQuery = query
+			return &sharedMockRow{data: []any{3}}
+		},
+	}
 	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failConnect: true},
+		ConnectionProvider: provider,
 		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "template_db_conn_error",
+		TemplateName:       "terminate_count_template",
 	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	err = tm.Initialize(ctx)
-	c.Assert(err, qt.ErrorMatches, ".*connect error.*")
-
-	_, _, err = tm.CreateTestDatabase(ctx)
-	c.Assert(err, qt.ErrorMatches, ".*connect error.*")
+	_, dbName, err := tm.CreateTestDatabase(ctx, "terminate_count_db")
+	c.Assert(err, qt.IsNil)
 
-	err = tm.DropTestDatabase(ctx, "any_db")
-	c.Assert(err, qt.ErrorMatches, ".*connect error.*")
+	terminated, err := tm.DropTestDatabaseWithCount(ctx, dbName)
+	c.Assert(err, qt.IsNil)
+	c.Assert(terminated, qt.Equals, 3)
+	c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+	c.Assert(terminateQuery, qt.Contains, "datname IN ('terminate_count_db')")
+
+	// The count is returned even if the drop fails afterwards.
+	terminated, err = tm.DropTestDatabaseWithCount(ctx, dbName)
+	c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+	c.Assert(terminated, qt.Equals, 3)
 }
 
-func TestDropTemplateDatabaseUnmarkError(t *testing.T) {
+// TestDatabaseCloser tests that connections kept open to the template
+// by a pooling provider are closed before test databases are copied.
+func TestDatabaseCloser(t *testing.T) {
+	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
+	fake := pgdbtemplatetest.NewFakeConnectionProvider()
+	provider := &poolingProvider{FakeConnectionProvider: fake}
 	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failUnmark: true},
-		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "template_db_unmark_error",
+		ConnectionProvider: provider,
+		MigrationRunner:    pgdbtemplate.NewSQLMigrationRunner("SELECT 1"),
+		TemplateName:       "closer_template",
 	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	err = tm.Initialize(ctx)
-	c.Assert(err, qt.IsNil)
+	// The session running the migrations is kept open by the pool.
+	c.Assert(fake.OpenConnections("closer_template"), qt.Equals, 1)
 
-	err = tm.Cleanup(ctx)
-	c.Assert(err, qt.ErrorMatches, ".*unmark error.*")
+	for i := 0; i < 10; i++ {
+		_, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(fake.DatabaseExists(dbName), qt.IsTrue)
+	}
+	c.Assert(fake.OpenConnections("closer_template"), qt.Equals, 0)
+	c.Assert(provider.closedDatabases, qt.HasLen, 10)
+	for _, dbName := range provider.closedDatabases {
+		c.Assert(dbName, qt.Equals, "closer_template")
+	}
 
-	// Note: Using mock provider, no real databases created - cleanup not needed.
+	c.Run("Closing fails", func(c *qt.C) {
+		provider.closeErr = errors.New("pool is busy")
+		defer func() { provider.closeErr = nil }()
+
+		_, _, err := tm.CreateTestDatabase(ctx, "closer_failing_db")
+		c.Assert(err, qt.ErrorMatches, `failed to close connections to template "closer_template": pool is busy`)
+		c.Assert(fake.DatabaseExists("closer_failing_db"), qt.IsFalse)
+	})
 }
 
-func TestDropTemplateDatabaseQueryRowError(t *testing.T) {
+// poolingProvider wraps a FakeConnectionProvider, keeping connections
+// open when they are closed, like a pool per database.
+type poolingProvider struct {
+	*pgdbtemplatetest.FakeConnectionProvider
+	closeErr error
+
+	mu              sync.Mutex
+	pools           map[string][]pgdbtemplate.DatabaseConnection
+	closedDatabases []string
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *poolingProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	conn, err := p.FakeConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pools == nil {
+		p.pools = make(map[string][]pgdbtemplate.DatabaseConnection)
+	}
+	p.pools[databaseName] = append(p.pools[databaseName], conn)
+	return pooledConnection{DatabaseConnection: conn}, nil
+}
+
+// CloseDatabase implements pgdbtemplate.DatabaseCloser.CloseDatabase.
+func (p *poolingProvider) CloseDatabase(databaseName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closeErr != nil {
+		return p.closeErr
+	}
+	p.closedDatabases = append(p.closedDatabases, databaseName)
+	var errs error
+	for _, conn := range p.pools[databaseName] {
+		errs = errors.Join(errs, conn.Close())
+	}
+	delete(p.pools, databaseName)
+	return errs
+}
+
+// pooledConnection is the connection of poolingProvider,
+// which is kept open when closed.
+type pooledConnection struct {
+	pgdbtemplate.DatabaseConnection
+}
+
+// Close implements pgdbtemplate.DatabaseConnection.Close.
+func (pooledConnection) Close() error {
+	return nil
+}
+
+// TestDropTestDatabaseConnectsToAdminDB tests that test databases are
+// dropped through the admin database, so that no session is opened
+// on the template while other test databases are created from it.
+func TestDropTestDatabaseConnectsToAdminDB(t *testing.T) {
+	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
+	provider := &recordingConnectionProvider{ConnectionProvider: setupTestConnectionProvider()}
 	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failQueryRow: true},
+		ConnectionProvider: provider,
 		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "template_db_queryrow_error",
+		TemplateName:       "drop_admin_template",
 	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	err = tm.Initialize(ctx)
-	c.Assert(err, qt.ErrorMatches, ".*queryrow error.*")
+	conn, dbName, err := tm.CreateTestDatabase(ctx, "drop_admin_db")
+	c.Assert(err, qt.IsNil)
+	c.Assert(conn.Close(), qt.IsNil)
+
+	connectsBefore := len(provider.recordedConnects())
+	c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+	c.Assert(provider.recordedConnects()[connectsBefore:], qt.DeepEquals, []string{tm.AdminDBName()})
 }
 
-func TestDropTemplateDatabaseBatchTerminateFailure(t *testing.T) {
+// TestSkipTerminateOnDrop tests that connections are only terminated
+// if dropping the database directly fails because it is being accessed.
+func TestSkipTerminateOnDrop(t *testing.T) {
+	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
+	connProvider := setupTestConnectionProvider()
+	// Databases in use fail to be dropped until connections
+	// to them are terminated.
+	var mu sync.Mutex
+	inUse := map[string]bool{}
+	terminates := 0
+	setInUse := func(dbName string) {
+		mu.Lock()
+		defer mu.Unlock()
+		inUse[dbName] = true
+	}
+	terminateCalls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return terminates
+	}
+	recorder := &recordingConnectionProvider{ConnectionProvider: &hookedConnectionProvider{
+		ConnectionProvider: connProvider,
+		beforeExec: func(_ context.Context, query string) error {
+			dbName := droppedDatabase(query)
+			mu.Lock()
+			defer mu.Unlock()
+			if inUse[dbName] {
+				return &mockPgError{
+					code:    "55006",
+					message: fmt.Sprintf("database %q is being accessed by other users", dbName),
+				}
+			}
+			return nil
+		},
+		beforeQueryRow: func(query string) pgdbtemplate.Row {
+			if !strings.Contains(query, "pg_terminate_backend") {
+				return nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			terminates++
+			terminated := 0
+			for dbName := range inUse {
+				if strings.Contains(query, pgdbtemplate.QuoteLiteral(dbName)) {
+					delete(inUse, dbName)
+					terminated++
+				}
+			}
+			return &sharedMockRow{data: []any{terminated}}
+		},
+	}}
 	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failTerminate: true},
-		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "template_batch_terminate_fail",
+		ConnectionProvider:  recorder,
+		MigrationRunner:     &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:        "skip_terminate_template",
+		SkipTerminateOnDrop: true,
 	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
 
-	err = tm.Initialize(ctx)
-	c.Assert(err, qt.IsNil)
-	_, _, err = tm.CreateTestDatabase(ctx)
-	c.Assert(err, qt.IsNil)
+	c.Run("No connections", func(c *qt.C) {
+		_, dbName, err := tm.CreateTestDatabase(ctx, "skip_terminate_closed_db")
+		c.Assert(err, qt.IsNil)
 
-	// Cleanup should report terminate error.
-	err = tm.Cleanup(ctx)
-	c.Assert(err, qt.ErrorMatches, `(?s).*terminate error.*`)
+		terminated, err := tm.DropTestDatabaseWithCount(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(terminated, qt.Equals, 0)
+		c.Assert(terminateCalls(), qt.Equals, 0)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+	})
 
-	// Note: Using mock provider, no real databases created - cleanup not needed.
+	c.Run("Fallback to terminating connections", func(c *qt.C) {
+		_, dbName, err := tm.CreateTestDatabase(ctx, "skip_terminate_open_db")
+		c.Assert(err, qt.IsNil)
+		setInUse(dbName)
+
+		terminated, err := tm.DropTestDatabaseWithCount(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(terminated, qt.Equals, 1)
+		c.Assert(terminateCalls(), qt.Equals, 1)
+		c.Assert(databaseExists(ctx, connProvider, dbName), qt.IsFalse)
+
+		queries := recorder.recordedQueries()
+		c.Assert(queries[len(queries)-2:], qt.DeepEquals, []string{
+			`DROP DATABASE "skip_terminate_open_db"`,
+			`DROP DATABASE "skip_terminate_open_db"`,
+		})
+	})
+
+	c.Run("Other errors are returned", func(c *qt.C) {
+		err := tm.DropTestDatabase(ctx, "skip_terminate_missing_db")
+		c.Assert(errors.Is(err, pgdbtemplate.ErrDatabaseDoesNotExist), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(terminateCalls(), qt.Equals, 1)
+	})
 }
 
-func TestInitializeCreateError(t *testing.T) {
+// TestVerifyTestDBConnection tests that a connection provider
+// ignoring the database name is caught when creating test databases.
+func TestVerifyTestDBConnection(t *testing.T) {
+	t.Parallel()
 	c := qt.New(t)
 	ctx := context.Background()
 
-	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
-		ConnectionProvider: &mockDropTemplateDBProvider{failCreate: true, nonExistentQueryRow: true},
-		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "fail_create_template",
+	connProvider := setupTestConnectionProvider()
+	newManager := func(c *qt.C, verify bool) *pgdbtemplate.TemplateManager {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:     &fixedDatabaseProvider{ConnectionProvider: connProvider, dbName: "postgres"},
+			MigrationRunner:        &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:           fmt.Sprintf("verify_conn_template_%v", verify),
+			TestDBPrefix:           fmt.Sprintf("verify_conn_%v_", verify),
+			VerifyTestDBConnection: verify,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		c.Cleanup(func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) })
+		return tm
+	}
+
+	c.Run("Connection to another database", func(c *qt.C) {
+		tm := newManager(c, true)
+		conn, dbName, err := tm.CreateTestDatabase(ctx, "verify_conn_wrong_db")
+		c.Assert(err, qt.ErrorMatches, `connection to test database "verify_conn_wrong_db" is connected to database "postgres": `+
+			`check that the connection provider connects to the requested database`)
+		c.Assert(conn, qt.IsNil)
+		c.Assert(dbName, qt.Equals, "")
+		c.Assert(databaseExists(ctx, connProvider, "verify_conn_wrong_db"), qt.IsFalse)
 	})
-	c.Assert(err, qt.IsNil)
 
-	err = tm.Initialize(ctx)
-	c.Assert(err, qt.ErrorMatches, ".*failed to create template database.*create error.*")
+	c.Run("Without verification", func(c *qt.C) {
+		tm := newManager(c, false)
+		conn, _, err := tm.CreateTestDatabase(ctx, "verify_conn_unchecked_db")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+	})
+
+	c.Run("Connection to the test database", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider:     connProvider,
+			MigrationRunner:        &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:           "verify_conn_template",
+			VerifyTestDBConnection: true,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+		conn, _, err := tm.CreateTestDatabase(ctx, "verify_conn_right_db")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+	})
 }
 
-func TestCleanupAdminConnectError(t *testing.T) {
-	c := qt.New(t)
+// fixedDatabaseProvider wraps a ConnectionProvider, always connecting
+// to dbName like a connStringFunc ignoring the database name would.
+type fixedDatabaseProvider struct {
+	pgdbtemplate.ConnectionProvider
+	dbName string
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *fixedDatabaseProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	return p.ConnectionProvider.Connect(ctx, p.dbName)
+}
+
+// BenchmarkDropTestDatabase compares dropping test databases
+// with and without terminating connections first.
+func BenchmarkDropTestDatabase(b *testing.B) {
 	ctx := context.Background()
 
+	for _, skipTerminate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("SkipTerminateOnDrop=%v", skipTerminate), func(b *testing.B) {
+			// Simulate the network round trip of every query.
+			roundTrip := func() { time.Sleep(100 * time.Microsecond) }
+			tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+				ConnectionProvider: &hookedConnectionProvider{
+					ConnectionProvider: setupTestConnectionProvider(),
+					beforeExec: func(context.Context, string) error {
+						roundTrip()
+						return nil
+					},
+					beforeQueryRow: func(string) pgdbtemplate.Row {
+						roundTrip()
+						return nil
+					},
+				},
+				MigrationRunner:      &pgdbtemplate.NoOpMigrationRunner{},
+				ReuseAdminConnection: true,
+				SkipTerminateOnDrop:  skipTerminate,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := tm.Initialize(ctx); err != nil {
+				b.Fatal(err)
+			}
+			defer tm.Cleanup(ctx)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				conn, dbName, err := tm.CreateTestDatabase(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				conn.Close()
+				b.StartTimer()
+
+				if err := tm.DropTestDatabase(ctx, dbName); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestTemplateManagerGetters tests that the getters return
+// the effective configuration.
+func TestTemplateManagerGetters(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
 	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
-		ConnectionProvider: &oneTimeFailProvider{},
+		ConnectionProvider: setupTestConnectionProvider(),
 		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
-		TemplateName:       "cleanup_admin_connect_error",
+		TemplateName:       "getters_template",
+		TestDBPrefix:       "getters_",
+		AdminDBName:        "getters_admin",
 	})
 	c.Assert(err, qt.IsNil)
+	c.Assert(tm.TemplateName(), qt.Equals, "getters_template")
+	c.Assert(tm.TestDBPrefix(), qt.Equals, "getters_")
+	c.Assert(tm.AdminDBName(), qt.Equals, "getters_admin")
 
-	err = tm.Initialize(ctx)
+	tm, err = pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+	})
 	c.Assert(err, qt.IsNil)
-
-	err = tm.Cleanup(ctx)
-	c.Assert(err, qt.ErrorMatches, "failed to connect to admin database:.*connect error for admin db")
-
-	// Note: Using mock provider, no real databases created - cleanup not needed.
+	c.Assert(tm.TemplateName(), qt.Matches, `template_db_\d+_\d+`)
+	c.Assert(tm.TestDBPrefix(), qt.Equals, "test_")
+	c.Assert(tm.AdminDBName(), qt.Equals, "postgres")
 }
 
 func setupTestConnectionProvider() pgdbtemplate.ConnectionProvider {
@@ -544,7 +2573,10 @@ func hasTestTable(ctx context.Context, conn pgdbtemplate.DatabaseConnection) boo
 type mockConnectionProvider struct {
 	connString string
 	databases  map[string]bool
+	templates  map[string]bool
 	mu         sync.RWMutex
+
+	advisoryLocks sync.Map
 }
 
 // getDatabases implements databaseProvider.getDatabases.
@@ -552,11 +2584,21 @@ func (m *mockConnectionProvider) getDatabases() map[string]bool {
 	return m.databases
 }
 
+// getTemplates implements databaseProvider.getTemplates.
+func (m *mockConnectionProvider) getTemplates() map[string]bool {
+	return m.templates
+}
+
 // getMutex implements databaseProvider.getMutex.
 func (m *mockConnectionProvider) getMutex() *sync.RWMutex {
 	return &m.mu
 }
 
+// getAdvisoryLocks implements databaseProvider.getAdvisoryLocks.
+func (m *mockConnectionProvider) getAdvisoryLocks() *sync.Map {
+	return &m.advisoryLocks
+}
+
 // NewMockConnectionProvider creates a new mock connection provider.
 func NewMockConnectionProvider() *mockConnectionProvider {
 	return &mockConnectionProvider{
@@ -565,6 +2607,10 @@ func NewMockConnectionProvider() *mockConnectionProvider {
 			"template0": true,
 			"template1": true,
 		},
+		templates: map[string]bool{
+			"template0": true,
+			"template1": true,
+		},
 	}
 }
 
@@ -671,13 +2717,20 @@ func (m *mockDropTemplateDBConnection) ExecContext(ctx context.Context, query st
 
 // QueryRowContext implements pgdbtemplate.DatabaseConnection.QueryRowContext.
 func (m *mockDropTemplateDBConnection) QueryRowContext(ctx context.Context, query string, args ...any) pgdbtemplate.Row {
+	if strings.Contains(query, "pg_terminate_backend") {
+		if m.failTerminate {
+			return &sharedMockRow{err: fmt.Errorf("terminate error")}
+		}
+		return &sharedMockRow{data: []any{0}}
+	}
 	if m.failQueryRow {
 		return &sharedMockRow{err: fmt.Errorf("queryrow error")}
 	}
 	if m.nonExistentQueryRow {
 		return &sharedMockRow{err: sql.ErrNoRows}
 	}
-	return &sharedMockRow{}
+	// The template database exists and is marked as a template.
+	return &sharedMockRow{data: []any{true}}
 }
 
 // Close implements pgdbtemplate.DatabaseConnection.Close.
@@ -0,0 +1,82 @@
+package pgdbtemplate
+
+import (
+	"context"
+	"time"
+)
+
+// Attribute keys set on spans started by the template manager.
+const (
+	// AttributeTemplateName holds the name of the template database.
+	AttributeTemplateName = "pgdbtemplate.template_name"
+	// AttributeDatabaseName holds the name of the test database
+	// the operation works on.
+	AttributeDatabaseName = "pgdbtemplate.database_name"
+	// AttributeDuration holds the wall-clock duration of the operation.
+	AttributeDuration = "pgdbtemplate.duration"
+)
+
+// Attribute is a key-value pair attached to a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Tracer starts spans around template manager operations.
+//
+// The interface is intentionally small, so that tracing backends such as
+// OpenTelemetry can be adapted to it without the core package depending
+// on them.
+type Tracer interface {
+	// Start starts a span for the named operation with the given attributes.
+	//
+	// The returned context is passed down to the connection provider
+	// and the migration runner.
+	Start(ctx context.Context, operation string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a Tracer.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...Attribute)
+	// End finishes the span, recording err on it if it is not nil.
+	End(err error)
+}
+
+// operationSpan wraps a Span together with the start time
+// of the traced operation.
+//
+// A nil *operationSpan is valid and does nothing, which is what
+// the template manager uses when no Tracer is configured.
+type operationSpan struct {
+	span  Span
+	clock Clock
+	start time.Time
+}
+
+// startSpan starts a span for the operation if a Tracer is configured.
+func (tm *TemplateManager) startSpan(ctx context.Context, operation string, attrs ...Attribute) (context.Context, *operationSpan) {
+	if tm.tracer == nil {
+		return ctx, nil
+	}
+	attrs = append([]Attribute{{Key: AttributeTemplateName, Value: tm.templateName}}, attrs...)
+	ctx, span := tm.tracer.Start(ctx, operation, attrs...)
+	return ctx, &operationSpan{span: span, clock: tm.clock, start: tm.clock.Now()}
+}
+
+// setAttributes adds attributes to the span.
+func (s *operationSpan) setAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+}
+
+// end records the duration of the operation and finishes the span.
+func (s *operationSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(Attribute{Key: AttributeDuration, Value: s.clock.Now().Sub(s.start).String()})
+	s.span.End(err)
+}
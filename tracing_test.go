@@ -0,0 +1,109 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestTracerSpans verifies that manager operations are wrapped in spans.
+func TestTracerSpans(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tracer := &recordingTracer{}
+	config := pgdbtemplate.Config{
+		ConnectionProvider: setupTestConnectionProvider(),
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "tracing_template",
+		Tracer:             tracer,
+	}
+
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.Initialize(ctx)
+	c.Assert(err, qt.IsNil)
+
+	_, testDBName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+
+	err = tm.DropTestDatabase(ctx, testDBName)
+	c.Assert(err, qt.IsNil)
+
+	// Dropping a non-existent database records the error on the span.
+	err = tm.DropTestDatabase(ctx, "tracing_missing_db")
+	c.Assert(err, qt.IsNotNil)
+
+	err = tm.Cleanup(ctx)
+	c.Assert(err, qt.IsNil)
+
+	spans := tracer.finished()
+	c.Assert(spans, qt.HasLen, 5)
+
+	wantOperations := []string{
+		"pgdbtemplate.Initialize",
+		"pgdbtemplate.CreateTestDatabase",
+		"pgdbtemplate.DropTestDatabase",
+		"pgdbtemplate.DropTestDatabase",
+		"pgdbtemplate.Cleanup",
+	}
+	for i, span := range spans {
+		c.Assert(span.operation, qt.Equals, wantOperations[i])
+		c.Assert(span.attrs[pgdbtemplate.AttributeTemplateName], qt.Equals, "tracing_template")
+		c.Assert(span.attrs[pgdbtemplate.AttributeDuration], qt.Not(qt.Equals), "")
+	}
+	c.Assert(spans[1].attrs[pgdbtemplate.AttributeDatabaseName], qt.Equals, testDBName)
+	c.Assert(spans[2].attrs[pgdbtemplate.AttributeDatabaseName], qt.Equals, testDBName)
+	c.Assert(spans[2].err, qt.IsNil)
+	c.Assert(spans[3].attrs[pgdbtemplate.AttributeDatabaseName], qt.Equals, "tracing_missing_db")
+	c.Assert(spans[3].err, qt.ErrorMatches, ".*does not exist.*")
+}
+
+// recordingTracer is an in-memory pgdbtemplate.Tracer.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+// Start implements pgdbtemplate.Tracer.Start.
+func (t *recordingTracer) Start(ctx context.Context, operation string, attrs ...pgdbtemplate.Attribute) (context.Context, pgdbtemplate.Span) {
+	span := &recordedSpan{tracer: t, operation: operation, attrs: map[string]string{}}
+	span.SetAttributes(attrs...)
+	return ctx, span
+}
+
+// finished returns the ended spans in the order they were ended.
+func (t *recordingTracer) finished() []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*recordedSpan(nil), t.spans...)
+}
+
+// recordedSpan is a span recorded by recordingTracer.
+type recordedSpan struct {
+	tracer    *recordingTracer
+	operation string
+	attrs     map[string]string
+	err       error
+}
+
+// SetAttributes implements pgdbtemplate.Span.SetAttributes.
+func (s *recordedSpan) SetAttributes(attrs ...pgdbtemplate.Attribute) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+// End implements pgdbtemplate.Span.End.
+func (s *recordedSpan) End(err error) {
+	s.err = err
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
@@ -0,0 +1,61 @@
+package pgdbtemplate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// CreateTestTransaction creates a test database and begins a transaction
+// on it, e.g. for tests rolling back their changes. The returned cleanup
+// function rolls the transaction back, closes the connection and drops
+// the test database.
+//
+// The connections of the ConnectionProvider must unwrap to a *sql.DB,
+// as those of the pgdbtemplate-pq provider do. See Unwrapper.
+func (tm *TemplateManager) CreateTestTransaction(ctx context.Context) (_ *sql.Tx, _ func() error, err error) {
+	conn, dbName, err := tm.CreateTestDatabase(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Should any further steps fail, ensure we drop the test database.
+	release := func() error {
+		closeErr := conn.Close()
+		if closeErr != nil {
+			closeErr = fmt.Errorf("failed to close connection to test database %q: %w", dbName, closeErr)
+		}
+		return errors.Join(closeErr, tm.DropTestDatabase(context.Background(), dbName))
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, release())
+		}
+	}()
+
+	unwrapper, ok := conn.(Unwrapper)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection of type %T does not implement Unwrapper", conn)
+	}
+	db, ok := unwrapper.Unwrap().(*sql.DB)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection unwraps to %T instead of *sql.DB", unwrapper.Unwrap())
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction on test database %q: %w", dbName, err)
+	}
+
+	cleanup := func() error {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			rollbackErr = fmt.Errorf("failed to roll back transaction on test database %q: %w", dbName, rollbackErr)
+		} else {
+			rollbackErr = nil
+		}
+		return errors.Join(rollbackErr, release())
+	}
+	return tx, cleanup, nil
+}
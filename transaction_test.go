@@ -0,0 +1,195 @@
+package pgdbtemplate_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// TestCreateTestTransaction tests that both the transaction
+// and the test database are cleaned up.
+func TestCreateTestTransaction(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mockProvider := setupTestConnectionProvider()
+	provider := &sqlDBConnectionProvider{ConnectionProvider: mockProvider}
+	tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+		ConnectionProvider: provider,
+		MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		TemplateName:       "transaction_template",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+	defer func() { c.Assert(tm.Cleanup(ctx), qt.IsNil) }()
+
+	tx, cleanup, err := tm.CreateTestTransaction(ctx)
+	c.Assert(err, qt.IsNil)
+	_, err = tx.ExecContext(ctx, "INSERT INTO users VALUES (1)")
+	c.Assert(err, qt.IsNil)
+
+	dbNames := provider.openedDatabases()
+	c.Assert(dbNames, qt.HasLen, 1)
+	c.Assert(databaseExists(ctx, mockProvider, dbNames[0]), qt.IsTrue)
+
+	c.Assert(cleanup(), qt.IsNil)
+	c.Assert(provider.recordedStatements(), qt.DeepEquals, []string{"BEGIN", "INSERT INTO users VALUES (1)", "ROLLBACK"})
+	c.Assert(databaseExists(ctx, mockProvider, dbNames[0]), qt.IsFalse)
+	c.Assert(tx.Commit(), qt.Equals, sql.ErrTxDone)
+
+	c.Run("Transaction already done", func(c *qt.C) {
+		tx, cleanup, err := tm.CreateTestTransaction(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(tx.Commit(), qt.IsNil)
+		c.Assert(cleanup(), qt.IsNil)
+	})
+
+	c.Run("Connection without *sql.DB", func(c *qt.C) {
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: mockProvider,
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:       "transaction_plain_template",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		_, _, err = tm.CreateTestTransaction(ctx)
+		c.Assert(err, qt.ErrorMatches, `connection of type \*pgdbtemplate_test.sharedMockDatabaseConnection does not implement Unwrapper`)
+		// The test database is dropped.
+		c.Assert(tm.Cleanup(ctx), qt.IsNil)
+	})
+}
+
+// sqlDBConnectionProvider wraps a ConnectionProvider whose connections
+// unwrap to a *sql.DB using sqlDBConnector.
+type sqlDBConnectionProvider struct {
+	pgdbtemplate.ConnectionProvider
+
+	mu         sync.Mutex
+	databases  []string
+	statements []string
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+func (p *sqlDBConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.databases = append(p.databases, databaseName)
+	p.mu.Unlock()
+	return &sqlDBConnection{DatabaseConnection: conn, db: sql.OpenDB(sqlDBConnector{provider: p})}, nil
+}
+
+// openedDatabases returns the names of the databases connected to,
+// other than the admin and template databases.
+func (p *sqlDBConnectionProvider) openedDatabases() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var dbNames []string
+	for _, dbName := range p.databases {
+		if dbName != "postgres" && dbName != "transaction_template" {
+			dbNames = append(dbNames, dbName)
+		}
+	}
+	return dbNames
+}
+
+// recordedStatements returns the statements run through the *sql.DB.
+func (p *sqlDBConnectionProvider) recordedStatements() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.statements...)
+}
+
+// record records a statement run through the *sql.DB.
+func (p *sqlDBConnectionProvider) record(statement string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statements = append(p.statements, statement)
+}
+
+// sqlDBConnection is the connection of sqlDBConnectionProvider.
+type sqlDBConnection struct {
+	pgdbtemplate.DatabaseConnection
+	db *sql.DB
+}
+
+// Unwrap implements pgdbtemplate.Unwrapper.Unwrap.
+func (c *sqlDBConnection) Unwrap() any {
+	return c.db
+}
+
+// Close implements pgdbtemplate.DatabaseConnection.Close.
+func (c *sqlDBConnection) Close() error {
+	return errors.Join(c.db.Close(), c.DatabaseConnection.Close())
+}
+
+// sqlDBConnector is a driver.Connector recording the statements
+// run through the *sql.DB into its provider.
+type sqlDBConnector struct {
+	provider *sqlDBConnectionProvider
+}
+
+// Connect implements driver.Connector.Connect.
+func (c sqlDBConnector) Connect(context.Context) (driver.Conn, error) {
+	return &sqlDBDriverConn{provider: c.provider}, nil
+}
+
+// Driver implements driver.Connector.Driver.
+func (c sqlDBConnector) Driver() driver.Driver {
+	return nil
+}
+
+// sqlDBDriverConn is the driver.Conn of sqlDBConnector.
+type sqlDBDriverConn struct {
+	provider *sqlDBConnectionProvider
+}
+
+// Prepare implements driver.Conn.Prepare.
+func (c *sqlDBDriverConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+// Close implements driver.Conn.Close.
+func (c *sqlDBDriverConn) Close() error {
+	return nil
+}
+
+// Begin implements driver.Conn.Begin.
+func (c *sqlDBDriverConn) Begin() (driver.Tx, error) {
+	c.provider.record("BEGIN")
+	return sqlDBDriverTx{provider: c.provider}, nil
+}
+
+// ExecContext implements driver.ExecerContext.ExecContext.
+func (c *sqlDBDriverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.provider.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+// sqlDBDriverTx is the driver.Tx of sqlDBDriverConn.
+type sqlDBDriverTx struct {
+	provider *sqlDBConnectionProvider
+}
+
+// Commit implements driver.Tx.Commit.
+func (tx sqlDBDriverTx) Commit() error {
+	tx.provider.record("COMMIT")
+	return nil
+}
+
+// Rollback implements driver.Tx.Rollback.
+func (tx sqlDBDriverTx) Rollback() error {
+	tx.provider.record("ROLLBACK")
+	return nil
+}